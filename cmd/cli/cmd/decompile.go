@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/greg2010/ic11c/internal/ic11/decompiler"
+	"github.com/greg2010/ic11c/internal/printer"
+	"github.com/spf13/cobra"
+)
+
+var decompileOut string
+var decompileCmd = &cobra.Command{
+	Use:   "decompile file",
+	Short: "Translate an IC10 program to µC",
+	Long: `decompile translates a hand-written IC10 program to µC, so that it can be maintained with the compiler.
+Jumps are rebuilt as if and while statements, aliases become variables and device macros, and hashes of known prefabs
+are written with hash(). Instructions µC has no equivalent for are kept as inline assembly.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.SetOut(os.Stdout)
+		printer, err := printer.NewPrinter(cmd, verbose, diagnosticsFormat)
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+		if len(args) != 1 {
+			printer.PrintErrorln("decompile expects a single input file")
+			os.Exit(1)
+		}
+
+		src, err := os.ReadFile(args[0])
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+		decompiled, err := decompiler.Decompile(string(src))
+		if err != nil {
+			printErr(printer, err)
+			os.Exit(1)
+		}
+
+		if decompileOut == "" {
+			printer.Print(decompiled)
+			return
+		}
+		err = writeToFile(decompileOut, decompiled)
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(decompileCmd)
+	decompileCmd.Flags().StringVarP(&decompileOut, "out", "o", "", "Filename to write output to. Defaults to the standard output.")
+}
@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/greg2010/ic11c/internal/filereader"
 	"github.com/greg2010/ic11c/internal/ic11/compiler"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/ir"
+	"github.com/greg2010/ic11c/internal/ic11/preprocessor"
 	"github.com/greg2010/ic11c/internal/printer"
 	"github.com/spf13/cobra"
 )
@@ -18,39 +24,42 @@ var noComputeHashes bool
 var optimize int
 var verbose bool
 var out string
+var defines []string
+var includePaths []string
+var boundsCheck bool
+var warnings []string
+var diagnosticsFormat string
 var rootCmd = &cobra.Command{
 	Use:   "ic11c file1 file2",
 	Short: "A µC -> MIPS compiler",
 	Long: `ic11c is a compiler for µC to MIPS dialect used by the game Stationeers.
 run ic11c help for details on how to use it.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.SetOut(os.Stdout)
-		printer := printer.NewCobraPrinter(cmd, verbose)
-		if len(args) == 0 {
-			printer.PrintErrorln("no input files")
-			os.Exit(1)
-		}
-
-		reader, err := filereader.New(args...)
+		printer, err := printer.NewPrinter(cmd, verbose, diagnosticsFormat)
 		if err != nil {
 			printer.PrintErrorln(err)
 			os.Exit(1)
 		}
-		defer reader.Close()
-
-		//conf := getCompilerConfig(printer)
+		if len(args) == 0 {
+			printer.PrintErrorln("no input files")
+			os.Exit(1)
+		}
 
-		compiler, err := compiler.New(reader.GetReaders())
+		compiler, err := newCompiler(args)
 		if err != nil {
-			printer.PrintErrorf("parsing failed: %v\n", err)
+			printErr(printer, err)
 			os.Exit(1)
 		}
 
 		compiled, err := compiler.Compile()
 		if err != nil {
-			printer.PrintErrorf("compilation terminated due to error: %v\n", err)
+			printErr(printer, err)
 			os.Exit(1)
 		}
+		printer.PrintDiagnostics(compiler.Diagnostics())
+		printRewrites(printer, compiler)
 
 		err = writeToFile(out, compiled)
 		if err != nil {
@@ -62,6 +71,127 @@ run ic11c help for details on how to use it.`,
 	},
 }
 
+// newCompiler compiles files to IR with the options set by the flags.
+// .ic10 files are hand-written IC10 code, checked and emitted as is.
+func newCompiler(files []string) (*compiler.Compiler, error) {
+	reader, err := filereader.New(files...)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	ic10, err := isIC10(files)
+	if err != nil {
+		return nil, err
+	}
+
+	defineMap, err := parseDefines(defines)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnosticOpts, err := parseWarnings(warnings)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := compiler.Options{
+		Preprocessor: preprocessor.Config{
+			Defines:      defineMap,
+			IncludePaths: includePaths,
+		},
+		Frontend: ir.FrontendOptions{
+			BoundsCheck: boundsCheck,
+		},
+		Diagnostics: diagnosticOpts,
+		Optimize:    optimize,
+	}
+	if ic10 {
+		return compiler.NewIC10(reader.GetReaders(), opts)
+	}
+
+	return compiler.New(reader.GetReaders(), opts)
+}
+
+// isIC10 reports whether the files are IC10 code. µC and IC10 files can't be mixed.
+func isIC10(files []string) (bool, error) {
+	n := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, ".ic10") {
+			n++
+		}
+	}
+	if n > 0 && n < len(files) {
+		return false, errors.New("µC and IC10 files can't be compiled together")
+	}
+
+	return n > 0, nil
+}
+
+// printErr prints err. Errors of the compiler are printed as a list of diagnostics.
+func printErr(p printer.Printer, err error) {
+	var diags diagnostic.List
+	if errors.As(err, &diags) {
+		p.PrintDiagnostics(diags)
+		return
+	}
+
+	p.PrintErrorln(err)
+}
+
+// printRewrites prints the peephole rules applied to the code in verbose mode
+func printRewrites(p printer.Printer, c *compiler.Compiler) {
+	for _, rewrite := range c.Rewrites() {
+		p.PrintVerboseln("peephole:", rewrite)
+	}
+}
+
+// parseDefines converts NAME=value pairs passed with -D to a map. NAME alone defines the macro as 1.
+func parseDefines(defs []string) (map[string]string, error) {
+	defineMap := make(map[string]string)
+	for _, def := range defs {
+		name, value, found := strings.Cut(def, "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid macro definition %q", def)
+		}
+		if !found {
+			value = "1"
+		}
+		defineMap[name] = value
+	}
+
+	return defineMap, nil
+}
+
+// parseWarnings converts values passed with -W to diagnostic options.
+// -W<name> enables a warning, -Wno-<name> disables it, -Werror turns warnings into errors.
+func parseWarnings(values []string) (diagnostic.Options, error) {
+	opts := diagnostic.Options{Disabled: make(map[string]bool)}
+	for _, value := range values {
+		name, disable := strings.CutPrefix(value, "no-")
+		if name == "error" {
+			opts.WarningsAsErrors = !disable
+			continue
+		}
+		if !isWarning(name) {
+			return opts, fmt.Errorf("unknown warning %q, known warnings are: %s", name, strings.Join(ir.Warnings, ", "))
+		}
+		opts.Disabled[name] = disable
+	}
+
+	return opts, nil
+}
+
+func isWarning(name string) bool {
+	for _, warning := range ir.Warnings {
+		if warning == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func writeToFile(fname string, contents string) error {
 	file, err := os.Create(fname)
 	if err != nil {
@@ -133,7 +263,12 @@ func init() {
 	rootCmd.Flags().BoolVar(&noVarOpt, "no-var-opt", false, "Do not propagate known variables to reduce the number of register allocations.")
 	rootCmd.Flags().BoolVar(&noDeviceAliases, "no-device-aliases", false, "Do not emit device alias instructions.")
 	rootCmd.Flags().BoolVar(&noComputeHashes, "no-compute-hashes", false, "Do not precompute hashes at compile time.")
-	rootCmd.Flags().IntVarP(&optimize, "optimize", "O", 2, "Set optimization level preset. 0 -- no optimizations, 2 -- full optimization.")
-	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging.")
+	rootCmd.PersistentFlags().IntVarP(&optimize, "optimize", "O", 2, "Set optimization level preset. 0 -- no optimizations, 2 -- full optimization.")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging.")
 	rootCmd.Flags().StringVarP(&out, "out", "o", "a.out", "Filename to write output to.")
+	rootCmd.PersistentFlags().BoolVar(&boundsCheck, "bounds-check", false, "Halt the chip when an array index is out of bounds at runtime.")
+	rootCmd.PersistentFlags().StringArrayVarP(&includePaths, "include-path", "I", nil, "Add a directory to the list of directories searched for included files.")
+	rootCmd.PersistentFlags().StringArrayVarP(&warnings, "warning", "W", nil, "Enable a warning with -W<name>, disable it with -Wno-<name>. -Werror turns warnings into errors.")
+	rootCmd.PersistentFlags().StringVar(&diagnosticsFormat, "diagnostics-format", printer.FormatText, "Format of errors and warnings: text, json or sarif.")
+	rootCmd.PersistentFlags().StringArrayVarP(&defines, "define", "D", nil, "Define a preprocessor macro as NAME=value, or NAME to define it as 1.")
 }
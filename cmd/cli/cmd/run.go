@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+	"github.com/greg2010/ic11c/internal/printer"
+	"github.com/spf13/cobra"
+)
+
+// defaultTicks is the number of ticks run if neither the flag nor the scenario sets it
+const defaultTicks = 100
+
+var scenarioFile string
+var ticks int
+var runCmd = &cobra.Command{
+	Use:   "run file1 file2",
+	Short: "Compile a µC program and run it in the IC10 emulator",
+	Long: `run compiles a µC program and runs it in the IC10 emulator against the devices described by a scenario.
+Every value written to a device is printed, prefixed with the tick it was written at.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.SetOut(os.Stdout)
+		// The standard output holds the diagnostics document when it's structured, the trace goes to the standard error
+		trace := cmd.OutOrStdout()
+		if diagnosticsFormat != printer.FormatText {
+			trace = cmd.ErrOrStderr()
+		}
+		printer, err := printer.NewPrinter(cmd, verbose, diagnosticsFormat)
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			printer.PrintErrorln("no input files")
+			os.Exit(1)
+		}
+
+		scenario := &emulator.Scenario{}
+		if scenarioFile != "" {
+			f, err := os.Open(scenarioFile)
+			if err != nil {
+				printer.PrintErrorln(err)
+				os.Exit(1)
+			}
+			scenario, err = emulator.LoadScenario(f)
+			f.Close()
+			if err != nil {
+				printer.PrintErrorln(err)
+				os.Exit(1)
+			}
+		}
+
+		compiler, err := newCompiler(args)
+		if err != nil {
+			printErr(printer, err)
+			os.Exit(1)
+		}
+		asm, err := compiler.Assemble()
+		if err != nil {
+			printErr(printer, err)
+			os.Exit(1)
+		}
+		printer.PrintDiagnostics(compiler.Diagnostics())
+		printRewrites(printer, compiler)
+		printer.PrintVerbose(asm)
+
+		program, err := emulator.Parse(asm)
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+		chip := emulator.New(program)
+		err = scenario.Attach(chip)
+		if err != nil {
+			printer.PrintErrorln(err)
+			os.Exit(1)
+		}
+
+		n := ticks
+		if n == 0 {
+			n = scenario.Ticks
+		}
+		if n == 0 {
+			n = defaultTicks
+		}
+		runErr := scenario.Run(chip, n)
+		for _, write := range chip.Trace {
+			fmt.Fprintln(trace, write)
+		}
+		if runErr != nil {
+			printer.PrintErrorln(runErr)
+			os.Exit(1)
+		}
+		if chip.Halted {
+			printer.PrintVerbosef("halted at tick %d\n", chip.Ticks-1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().StringVarP(&scenarioFile, "scenario", "s", "", "YAML or JSON file describing the devices the program runs against.")
+	runCmd.Flags().IntVarP(&ticks, "ticks", "n", 0, "Number of ticks to run. Defaults to the ticks set by the scenario, or 100.")
+}
@@ -7,6 +7,7 @@ import (
 )
 
 // MultiFileReader opens multiple files on the filesystem
+// The readers implement Name() string, so that positions in compiler errors carry file names.
 type MultiFileReader struct {
 	readers    []io.Reader
 	closeFuncs []func() error
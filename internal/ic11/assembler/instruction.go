@@ -3,10 +3,16 @@ package assembler
 import (
 	"fmt"
 	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
 )
 
 type mipsInstruction interface {
 	String() string
+	// check checks the instruction against the opcode table, with names resolved by sc
+	check(sc scope) error
+	// lines returns the number of lines the instruction takes in a chip
+	lines() int
 }
 
 type mipsLabel struct {
@@ -17,13 +23,46 @@ func (l mipsLabel) String() string {
 	return fmt.Sprintf("%s:", l.label)
 }
 
+func (l mipsLabel) check(scope) error {
+	if !isName(l.label) {
+		return fmt.Errorf("%w: %s is not a label", ErrInvalidOperand, l.label)
+	}
+
+	return nil
+}
+
+func (l mipsLabel) lines() int {
+	return 1
+}
+
+// mipsRaw is a line of code copied verbatim from an inline assembly block
+type mipsRaw struct {
+	text string
+	// pos is the position of the inline assembly statement the line comes from
+	pos lexer.Position
+}
+
+func (r mipsRaw) String() string {
+	return r.text
+}
+
+func (r mipsRaw) check(scope) error {
+	return nil
+}
+
+func (r mipsRaw) lines() int {
+	return 1
+}
+
 type mipsInstructionN struct {
 	cmd  string
 	args []string
+	// volatile loads are never replaced by values loaded earlier
+	volatile bool
 }
 
 func newInstructionN(cmd string, args ...string) *mipsInstructionN {
-	return &mipsInstructionN{cmd, args}
+	return &mipsInstructionN{cmd: cmd, args: args}
 }
 
 func (in mipsInstructionN) String() string {
@@ -33,3 +72,7 @@ func (in mipsInstructionN) String() string {
 
 	return fmt.Sprintf("%s %s", in.cmd, strings.Join(in.args, " "))
 }
+
+func (in mipsInstructionN) lines() int {
+	return opcodes[in.cmd].lines
+}
@@ -3,7 +3,11 @@ package assembler
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
 	"github.com/greg2010/ic11c/internal/ic11/ir"
 	"github.com/greg2010/ic11c/internal/ic11/regassign"
 )
@@ -11,11 +15,14 @@ import (
 var (
 	ErrUnknownIRInstruction          = errors.New("unknown IR instruction")
 	ErrInvalidIRInstructionArguments = errors.New("invalid IR instruction argumetns")
+	ErrUnknownBuiltin                = errors.New("unknown builtin function")
 )
 
 type MipsAssembler struct {
 	registerAssigner regassign.RegisterAssigner
 	program          *MIPSProgram
+	// err is the first error of the register assigner, checked after each IR instruction
+	err error
 }
 
 func New(program *ir.Program, reg regassign.RegisterAssigner) (*MipsAssembler, error) {
@@ -32,43 +39,81 @@ func (ma *MipsAssembler) String() string {
 	return ma.program.String()
 }
 
+// Program returns the generated MIPS program
+func (ma *MipsAssembler) Program() *MIPSProgram {
+	return ma.program
+}
+
 // compile iterates over IR program and emits corresponding MIPS instructions to MipsProgram
 func (ma *MipsAssembler) compile(irProgram *ir.Program) error {
-	for _, irInstr := range irProgram.Get() {
-		switch i := irInstr.(type) {
-		case ir.IRAssignLiteral:
-			ma.emitAssignLiteral(i)
-		case ir.IRAssignVar:
-			ma.emitAsssignVar(i)
-		case ir.IRAssignBinary:
-			ma.emitAsssignBinary(i)
-		case ir.IRLabel:
-			ma.emitLabel(i)
-		case ir.IRGoto:
-			ma.emitGoto(i)
-		case ir.IRIfZ:
-			ma.emitIfZ(i)
-		case ir.IRBuiltinCallVoid:
-			ma.emitBuiltinCallVoid(i)
-		case ir.IRBuiltinCallRet:
-			ma.emitBuiltinCallRet(i)
-		default:
-			return ErrUnknownIRInstruction
+	for idx, irInstr := range irProgram.Get() {
+		err := ma.compileInstruction(irInstr, irProgram.Pos(idx))
+		if err == nil {
+			err = ma.err
+		}
+		if err != nil {
+			return diagnostic.Wrap(irProgram.Pos(idx), fmt.Errorf("%s: %w", irInstr, err))
 		}
 	}
 
 	return nil
 }
 
+// compileInstruction emits MIPS instructions corresponding to a single IR instruction
+func (ma *MipsAssembler) compileInstruction(irInstr ir.IRInstruction, pos lexer.Position) error {
+	switch i := irInstr.(type) {
+	case ir.IRAssignLiteral:
+		if !ir.NewLiteralOrVarLiteral(i.ValueVar).Valid() {
+			return ErrInvalidIRInstructionArguments
+		}
+		return ma.emitAssignLiteral(i)
+	case ir.IRAssignVar:
+		return ma.emitAsssignVar(i)
+	case ir.IRAssignBinary:
+		return ma.emitAsssignBinary(i)
+	case ir.IRAssignUnary:
+		return ma.emitAssignUnary(i)
+	case ir.IRAssignSelect:
+		return ma.emitAssignSelect(i)
+	case ir.IRLabel:
+		return ma.emitLabel(i)
+	case ir.IRGoto:
+		return ma.emitGoto(i)
+	case ir.IRIfZ:
+		return ma.emitIfZ(i)
+	case ir.IRBuiltinCallVoid:
+		return ma.emitBuiltinCallVoid(i)
+	case ir.IRBuiltinCallRet:
+		return ma.emitBuiltinCallRet(i)
+	case ir.IRStackLoad:
+		if !i.Address.Valid() {
+			return ErrInvalidIRInstructionArguments
+		}
+		return ma.emitStackLoad(i)
+	case ir.IRStackStore:
+		if !i.Address.Valid() {
+			return ErrInvalidIRInstructionArguments
+		}
+		return ma.emitStackStore(i)
+	case ir.IRInlineAsm:
+		for _, operand := range i.Operands {
+			if !operand.Valid() {
+				return ErrInvalidIRInstructionArguments
+			}
+		}
+		return ma.emitInlineAsm(i, pos)
+	default:
+		return ErrUnknownIRInstruction
+	}
+}
+
 // emitAssignLiteral emits MIPS code that corresponds to IRAssignLiteral
 // example:
 // t0 = 0;
 // ->
 // move r0 0
-func (ma *MipsAssembler) emitAssignLiteral(irInstr ir.IRAssignLiteral) {
-	regNumber := ma.registerAssigner.GetRegister(irInstr.Assignee)
-	regName := mipsRegisterName(regNumber)
-	ma.program.Emit(newInstructionN(move, regName, irInstr.ValueVar.String()))
+func (ma *MipsAssembler) emitAssignLiteral(irInstr ir.IRAssignLiteral) error {
+	return ma.program.Emit(newInstructionN(move, ma.register(irInstr.Assignee), mipsLiteral(irInstr.ValueVar)))
 }
 
 // emitAsssignVar emits MIPS code that corresponds to IRAssignVar
@@ -76,8 +121,29 @@ func (ma *MipsAssembler) emitAssignLiteral(irInstr ir.IRAssignLiteral) {
 // t0 = a;
 // ->
 // move r1 r0
-func (ma *MipsAssembler) emitAsssignVar(irInstr ir.IRAssignVar) {
-	panic("unimplmented")
+func (ma *MipsAssembler) emitAsssignVar(irInstr ir.IRAssignVar) error {
+	return ma.program.Emit(newInstructionN(move, ma.register(irInstr.Assignee), ma.register(irInstr.ValueVar)))
+}
+
+// binaryOps maps IR binary operators to the MIPS instructions implementing them
+var binaryOps = map[ir.IROp]string{
+	ir.OpAdd:    add,
+	ir.OpSub:    sub,
+	ir.OpMul:    mul,
+	ir.OpDiv:    div,
+	ir.OpMod:    mod,
+	ir.OpEq:     seq,
+	ir.OpNe:     sne,
+	ir.OpLt:     slt,
+	ir.OpLe:     sle,
+	ir.OpGt:     sgt,
+	ir.OpGe:     sge,
+	ir.OpBitAnd: and,
+	ir.OpBitOr:  or,
+	ir.OpBitXor: xor,
+	ir.OpShl:    sll,
+	ir.OpShr:    sra,
+	ir.OpShrU:   srl,
 }
 
 // emitAsssignBinary emits MIPS code that corresponds to IRAssignBinary
@@ -86,26 +152,73 @@ func (ma *MipsAssembler) emitAsssignVar(irInstr ir.IRAssignVar) {
 // ->
 // add r1 r0 1
 func (ma *MipsAssembler) emitAsssignBinary(irInstr ir.IRAssignBinary) error {
-	switch irInstr.Op {
-	case "+":
-		return nil
-	case "-":
-		return nil
-	case "/":
-		return nil
-	case "*":
-		return nil
-	case "||":
-		return nil
-	case "&&":
-		return nil
-	case "==":
-		return nil
-	case "<":
-		return nil
-	default:
+	assignee := ma.register(irInstr.Assignee)
+	l := ma.register(irInstr.L)
+	r := ma.register(irInstr.R)
+
+	// Operands of logical operators can be any number, so the result is normalized to 0 or 1.
+	// The assignee may share the register with an operand, so only the first instruction reads operands.
+	if irInstr.Op == ir.OpAnd {
+		if err := ma.program.Emit(newInstructionN(sel, assignee, l, r, "0")); err != nil {
+			return err
+		}
+		return ma.program.Emit(newInstructionN(snez, assignee, assignee))
+	}
+	if irInstr.Op == ir.OpOr {
+		if err := ma.program.Emit(newInstructionN(sel, assignee, l, "1", r)); err != nil {
+			return err
+		}
+		return ma.program.Emit(newInstructionN(snez, assignee, assignee))
+	}
+
+	op, found := binaryOps[irInstr.Op]
+	if !found {
 		return ErrInvalidIRInstructionArguments
 	}
+
+	return ma.program.Emit(newInstructionN(op, assignee, l, r))
+}
+
+// emitAssignUnary emits MIPS code that corresponds to IRAssignUnary
+// example:
+// t1 = ~t0;
+// ->
+// nor r1 r0 0
+func (ma *MipsAssembler) emitAssignUnary(irInstr ir.IRAssignUnary) error {
+	assignee := ma.register(irInstr.Assignee)
+	r := ma.register(irInstr.R)
+	instr, found := unaryOps[irInstr.Op]
+	if !found {
+		return ErrInvalidIRInstructionArguments
+	}
+
+	return ma.program.Emit(instr(assignee, r))
+}
+
+// unaryOps maps IR unary operators to functions emitting MIPS instructions implementing them
+var unaryOps = map[ir.IROp]func(assignee, r string) *mipsInstructionN{
+	ir.OpNeg: func(assignee, r string) *mipsInstructionN {
+		return newInstructionN(sub, assignee, "0", r)
+	},
+	ir.OpNot: func(assignee, r string) *mipsInstructionN {
+		return newInstructionN(seqz, assignee, r)
+	},
+	ir.OpBitNot: func(assignee, r string) *mipsInstructionN {
+		return newInstructionN(nor, assignee, r, "0")
+	},
+}
+
+// emitAssignSelect emits MIPS code that corresponds to IRAssignSelect
+// example:
+// t3 = t0 ? t1 : t2;
+// ->
+// select r3 r0 r1 r2
+func (ma *MipsAssembler) emitAssignSelect(irInstr ir.IRAssignSelect) error {
+	return ma.program.Emit(newInstructionN(sel,
+		ma.register(irInstr.Assignee),
+		ma.register(irInstr.Cond),
+		ma.register(irInstr.L),
+		ma.register(irInstr.R)))
 }
 
 // emitLabel emits MIPS code that corresponds to IRLabel
@@ -113,8 +226,8 @@ func (ma *MipsAssembler) emitAsssignBinary(irInstr ir.IRAssignBinary) error {
 // _L1:
 // ->
 // _L1:
-func (ma *MipsAssembler) emitLabel(irInstr ir.IRLabel) {
-	panic("unimplmented")
+func (ma *MipsAssembler) emitLabel(irInstr ir.IRLabel) error {
+	return ma.program.Emit(mipsLabel{string(irInstr.Label)})
 }
 
 // emitGoto emits MIPS code that corresponds to IRGoto
@@ -122,8 +235,8 @@ func (ma *MipsAssembler) emitLabel(irInstr ir.IRLabel) {
 // Goto _L0;
 // ->
 // j _L0
-func (ma *MipsAssembler) emitGoto(irInstr ir.IRGoto) {
-	panic("unimplmented")
+func (ma *MipsAssembler) emitGoto(irInstr ir.IRGoto) error {
+	return ma.program.Emit(newInstructionN(j, string(irInstr.Label)))
 }
 
 // emitIfZ emits MIPS code that corresponds to emitIfZ
@@ -131,30 +244,175 @@ func (ma *MipsAssembler) emitGoto(irInstr ir.IRGoto) {
 // IfZ t0 Goto _L0;
 // ->
 // beqz r0 _L0
-func (ma *MipsAssembler) emitIfZ(irInstr ir.IRIfZ) {
-	panic("unimplmented")
+func (ma *MipsAssembler) emitIfZ(irInstr ir.IRIfZ) error {
+	return ma.program.Emit(newInstructionN(beqz, ma.register(irInstr.Cond), string(irInstr.Label)))
+}
+
+// builtin describes the MIPS instruction implementing a builtin function.
+// Parameters of the builtin are passed as operands in order, following the register of the result if there is one.
+type builtin struct {
+	instr  string
+	params int
+	ret    bool
+}
+
+var builtins = map[string]builtin{
+	"load":        {l, 2, true},
+	"load_batch":  {lb, 3, true},
+	"store":       {s, 3, false},
+	"store_batch": {sb, 3, false},
+	"yield":       {yield, 0, false},
+	"sleep":       {sleep, 1, false},
+	"hcf":         {hcf, 0, false},
+	"rand":        {rand, 0, true},
+	"sin":         {sin, 1, true},
+	"cos":         {cos, 1, true},
+	"tan":         {tan, 1, true},
+	"abs":         {abs, 1, true},
+	"acos":        {acos, 1, true},
+	"asin":        {asin, 1, true},
+	"atan":        {atan, 1, true},
+	"ceil":        {ceil, 1, true},
+	"floor":       {floor, 1, true},
+	"log":         {log, 1, true},
+	"sqrt":        {sqrt, 1, true},
+	"round":       {round, 1, true},
+	"trunc":       {trunc, 1, true},
+	"mod":         {mod, 2, true},
+	"xor":         {xor, 2, true},
+	"nor":         {nor, 2, true},
+	"max":         {max, 2, true},
+	"min":         {min, 2, true},
+}
+
+// builtinOperands validates a call of a builtin function and returns its operands
+func (ma *MipsAssembler) builtinOperands(name string, params []ir.IRLiteralOrVar, ret bool) (string, []string, error) {
+	b, found := builtins[name]
+	if !found {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownBuiltin, name)
+	}
+	if len(params) != b.params || b.ret != ret {
+		return "", nil, ErrInvalidIRInstructionArguments
+	}
+
+	operands := []string{}
+	for _, param := range params {
+		if !param.Valid() {
+			return "", nil, ErrInvalidIRInstructionArguments
+		}
+		operands = append(operands, ma.operand(param))
+	}
+
+	return b.instr, operands, nil
 }
 
 // emitBuiltinCallVoid emits MIPS code that corresponds to IRBuiltinCallVoid
 // example:
-// Bcall store d0 "Vertical" t0;
+// Bcall store d0 Vertical t0;
 // ->
-// beqz r0 _L0
-func (ma *MipsAssembler) emitBuiltinCallVoid(irInstr ir.IRBuiltinCallVoid) {
-	panic("unimplmented")
+// s d0 Vertical r0
+func (ma *MipsAssembler) emitBuiltinCallVoid(irInstr ir.IRBuiltinCallVoid) error {
+	instr, operands, err := ma.builtinOperands(irInstr.BuiltinName, irInstr.Params, false)
+	if err != nil {
+		return err
+	}
+
+	return ma.program.Emit(newInstructionN(instr, operands...))
 }
 
-// emitIfZ emits MIPS code that corresponds to IRBuiltinCallRet
+// emitBuiltinCallRet emits MIPS code that corresponds to IRBuiltinCallRet
 // example:
 // t0 = Bcall load d2 On;
 // ->
 // l r0 d2 On
-func (ma *MipsAssembler) emitBuiltinCallRet(irInstr ir.IRBuiltinCallRet) {
-	panic("unimplmented")
+func (ma *MipsAssembler) emitBuiltinCallRet(irInstr ir.IRBuiltinCallRet) error {
+	instr, operands, err := ma.builtinOperands(irInstr.BuiltinName, irInstr.Params, true)
+	if err != nil {
+		return err
+	}
+
+	in := newInstructionN(instr, append([]string{ma.register(irInstr.Ret)}, operands...)...)
+	in.volatile = irInstr.Volatile
+	return ma.program.Emit(in)
+}
+
+// emitStackLoad emits MIPS code that corresponds to IRStackLoad
+// example:
+// t1 = Stack[t0];
+// ->
+// get r1 db r0
+func (ma *MipsAssembler) emitStackLoad(irInstr ir.IRStackLoad) error {
+	return ma.program.Emit(newInstructionN(get, ma.register(irInstr.Ret), db, ma.operand(irInstr.Address)))
+}
+
+// emitStackStore emits MIPS code that corresponds to IRStackStore
+// example:
+// Stack[511] = t0;
+// ->
+// put db 511 r0
+func (ma *MipsAssembler) emitStackStore(irInstr ir.IRStackStore) error {
+	return ma.program.Emit(newInstructionN(put, db, ma.operand(irInstr.Address), ma.register(irInstr.Value)))
+}
+
+// emitInlineAsm copies the inline assembly block verbatim, with operands replaced by registers and literals.
+// The lines keep pos, the position of the block, so that they can be validated against the source.
+// example:
+// Asm "move %0 r15" a;
+// ->
+// move r0 r15
+func (ma *MipsAssembler) emitInlineAsm(irInstr ir.IRInlineAsm, pos lexer.Position) error {
+	for _, line := range irInstr.Expand(ma.operand) {
+		if err := ma.program.Emit(mipsRaw{text: line, pos: pos}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Helpers
 
+// register returns name of the register assigned to the variable. If no register is left, the error is kept in ma.err.
+func (ma *MipsAssembler) register(v ir.IRVar) string {
+	register, err := ma.registerAssigner.GetRegister(v)
+	if err != nil && ma.err == nil {
+		ma.err = err
+	}
+
+	return mipsRegisterName(register)
+}
+
+// operand returns MIPS operand for IRLiteralOrVar: a register name for variables, the value itself for literals
+func (ma *MipsAssembler) operand(litOrVar ir.IRLiteralOrVar) string {
+	if v, ok := litOrVar.Var(); ok {
+		return ma.register(v)
+	}
+
+	lit, _ := litOrVar.Literal()
+	return mipsLiteral(lit)
+}
+
+// mipsLiteral formats a literal operand. Integers are written in IC10 hexadecimal ($FF) or binary (%101)
+// notation when it's shorter than the decimal one.
+func mipsLiteral(lit ir.IRLiteralType) string {
+	i, ok := lit.Integer()
+	if !ok {
+		return lit.String()
+	}
+
+	shortest := strconv.FormatInt(i, 10)
+	if i <= 0 {
+		return shortest
+	}
+	for _, alt := range []string{"$" + strings.ToUpper(strconv.FormatInt(i, 16)), "%" + strconv.FormatInt(i, 2)} {
+		if len(alt) < len(shortest) {
+			shortest = alt
+		}
+	}
+
+	return shortest
+}
+
 func mipsRegisterName(registerNumber int) string {
 	return fmt.Sprintf("r%d", registerNumber)
 }
@@ -1,9 +1,62 @@
 package assembler
 
+import (
+	"errors"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/ir"
+)
+
 type testRegisterAssigner struct {
-	assignMap map[string]int
+	assignMap map[ir.IRVar]int
+}
+
+func (tra *testRegisterAssigner) GetRegister(varName ir.IRVar) (int, error) {
+	return tra.assignMap[varName], nil
+}
+
+func TestRegisterAssignment(t *testing.T) {
+	program := ir.NewProgram()
+	program.Emit(ir.IRAssignLiteral{Assignee: "a", ValueVar: *ir.NewIntLiteral(0xFFFFFF)})
+	program.Emit(ir.IRAssignBinary{Assignee: "b", L: "a", R: "a", Op: ir.OpMul})
+	program.Emit(ir.IRBuiltinCallVoid{BuiltinName: "store", Params: []ir.IRLiteralOrVar{
+		ir.NewLiteralOrVarLiteral(*ir.NewStringLiteral("d0")),
+		ir.NewLiteralOrVarLiteral(*ir.NewStringLiteral("Setting")),
+		ir.NewLiteralOrVarVar("b"),
+	}})
+
+	asm, err := New(program, &testRegisterAssigner{assignMap: map[ir.IRVar]int{"a": 3, "b": 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "move r3 $FFFFFF\nmul r7 r3 r3\ns d0 Setting r7\n"
+	if got := asm.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestInvalidOperand(t *testing.T) {
+	program := ir.NewProgram()
+	program.Emit(ir.IRStackLoad{Ret: "a", Address: ir.IRLiteralOrVar{}})
+
+	_, err := New(program, &testRegisterAssigner{assignMap: map[ir.IRVar]int{"a": 0}})
+	if !errors.Is(err, ErrInvalidIRInstructionArguments) {
+		t.Errorf("got %v, want %v", err, ErrInvalidIRInstructionArguments)
+	}
 }
 
-func (tra *testRegisterAssigner) GetRegister(regName string) int {
-	return tra.assignMap[regName]
+func TestMalformedInstruction(t *testing.T) {
+	program := ir.NewProgram()
+	program.Emit(ir.IRAssignLiteral{Assignee: "a", ValueVar: *ir.NewIntLiteral(1)})
+	program.Emit(ir.IRBuiltinCallVoid{BuiltinName: "store", Params: []ir.IRLiteralOrVar{
+		ir.NewLiteralOrVarLiteral(*ir.NewIntLiteral(5)),
+		ir.NewLiteralOrVarLiteral(*ir.NewStringLiteral("On")),
+		ir.NewLiteralOrVarVar("a"),
+	}})
+
+	_, err := New(program, &testRegisterAssigner{assignMap: map[ir.IRVar]int{"a": 0}})
+	if !errors.Is(err, ErrInvalidOperand) {
+		t.Errorf("got %v, want %v", err, ErrInvalidOperand)
+	}
 }
@@ -2,56 +2,92 @@ package assembler
 
 // MIPS instructions
 const (
-	move  = "move"
-	add   = "add"
-	sub   = "sub"
-	mul   = "mul"
-	div   = "div"
-	and   = "and"
-	or    = "or"
-	xor   = "xor"
-	nor   = "nor"
-	sge   = "sge"
-	sgt   = "sgt"
-	sle   = "sle"
-	slt   = "slt"
-	seq   = "seq"
-	seqz  = "seqz"
-	sne   = "sne"
-	j     = "j"
-	bnez  = "bnez"
-	beqz  = "beqz"
-	sin   = "sin"
-	cos   = "cos"
-	tan   = "tan"
-	mod   = "mod"
-	l     = "l"
-	lb    = "lb"
-	lr    = "lr"
-	ls    = "ls"
-	s     = "s"
-	sb    = "sb"
-	yield = "yield"
-	sleep = "sleep"
-	bge   = "bge"
-	bgt   = "bgt"
-	ble   = "ble"
-	blt   = "blt"
-	beq   = "beq"
-	bne   = "bne"
-	abs   = "abs"
-	acos  = "acos"
-	asin  = "asin"
-	atan  = "atan"
-	ceil  = "ceil"
-	exp   = "exp"
-	floor = "floor"
-	log   = "log"
-	max   = "max"
-	min   = "min"
-	sqrt  = "sqrt"
-	round = "round"
-	trunc = "trunc"
-	rand  = "rand"
-	alias = "alias"
+	move   = "move"
+	add    = "add"
+	sub    = "sub"
+	mul    = "mul"
+	div    = "div"
+	and    = "and"
+	or     = "or"
+	xor    = "xor"
+	nor    = "nor"
+	sll    = "sll"
+	sla    = "sla"
+	srl    = "srl"
+	sra    = "sra"
+	sge    = "sge"
+	sgt    = "sgt"
+	sle    = "sle"
+	slt    = "slt"
+	seq    = "seq"
+	seqz   = "seqz"
+	sne    = "sne"
+	snez   = "snez"
+	j      = "j"
+	bnez   = "bnez"
+	beqz   = "beqz"
+	sin    = "sin"
+	cos    = "cos"
+	tan    = "tan"
+	mod    = "mod"
+	l      = "l"
+	lb     = "lb"
+	lr     = "lr"
+	ls     = "ls"
+	s      = "s"
+	sb     = "sb"
+	yield  = "yield"
+	sleep  = "sleep"
+	bge    = "bge"
+	bgt    = "bgt"
+	ble    = "ble"
+	blt    = "blt"
+	beq    = "beq"
+	bne    = "bne"
+	abs    = "abs"
+	acos   = "acos"
+	asin   = "asin"
+	atan   = "atan"
+	ceil   = "ceil"
+	exp    = "exp"
+	floor  = "floor"
+	log    = "log"
+	max    = "max"
+	min    = "min"
+	sqrt   = "sqrt"
+	round  = "round"
+	trunc  = "trunc"
+	rand   = "rand"
+	alias  = "alias"
+	define = "define"
+	get    = "get"
+	put    = "put"
+	hcf    = "hcf"
+	atan2  = "atan2"
+	lerp   = "lerp"
+	not    = "not"
+	jal    = "jal"
+	jr     = "jr"
+	push   = "push"
+	pop    = "pop"
+	peek   = "peek"
+	poke   = "poke"
+	getd   = "getd"
+	putd   = "putd"
+	clr    = "clr"
+	ld     = "ld"
+	sd     = "sd"
+	ss     = "ss"
+	lbn    = "lbn"
+	lbs    = "lbs"
+	lbns   = "lbns"
+	sbn    = "sbn"
+	sbs    = "sbs"
+	// select is a reserved word in Go
+	sel = "select"
+)
+
+// MIPS devices
+const (
+	db = "db"
 )
@@ -0,0 +1,323 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+)
+
+var (
+	ErrUnknownInstruction = errors.New("unknown instruction")
+	ErrArity              = errors.New("wrong number of operands")
+	ErrInvalidOperand     = errors.New("invalid operand")
+)
+
+var (
+	registerRegexp = regexp.MustCompile(`^(r+(1[0-7]|[0-9])|sp|ra)$`)
+	deviceRegexp   = regexp.MustCompile(`^(d[0-5]|db|dr+(1[0-7]|[0-9]))(:[0-9]+)?$`)
+	nameRegexp     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// pinLikeRegexp matches registers and devices past the ones a chip has, such as r18, which can't be names either
+	pinLikeRegexp = regexp.MustCompile(`^(r+|d|dr+)[0-9]+$`)
+)
+
+var reagentModes = []string{"Contents", "Required", "Recipe"}
+
+// operandKind is the kind of values an operand of an instruction accepts
+type operandKind int
+
+const (
+	// regOp is a register written by the instruction: r0-r15, sp, ra, an indirect register or an alias of a register
+	regOp operandKind = iota
+	// valueOp is a number: a register, a literal, a define or a label
+	valueOp
+	// deviceOp is a device pin: d0-d5, db, an indirect device or an alias of a device
+	deviceOp
+	// logicTypeOp is the name of a logic type, or its number
+	logicTypeOp
+	// batchModeOp is the name of a batch mode, or its number
+	batchModeOp
+	// reagentModeOp is the name of a reagent mode, or its number
+	reagentModeOp
+	// targetOp is the line a jump goes to: a label or a number
+	targetOp
+	// nameOp is a name declared by the instruction
+	nameOp
+	// aliasTargetOp is the register or the device pin an alias stands for
+	aliasTargetOp
+	// numberOp is a literal number
+	numberOp
+)
+
+func (k operandKind) String() string {
+	return [...]string{
+		"register", "value", "device", "logic type", "batch mode", "reagent mode", "jump target", "name", "register or device", "number",
+	}[k]
+}
+
+// effect is a set of side effects of an instruction, besides writing its register operand
+type effect int
+
+const (
+	// readsDevice reads a device, or checks if a device is set
+	readsDevice effect = 1 << iota
+	writesDevice
+	// usesStack reads or writes the stack of the chip, and sp
+	usesStack
+	// suspends makes the chip wait, devices may change meanwhile
+	suspends
+	halts
+	// links writes the address of the next line to ra
+	links
+	// random gives a different result every time
+	random
+	// declares declares a name when the program is loaded, the instruction does nothing at run time
+	declares
+)
+
+// branchKind tells how an instruction changes the line executed next. The line jumped to is the last operand.
+type branchKind int
+
+const (
+	noBranch branchKind = iota
+	// jump always jumps to a line
+	jump
+	// relativeJump always jumps by a number of lines
+	relativeJump
+	// branch jumps to a line if a condition holds
+	branch
+	// relativeBranch jumps by a number of lines if a condition holds
+	relativeBranch
+)
+
+// An opcode describes an IC10 instruction
+type opcode struct {
+	name     string
+	operands []operandKind
+	effects  effect
+	branch   branchKind
+	// lines is the number of lines the instruction takes in a chip
+	lines int
+}
+
+func newOpcode(name string, effects effect, branch branchKind, operands ...operandKind) opcode {
+	return opcode{name: name, operands: operands, effects: effects, branch: branch, lines: 1}
+}
+
+// opcodes lists every IC10 instruction by name. Conditional instructions are added by init.
+var opcodes = make(map[string]opcode)
+
+var opcodeTable = []opcode{
+	newOpcode(move, 0, noBranch, regOp, valueOp),
+
+	newOpcode(add, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(sub, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(mul, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(div, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(mod, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(max, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(min, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(atan2, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(abs, 0, noBranch, regOp, valueOp),
+	newOpcode(ceil, 0, noBranch, regOp, valueOp),
+	newOpcode(floor, 0, noBranch, regOp, valueOp),
+	newOpcode(round, 0, noBranch, regOp, valueOp),
+	newOpcode(trunc, 0, noBranch, regOp, valueOp),
+	newOpcode(sqrt, 0, noBranch, regOp, valueOp),
+	newOpcode(exp, 0, noBranch, regOp, valueOp),
+	newOpcode(log, 0, noBranch, regOp, valueOp),
+	newOpcode(sin, 0, noBranch, regOp, valueOp),
+	newOpcode(cos, 0, noBranch, regOp, valueOp),
+	newOpcode(tan, 0, noBranch, regOp, valueOp),
+	newOpcode(asin, 0, noBranch, regOp, valueOp),
+	newOpcode(acos, 0, noBranch, regOp, valueOp),
+	newOpcode(atan, 0, noBranch, regOp, valueOp),
+	newOpcode(lerp, 0, noBranch, regOp, valueOp, valueOp, valueOp),
+	newOpcode(sel, 0, noBranch, regOp, valueOp, valueOp, valueOp),
+	newOpcode(rand, random, noBranch, regOp),
+
+	newOpcode(and, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(or, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(xor, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(nor, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(sll, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(sla, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(srl, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(sra, 0, noBranch, regOp, valueOp, valueOp),
+	newOpcode(not, 0, noBranch, regOp, valueOp),
+
+	newOpcode(j, 0, jump, targetOp),
+	newOpcode(jal, links, jump, targetOp),
+	newOpcode(jr, 0, relativeJump, valueOp),
+	newOpcode(yield, suspends, noBranch),
+	newOpcode(sleep, suspends, noBranch, valueOp),
+	newOpcode(hcf, halts, noBranch),
+	newOpcode(alias, declares, noBranch, nameOp, aliasTargetOp),
+	newOpcode(define, declares, noBranch, nameOp, numberOp),
+
+	newOpcode(push, usesStack, noBranch, valueOp),
+	newOpcode(pop, usesStack, noBranch, regOp),
+	newOpcode(peek, usesStack, noBranch, regOp),
+	newOpcode(poke, usesStack, noBranch, valueOp, valueOp),
+	newOpcode(get, readsDevice, noBranch, regOp, deviceOp, valueOp),
+	newOpcode(getd, readsDevice, noBranch, regOp, valueOp, valueOp),
+	newOpcode(put, writesDevice, noBranch, deviceOp, valueOp, valueOp),
+	newOpcode(putd, writesDevice, noBranch, valueOp, valueOp, valueOp),
+	newOpcode(clr, writesDevice, noBranch, deviceOp),
+
+	newOpcode(l, readsDevice, noBranch, regOp, deviceOp, logicTypeOp),
+	newOpcode(ld, readsDevice, noBranch, regOp, valueOp, logicTypeOp),
+	newOpcode(s, writesDevice, noBranch, deviceOp, logicTypeOp, valueOp),
+	newOpcode(sd, writesDevice, noBranch, valueOp, logicTypeOp, valueOp),
+	newOpcode(ls, readsDevice, noBranch, regOp, deviceOp, valueOp, logicTypeOp),
+	newOpcode(ss, writesDevice, noBranch, deviceOp, valueOp, logicTypeOp, valueOp),
+	newOpcode(lr, readsDevice, noBranch, regOp, deviceOp, reagentModeOp, valueOp),
+	newOpcode(lb, readsDevice, noBranch, regOp, valueOp, logicTypeOp, batchModeOp),
+	newOpcode(lbn, readsDevice, noBranch, regOp, valueOp, valueOp, logicTypeOp, batchModeOp),
+	newOpcode(lbs, readsDevice, noBranch, regOp, valueOp, valueOp, logicTypeOp, batchModeOp),
+	newOpcode(lbns, readsDevice, noBranch, regOp, valueOp, valueOp, valueOp, logicTypeOp, batchModeOp),
+	newOpcode(sb, writesDevice, noBranch, valueOp, logicTypeOp, valueOp),
+	newOpcode(sbn, writesDevice, noBranch, valueOp, valueOp, logicTypeOp, valueOp),
+	newOpcode(sbs, writesDevice, noBranch, valueOp, valueOp, logicTypeOp, valueOp),
+}
+
+// conditions lists the operands compared by the set (sCOND), branch (bCOND, brCOND) and branch and link (bCONDal)
+// instructions
+var conditions = map[string][]operandKind{
+	"eq":  {valueOp, valueOp},
+	"ne":  {valueOp, valueOp},
+	"lt":  {valueOp, valueOp},
+	"le":  {valueOp, valueOp},
+	"gt":  {valueOp, valueOp},
+	"ge":  {valueOp, valueOp},
+	"eqz": {valueOp},
+	"nez": {valueOp},
+	"ltz": {valueOp},
+	"lez": {valueOp},
+	"gtz": {valueOp},
+	"gez": {valueOp},
+	"ap":  {valueOp, valueOp, valueOp},
+	"na":  {valueOp, valueOp, valueOp},
+	"apz": {valueOp, valueOp},
+	"naz": {valueOp, valueOp},
+	"dse": {deviceOp},
+	"dns": {deviceOp},
+}
+
+func init() {
+	for _, op := range opcodeTable {
+		opcodes[op.name] = op
+	}
+	for name, operands := range conditions {
+		var effects effect
+		if name == "dse" || name == "dns" {
+			effects = readsDevice
+		}
+		compared := func(last operandKind) []operandKind {
+			return append(append([]operandKind{}, operands...), last)
+		}
+
+		opcodes["s"+name] = newOpcode("s"+name, effects, noBranch, append([]operandKind{regOp}, operands...)...)
+		opcodes["b"+name] = newOpcode("b"+name, effects, branch, compared(targetOp)...)
+		opcodes["b"+name+"al"] = newOpcode("b"+name+"al", effects|links, branch, compared(targetOp)...)
+		opcodes["br"+name] = newOpcode("br"+name, effects, relativeBranch, compared(valueOp)...)
+	}
+}
+
+// A scope resolves the names used as operands
+type scope interface {
+	// isRegister reports whether name is an alias of a register
+	isRegister(name string) bool
+	// isDevice reports whether name is an alias of a device
+	isDevice(name string) bool
+	// isLabel reports whether name is a label
+	isLabel(name string) bool
+	// isDefine reports whether name is a define
+	isDefine(name string) bool
+}
+
+// anyScope accepts names of every kind, for code checked before its names are known
+type anyScope struct{}
+
+func (anyScope) isRegister(name string) bool { return isName(name) }
+func (anyScope) isDevice(name string) bool   { return isName(name) }
+func (anyScope) isLabel(name string) bool    { return isName(name) }
+func (anyScope) isDefine(name string) bool   { return isName(name) }
+
+// check checks the operands of the instruction against its opcode
+func (in mipsInstructionN) check(sc scope) error {
+	op, found := opcodes[in.cmd]
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknownInstruction, in.cmd)
+	}
+	if len(in.args) != len(op.operands) {
+		return fmt.Errorf("%w: %s expects %d, got %d", ErrArity, in.cmd, len(op.operands), len(in.args))
+	}
+	for i, kind := range op.operands {
+		if err := checkOperand(kind, in.args[i], sc); err != nil {
+			return fmt.Errorf("%s: %w", in, err)
+		}
+	}
+
+	return nil
+}
+
+// checkOperand checks that arg is a valid operand of the kind
+func checkOperand(kind operandKind, arg string, sc scope) error {
+	valid := false
+	switch kind {
+	case regOp:
+		valid = registerRegexp.MatchString(arg) || sc.isRegister(arg)
+	case valueOp:
+		valid = isValue(arg, sc)
+	case deviceOp:
+		valid = deviceRegexp.MatchString(arg) || sc.isDevice(arg)
+	case logicTypeOp:
+		valid = nameRegexp.MatchString(arg) || isValue(arg, sc)
+	case batchModeOp:
+		valid = contains(ic11.BatchModes, arg) || isValue(arg, sc)
+	case reagentModeOp:
+		valid = contains(reagentModes, arg) || isValue(arg, sc)
+	case targetOp:
+		valid = sc.isLabel(arg) || isValue(arg, sc)
+	case nameOp:
+		valid = isName(arg)
+	case aliasTargetOp:
+		valid = registerRegexp.MatchString(arg) || deviceRegexp.MatchString(arg)
+	case numberOp:
+		_, err := emulator.ParseNumber(arg)
+		valid = err == nil
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: %s is not a %s", ErrInvalidOperand, arg, kind)
+	}
+
+	return nil
+}
+
+func isValue(arg string, sc scope) bool {
+	if registerRegexp.MatchString(arg) || sc.isRegister(arg) || sc.isDefine(arg) || sc.isLabel(arg) {
+		return true
+	}
+	_, err := emulator.ParseNumber(arg)
+	return err == nil
+}
+
+// isName reports whether arg can be declared as a name, registers and devices can't
+func isName(arg string) bool {
+	return nameRegexp.MatchString(arg) && !pinLikeRegexp.MatchString(arg) && !registerRegexp.MatchString(arg) &&
+		!deviceRegexp.MatchString(arg)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
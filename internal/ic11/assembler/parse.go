@@ -0,0 +1,145 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+var (
+	ErrSyntax         = ic11.ErrSyntax
+	ErrDuplicateLabel = errors.New("duplicate label")
+)
+
+// Parse parses hand-written IC10 code. Instructions are checked against the opcode table, and names against
+// the labels, aliases and defines of all files. Comment and blank lines are kept, so that line numbers don't change,
+// comments at the end of instructions are dropped.
+func Parse(files []io.Reader, diags *diagnostic.Collector) (*MIPSProgram, error) {
+	p := newParsedProgram()
+	for _, file := range files {
+		src, err := io.ReadAll(file)
+		name := sourceName(file)
+		if err != nil {
+			diags.Report(lexer.Position{Filename: name}, err)
+			continue
+		}
+		diags.AddSource(name, string(src))
+		p.parseFile(name, string(src), diags)
+	}
+
+	for _, line := range p.lines {
+		if err := line.instr.check(p); err != nil {
+			diags.Report(line.pos, err)
+		}
+	}
+
+	return p.program, diags.Err()
+}
+
+type parsedProgram struct {
+	program *MIPSProgram
+	lines   []parsedLine
+	labels  map[string]lexer.Position
+	// aliases maps names declared by alias to the kind of their target, register or device
+	aliases map[string]operandKind
+	defines map[string]bool
+}
+
+func newParsedProgram() *parsedProgram {
+	return &parsedProgram{
+		program: NewMipsProgram(),
+		labels:  make(map[string]lexer.Position),
+		aliases: make(map[string]operandKind),
+		defines: make(map[string]bool),
+	}
+}
+
+type parsedLine struct {
+	pos   lexer.Position
+	instr *mipsInstructionN
+}
+
+// parseFile parses the lines of a file. Names are checked once all files are parsed, as they can be used before they
+// are declared.
+func (p *parsedProgram) parseFile(name, src string, diags *diagnostic.Collector) {
+	for n, line := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+		pos := lexer.Position{Filename: name, Line: n + 1, Column: 1}
+		tokens, comment, err := ic11.Tokenize(line)
+		if err != nil {
+			diags.Report(pos, err)
+			p.program.Emit(mipsRaw{text: ""})
+			continue
+		}
+
+		switch {
+		case len(tokens) == 0:
+			p.program.Emit(mipsRaw{text: comment})
+		case len(tokens) == 1 && strings.HasSuffix(tokens[0], ":"):
+			label := strings.TrimSuffix(tokens[0], ":")
+			if prev, found := p.labels[label]; found {
+				diags.Report(pos, fmt.Errorf("%w: %s, first declared at %s", ErrDuplicateLabel, label, prev))
+			}
+			p.labels[label] = pos
+			if err := p.program.Emit(mipsLabel{label}); err != nil {
+				diags.Report(pos, err)
+			}
+		default:
+			instr := newInstructionN(tokens[0], tokens[1:]...)
+			if err := p.program.Emit(instr); err != nil {
+				diags.Report(pos, err)
+				continue
+			}
+			p.declare(instr)
+			p.lines = append(p.lines, parsedLine{pos, instr})
+		}
+	}
+}
+
+// declare records the names declared by alias and define
+func (p *parsedProgram) declare(instr *mipsInstructionN) {
+	if len(instr.args) != 2 {
+		return
+	}
+	switch instr.cmd {
+	case alias:
+		if registerRegexp.MatchString(instr.args[1]) {
+			p.aliases[instr.args[0]] = regOp
+		} else {
+			p.aliases[instr.args[0]] = deviceOp
+		}
+	case define:
+		p.defines[instr.args[0]] = true
+	}
+}
+
+func (p *parsedProgram) isRegister(name string) bool {
+	kind, found := p.aliases[name]
+	return found && kind == regOp
+}
+
+func (p *parsedProgram) isDevice(name string) bool {
+	kind, found := p.aliases[name]
+	return found && kind == deviceOp
+}
+
+func (p *parsedProgram) isLabel(name string) bool {
+	_, found := p.labels[name]
+	return found
+}
+
+func (p *parsedProgram) isDefine(name string) bool {
+	return p.defines[name]
+}
+
+func sourceName(r io.Reader) string {
+	if named, ok := r.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+
+	return ""
+}
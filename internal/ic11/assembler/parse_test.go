@@ -0,0 +1,94 @@
+package assembler
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+func parse(src string) (*MIPSProgram, error) {
+	return Parse([]io.Reader{strings.NewReader(src)}, diagnostic.NewCollector(diagnostic.Options{}))
+}
+
+func TestParse(t *testing.T) {
+	src := `# thermostat
+alias sensor d0
+alias temp r0
+define Target 300
+
+start:
+l temp sensor Temperature
+sgt r1 temp Target
+s d1 On r1
+lb r2 HASH("StructureWallLight") On Average
+bdns sensor start
+yield
+j start
+`
+	program, err := parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := program.String(); got != src {
+		t.Errorf("got:\n%s\nwant:\n%s", got, src)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want error
+	}{
+		{"unknown instruction", "foo r0\n", ErrUnknownInstruction},
+		{"arity", "add r0 r1\n", ErrArity},
+		{"register", "move 1 r0\n", ErrInvalidOperand},
+		{"register past ra", "move r18 1\n", ErrInvalidOperand},
+		{"device", "l r0 r1 On\n", ErrInvalidOperand},
+		{"undefined name", "move r0 Target\n", ErrInvalidOperand},
+		{"undefined label", "j start\n", ErrInvalidOperand},
+		{"register alias as device", "alias x r0\nl r0 x On\n", ErrInvalidOperand},
+		{"duplicate label", "a:\na:\n", ErrDuplicateLabel},
+		{"unterminated string", "move r0 HASH(\"a)\n", ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.src)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	program, err := parse(strings.Repeat("yield\n", LineLimit+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.Validate(); !errors.Is(err, ErrTooManyLines) {
+		t.Errorf("got %v, want %v", err, ErrTooManyLines)
+	}
+
+	program, err = parse("# " + strings.Repeat("a", MaxLineLength) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.Validate(); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("got %v, want %v", err, ErrLineTooLong)
+	}
+
+	// Inline assembly is checked against the names the program declares
+	program = NewMipsProgram()
+	program.Emit(mipsRaw{text: "j start"})
+	if err := program.Validate(); !errors.Is(err, ErrInvalidOperand) {
+		t.Errorf("got %v, want %v", err, ErrInvalidOperand)
+	}
+	program.Emit(mipsRaw{text: "start:"})
+	if err := program.Validate(); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
@@ -0,0 +1,656 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+)
+
+// A Rewrite records a peephole rule applied to a program
+type Rewrite struct {
+	Rule string
+	// Line is the first line rewritten, counted from 1
+	Line   int
+	Before []string
+	After  []string
+}
+
+func (r Rewrite) String() string {
+	after := strings.Join(r.After, "; ")
+	if after == "" {
+		after = "(removed)"
+	}
+
+	return fmt.Sprintf("%s at line %d: %s -> %s", r.Rule, r.Line, strings.Join(r.Before, "; "), after)
+}
+
+// A peepholeRule matches the code at line i and returns how to rewrite it
+type peepholeRule struct {
+	name  string
+	match func(o *peephole, i int) (edit, bool)
+}
+
+// An edit replaces n lines starting at line at
+type edit struct {
+	at, n int
+	with  []mipsInstruction
+}
+
+var peepholeRules = []peepholeRule{
+	{"self-move", selfMove},
+	{"copy-into-def", copyIntoDef},
+	{"copy-into-use", copyIntoUse},
+	{"redundant-load", redundantLoad},
+	{"jump-next", jumpNext},
+	{"branch-over-jump", branchOverJump},
+	{"jump-thread", jumpThread},
+}
+
+// inverseConditions maps branch conditions to their negation. Ordered comparisons have none, as neither a < b
+// nor a >= b holds if a or b is NaN.
+var inverseConditions = map[string]string{
+	"eq":  "ne",
+	"ne":  "eq",
+	"eqz": "nez",
+	"nez": "eqz",
+	"ap":  "na",
+	"na":  "ap",
+	"apz": "naz",
+	"naz": "apz",
+	"dse": "dns",
+	"dns": "dse",
+}
+
+// regSet is a set of registers, sp and ra are r16 and r17
+type regSet uint32
+
+const (
+	spRegister        = 16
+	raRegister        = 17
+	allRegs    regSet = 1<<18 - 1
+)
+
+type peephole struct {
+	instructions []mipsInstruction
+	// aliases maps names to the register or device they stand for, "" if they are aliased several times
+	aliases  map[string]string
+	labels   map[string]int
+	liveOut  []regSet
+	rewrites []Rewrite
+}
+
+// Peephole returns a copy of the program improved by peephole rules, and the rewrites made.
+// Programs jumping to line numbers rather than labels are returned unchanged, as rules remove lines.
+func Peephole(p *MIPSProgram) (*MIPSProgram, []Rewrite) {
+	o := &peephole{
+		instructions: append([]mipsInstruction{}, p.instructions...),
+		aliases:      make(map[string]string),
+	}
+	for i := range o.instructions {
+		in, _, ok := o.code(i)
+		if !ok || in.cmd != alias {
+			continue
+		}
+		if target, found := o.aliases[in.args[0]]; found && target != in.args[1] {
+			o.aliases[in.args[0]] = ""
+			continue
+		}
+		o.aliases[in.args[0]] = in.args[1]
+	}
+	if o.lineSensitive() {
+		return p, nil
+	}
+
+	for o.step() {
+	}
+
+	return &MIPSProgram{instructions: o.instructions}, o.rewrites
+}
+
+// step applies the first rule matching the program and reports whether one did
+func (o *peephole) step() bool {
+	o.analyze()
+	for i := range o.instructions {
+		for _, rule := range peepholeRules {
+			e, ok := rule.match(o, i)
+			if !ok {
+				continue
+			}
+
+			rw := Rewrite{Rule: rule.name, Line: e.at + 1}
+			for _, in := range o.instructions[e.at : e.at+e.n] {
+				rw.Before = append(rw.Before, in.String())
+			}
+			for _, in := range e.with {
+				rw.After = append(rw.After, in.String())
+			}
+			o.rewrites = append(o.rewrites, rw)
+
+			tail := append(e.with, o.instructions[e.at+e.n:]...)
+			o.instructions = append(o.instructions[:e.at], tail...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineSensitive reports whether the program jumps by or to a number of lines, which removing lines would break.
+// Jumps to ra are fine, as ra is set by jal.
+func (o *peephole) lineSensitive() bool {
+	labels := make(map[string]bool)
+	for i, instruction := range o.instructions {
+		if label, ok := o.label(i); ok {
+			labels[label] = true
+		}
+		if raw, ok := instruction.(mipsRaw); ok && isRawCode(raw) {
+			if _, ok := parseRaw(raw); !ok {
+				return true
+			}
+		}
+	}
+
+	for i := range o.instructions {
+		in, op, ok := o.code(i)
+		if !ok {
+			continue
+		}
+		switch op.branch {
+		case relativeJump, relativeBranch:
+			return true
+		case jump, branch:
+			target := o.resolve(in.args[len(in.args)-1])
+			if !labels[target] && target != "ra" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// analyze finds the labels of the program and the registers live after each line
+func (o *peephole) analyze() {
+	o.labels = make(map[string]int)
+	for i := range o.instructions {
+		if label, ok := o.label(i); ok {
+			o.labels[label] = i
+		}
+	}
+
+	n := len(o.instructions)
+	o.liveOut = make([]regSet, n)
+	liveIn := make([]regSet, n+1)
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			var out regSet
+			next, known := o.successors(i)
+			if !known {
+				out = allRegs
+			}
+			for _, s := range next {
+				out |= liveIn[s]
+			}
+			use, def := o.access(o.instructions[i])
+			in := use | out&^def
+			if in != liveIn[i] || out != o.liveOut[i] {
+				liveIn[i], o.liveOut[i] = in, out
+				changed = true
+			}
+		}
+	}
+}
+
+// successors returns the lines that may run after line i. known is false if they are only known at run time.
+func (o *peephole) successors(i int) (next []int, known bool) {
+	in, op, ok := o.instr(i)
+	if !ok {
+		return []int{i + 1}, true
+	}
+
+	if op.branch != jump && op.effects&halts == 0 || op.effects&links != 0 {
+		next = append(next, i+1)
+	}
+	if op.branch == jump || op.branch == branch {
+		line, found := o.labels[o.resolve(in.args[len(in.args)-1])]
+		if !found {
+			return nil, false
+		}
+		next = append(next, line)
+	}
+
+	return next, true
+}
+
+// access returns the registers an instruction reads and writes.
+// Registers only known at run time may be any register.
+func (o *peephole) access(instruction mipsInstruction) (use, def regSet) {
+	switch in := instruction.(type) {
+	case *mipsInstructionN:
+		op := opcodes[in.cmd]
+		for n, kind := range op.operands {
+			switch kind {
+			case regOp:
+				if r, ok := o.register(in.args[n]); ok {
+					def |= 1 << r
+				} else {
+					use, def = allRegs, allRegs
+				}
+			case nameOp, aliasTargetOp, numberOp:
+			default:
+				use |= o.reads(in.args[n])
+			}
+		}
+		if op.effects&usesStack != 0 {
+			use |= 1 << spRegister
+			if in.cmd == push || in.cmd == pop {
+				def |= 1 << spRegister
+			}
+		}
+		if op.effects&links != 0 {
+			def |= 1 << raRegister
+		}
+	case mipsRaw:
+		if isRawCode(in) {
+			use = allRegs
+		}
+	}
+
+	return use, def
+}
+
+// resolve returns the register or device an alias stands for, "" if it's aliased several times
+func (o *peephole) resolve(arg string) string {
+	if target, found := o.aliases[arg]; found {
+		return target
+	}
+
+	return arg
+}
+
+// register returns the number of the register arg stands for, ok is false for indirect registers
+func (o *peephole) register(arg string) (r int, ok bool) {
+	arg = o.resolve(arg)
+	switch {
+	case arg == "sp":
+		return spRegister, true
+	case arg == "ra":
+		return raRegister, true
+	case registerRegexp.MatchString(arg) && !strings.HasPrefix(arg, "rr"):
+		r, err := strconv.Atoi(arg[1:])
+		return r, err == nil
+	}
+
+	return 0, false
+}
+
+// reads returns the registers read to get the value of arg
+func (o *peephole) reads(arg string) regSet {
+	target := o.resolve(arg)
+	if r, ok := o.register(target); ok {
+		return 1 << r
+	}
+	if deviceRegexp.MatchString(target) {
+		pin, _, _ := strings.Cut(target[1:], ":")
+		if strings.HasPrefix(pin, "r") {
+			return o.reads(pin)
+		}
+		return 0
+	}
+	if target == "" || registerRegexp.MatchString(target) {
+		return allRegs
+	}
+
+	return 0
+}
+
+// instr returns the instruction at line i, ok is false for labels and raw lines
+func (o *peephole) instr(i int) (*mipsInstructionN, opcode, bool) {
+	if i < 0 || i >= len(o.instructions) {
+		return nil, opcode{}, false
+	}
+	in, ok := o.instructions[i].(*mipsInstructionN)
+	if !ok {
+		return nil, opcode{}, false
+	}
+
+	return in, opcodes[in.cmd], true
+}
+
+// code returns the instruction at line i, parsing raw lines
+func (o *peephole) code(i int) (*mipsInstructionN, opcode, bool) {
+	if raw, ok := o.instructions[i].(mipsRaw); ok {
+		in, ok := parseRaw(raw)
+		if !ok || in == nil {
+			return nil, opcode{}, false
+		}
+		return in, opcodes[in.cmd], true
+	}
+
+	return o.instr(i)
+}
+
+// label returns the label declared at line i
+func (o *peephole) label(i int) (string, bool) {
+	switch in := o.instructions[i].(type) {
+	case mipsLabel:
+		return in.label, true
+	case mipsRaw:
+		tokens, _, _ := ic11.Tokenize(in.text)
+		if len(tokens) == 1 && strings.HasSuffix(tokens[0], ":") {
+			return strings.TrimSuffix(tokens[0], ":"), true
+		}
+	}
+
+	return "", false
+}
+
+// isComment reports whether line i is a comment or a blank line
+func (o *peephole) isComment(i int) bool {
+	raw, ok := o.instructions[i].(mipsRaw)
+	return ok && !isRawCode(raw)
+}
+
+// next returns the first line after line i that isn't a comment
+func (o *peephole) next(i int) int {
+	i++
+	for i < len(o.instructions) && o.isComment(i) {
+		i++
+	}
+
+	return i
+}
+
+// onlyLabels reports whether the lines from i to j, excluded, are labels and comments
+func (o *peephole) onlyLabels(i, j int) bool {
+	for ; i < j; i++ {
+		if _, ok := o.label(i); !ok && !o.isComment(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// comments returns the comments from line i to line j, excluded
+func (o *peephole) comments(i, j int) []mipsInstruction {
+	var comments []mipsInstruction
+	for ; i < j; i++ {
+		if o.isComment(i) {
+			comments = append(comments, o.instructions[i])
+		}
+	}
+
+	return comments
+}
+
+// isRawCode reports whether a raw line holds code rather than a comment
+func isRawCode(raw mipsRaw) bool {
+	tokens, _, _ := ic11.Tokenize(raw.text)
+	return len(tokens) > 0
+}
+
+// parseRaw parses a raw line. in is nil for comments and labels, ok is false for lines that aren't IC10 code.
+func parseRaw(raw mipsRaw) (in *mipsInstructionN, ok bool) {
+	tokens, _, err := ic11.Tokenize(raw.text)
+	switch {
+	case err != nil:
+		return nil, false
+	case len(tokens) == 0, len(tokens) == 1 && strings.HasSuffix(tokens[0], ":"):
+		return nil, true
+	}
+	in = newInstructionN(tokens[0], tokens[1:]...)
+	if in.check(anyScope{}) != nil {
+		return nil, false
+	}
+
+	return in, true
+}
+
+// Rules
+
+// selfMove removes moves of a register to itself
+// example:
+// move r0 r0
+// ->
+func selfMove(o *peephole, i int) (edit, bool) {
+	in, _, ok := o.instr(i)
+	if !ok || in.cmd != move {
+		return edit{}, false
+	}
+	dst, ok := o.register(in.args[0])
+	src, ok2 := o.register(in.args[1])
+	if !ok || !ok2 || dst != src {
+		return edit{}, false
+	}
+
+	return edit{at: i, n: 1}, true
+}
+
+// copyIntoDef writes the result of an instruction to the register it's moved to, if it isn't read later
+// example:
+// l r1 d0 Temperature
+// move r2 r1
+// ->
+// l r2 d0 Temperature
+func copyIntoDef(o *peephole, i int) (edit, bool) {
+	in, op, ok := o.instr(i)
+	if !ok || len(op.operands) == 0 || op.operands[0] != regOp || op.branch != noBranch {
+		return edit{}, false
+	}
+	x, ok := o.register(in.args[0])
+	if !ok {
+		return edit{}, false
+	}
+
+	m := o.next(i)
+	mv, _, ok := o.instr(m)
+	if !ok || mv.cmd != move {
+		return edit{}, false
+	}
+	y, ok := o.register(mv.args[0])
+	src, ok2 := o.register(mv.args[1])
+	if !ok || !ok2 || src != x || y == x || o.liveOut[m]&(1<<x) != 0 {
+		return edit{}, false
+	}
+
+	rewritten := newInstructionN(in.cmd, append([]string{mv.args[0]}, in.args[1:]...)...)
+	rewritten.volatile = in.volatile
+	with := []mipsInstruction{rewritten}
+	return edit{at: i, n: m - i + 1, with: append(with, o.comments(i+1, m)...)}, true
+}
+
+// copyIntoUse replaces a register moved to by its value in the next instruction, if it isn't read later
+// example:
+// move r3 500
+// sgt r4 r2 r3
+// ->
+// sgt r4 r2 500
+func copyIntoUse(o *peephole, i int) (edit, bool) {
+	in, _, ok := o.instr(i)
+	if !ok || in.cmd != move {
+		return edit{}, false
+	}
+	y, ok := o.register(in.args[0])
+	if !ok {
+		return edit{}, false
+	}
+
+	m := o.next(i)
+	next, op, ok := o.instr(m)
+	if !ok {
+		return edit{}, false
+	}
+	args := append([]string{}, next.args...)
+	replaced := false
+	for n, kind := range op.operands {
+		switch kind {
+		case valueOp, logicTypeOp, batchModeOp, reagentModeOp:
+			if r, ok := o.register(args[n]); ok && r == y {
+				args[n] = in.args[1]
+				replaced = true
+			}
+		}
+	}
+	if !replaced {
+		return edit{}, false
+	}
+
+	// The moved value must not be read in other ways, or after the instruction unless it overwrites the register
+	rewritten := newInstructionN(next.cmd, args...)
+	rewritten.volatile = next.volatile
+	use, _ := o.access(rewritten)
+	if use&(1<<y) != 0 {
+		return edit{}, false
+	}
+	if o.liveOut[m]&(1<<y) != 0 {
+		if dst, ok := o.register(next.args[0]); !ok || op.operands[0] != regOp || dst != y {
+			return edit{}, false
+		}
+	}
+
+	return edit{at: i, n: m - i + 1, with: append(o.comments(i+1, m), rewritten)}, true
+}
+
+// redundantLoad replaces a load by a move of the same value loaded earlier. Devices may change when the chip
+// waits, and any device may be the one written to, so stores, yield and sleep end the search.
+// example:
+// l r0 d0 Temperature
+// add r1 r0 1
+// l r2 d0 Temperature
+// ->
+// l r0 d0 Temperature
+// add r1 r0 1
+// move r2 r0
+func redundantLoad(o *peephole, i int) (edit, bool) {
+	in, _, ok := o.instr(i)
+	if !ok || in.cmd != l {
+		return edit{}, false
+	}
+	x, ok := o.register(in.args[0])
+	if !ok {
+		return edit{}, false
+	}
+
+	deps := o.reads(in.args[1]) | o.reads(in.args[2]) | 1<<x
+	for k := o.next(i); k < len(o.instructions); k = o.next(k) {
+		next, op, ok := o.instr(k)
+		if !ok || op.effects&(writesDevice|suspends|halts|declares|links) != 0 || op.branch == jump || op.branch == relativeJump {
+			return edit{}, false
+		}
+		if next.cmd == l && !next.volatile && next.args[1] == in.args[1] && next.args[2] == in.args[2] {
+			return edit{at: k, n: 1, with: []mipsInstruction{newInstructionN(move, next.args[0], in.args[0])}}, true
+		}
+		if _, def := o.access(next); def&deps != 0 {
+			return edit{}, false
+		}
+	}
+
+	return edit{}, false
+}
+
+// jumpNext removes jumps to the next line
+// example:
+// j _L3
+// _L3:
+// ->
+// _L3:
+func jumpNext(o *peephole, i int) (edit, bool) {
+	in, _, ok := o.instr(i)
+	if !ok || in.cmd != j {
+		return edit{}, false
+	}
+	target, found := o.labels[o.resolve(in.args[0])]
+	if !found || target <= i || !o.onlyLabels(i+1, target) {
+		return edit{}, false
+	}
+
+	return edit{at: i, n: 1}, true
+}
+
+// branchOverJump inverts a branch over a jump, so that it jumps where the jump does
+// example:
+// beqz r0 _L1
+// j _L2
+// _L1:
+// ->
+// bnez r0 _L2
+// _L1:
+func branchOverJump(o *peephole, i int) (edit, bool) {
+	in, op, ok := o.instr(i)
+	if !ok || op.branch != branch || op.effects&links != 0 {
+		return edit{}, false
+	}
+	inverse, found := inverseConditions[strings.TrimPrefix(in.cmd, "b")]
+	if !found {
+		return edit{}, false
+	}
+
+	m := o.next(i)
+	jmp, _, ok := o.instr(m)
+	if !ok || jmp.cmd != j {
+		return edit{}, false
+	}
+	last := len(in.args) - 1
+	target, found := o.labels[o.resolve(in.args[last])]
+	if !found || target <= m || !o.onlyLabels(m+1, target) {
+		return edit{}, false
+	}
+
+	inverted := newInstructionN("b"+inverse, append(append([]string{}, in.args[:last]...), jmp.args[0])...)
+	return edit{at: i, n: m - i + 1, with: append([]mipsInstruction{inverted}, o.comments(i+1, m)...)}, true
+}
+
+// jumpThread makes jumps to a jump go where the latter does
+// example:
+// beqz r0 _L1
+// ...
+// _L1:
+// j _L2
+// ->
+// beqz r0 _L2
+func jumpThread(o *peephole, i int) (edit, bool) {
+	in, op, ok := o.instr(i)
+	if !ok || op.branch != jump && op.branch != branch {
+		return edit{}, false
+	}
+	last := len(in.args) - 1
+	target := o.resolve(in.args[last])
+	final := o.thread(target)
+	if final == target {
+		return edit{}, false
+	}
+
+	args := append(append([]string{}, in.args[:last]...), final)
+	return edit{at: i, n: 1, with: []mipsInstruction{newInstructionN(in.cmd, args...)}}, true
+}
+
+// thread follows the jumps from label, and returns the label they end at. Jumps in a cycle are left alone.
+func (o *peephole) thread(label string) string {
+	visited := map[string]bool{label: true}
+	for current := label; ; {
+		line, found := o.labels[current]
+		if !found {
+			return current
+		}
+		for line < len(o.instructions) && o.onlyLabels(line, line+1) {
+			line++
+		}
+		jmp, _, ok := o.instr(line)
+		if !ok || jmp.cmd != j {
+			return current
+		}
+		next := o.resolve(jmp.args[0])
+		if _, found := o.labels[next]; !found {
+			return current
+		}
+		if visited[next] {
+			return label
+		}
+		visited[next] = true
+		current = next
+	}
+}
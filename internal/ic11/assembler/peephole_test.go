@@ -0,0 +1,97 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		want  string
+		rules []string
+	}{
+		{
+			name:  "jump to the next line",
+			src:   "j a\n# comment\na:\nyield\n",
+			want:  "# comment\na:\nyield\n",
+			rules: []string{"jump-next"},
+		},
+		{
+			name:  "branch over a jump",
+			src:   "a:\nbeqz r0 b\nj a\nb:\nyield\n",
+			want:  "a:\nbnez r0 a\nb:\nyield\n",
+			rules: []string{"branch-over-jump"},
+		},
+		{
+			name: "ordered comparisons are not inverted",
+			src:  "a:\nblt r0 r1 b\nj a\nb:\nyield\n",
+			want: "a:\nblt r0 r1 b\nj a\nb:\nyield\n",
+		},
+		{
+			name:  "jump to a jump",
+			src:   "beqz r0 a\nyield\na:\nj b\nyield\nb:\nhcf\n",
+			want:  "beqz r0 b\nyield\na:\nj b\nyield\nb:\nhcf\n",
+			rules: []string{"jump-thread"},
+		},
+		{
+			name: "jumps in a cycle",
+			src:  "a:\nj b\nyield\nb:\nj a\n",
+			want: "a:\nj b\nyield\nb:\nj a\n",
+		},
+		{
+			name:  "load of the same device",
+			src:   "l r0 d0 Temperature\nadd r1 r0 1\nl r2 d0 Temperature\ns d1 On r1\ns d1 Setting r2\n",
+			want:  "l r0 d0 Temperature\nadd r1 r0 1\nmove r2 r0\ns d1 On r1\ns d1 Setting r2\n",
+			rules: []string{"redundant-load"},
+		},
+		{
+			name: "load after yield",
+			src:  "l r0 d0 Temperature\nyield\nl r1 d0 Temperature\ns d1 On r0\ns d1 On r1\n",
+			want: "l r0 d0 Temperature\nyield\nl r1 d0 Temperature\ns d1 On r0\ns d1 On r1\n",
+		},
+		{
+			name:  "move of a result",
+			src:   "l r0 d0 Temperature\nmove r1 r0\ns d1 On r1\n",
+			want:  "l r1 d0 Temperature\ns d1 On r1\n",
+			rules: []string{"copy-into-def"},
+		},
+		{
+			name: "move of a result read later",
+			src:  "add r0 r2 1\nmove r1 r0\ns d1 On r0\ns d1 Setting r1\n",
+			want: "add r0 r2 1\nmove r1 r0\ns d1 On r0\ns d1 Setting r1\n",
+		},
+		{
+			name:  "move to a register written again",
+			src:   "alias x r1\nmove x 5\nadd x x 1\ns d1 On x\n",
+			want:  "alias x r1\nadd x 5 1\ns d1 On x\n",
+			rules: []string{"copy-into-use"},
+		},
+		{
+			name: "relative jumps",
+			src:  "j a\na:\njr 2\nyield\n",
+			want: "j a\na:\njr 2\nyield\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			optimized, rewrites := Peephole(program)
+			if got := optimized.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			var rules []string
+			for _, rewrite := range rewrites {
+				rules = append(rules, rewrite.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("got rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
@@ -1,10 +1,24 @@
 package assembler
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+// LineLimit is the number of lines a chip holds, and MaxLineLength the number of characters each of them holds
+const (
+	LineLimit     = 128
+	MaxLineLength = 90
 )
 
+var ErrTooManyLines = errors.New("program does not fit in a chip")
+var ErrLineTooLong = errors.New("line is too long")
+
 // A MIPS MIPSProgram is a list of MIPS instructions
 type MIPSProgram struct {
 	instructions []mipsInstruction
@@ -14,14 +28,86 @@ func NewMipsProgram() *MIPSProgram {
 	return &MIPSProgram{instructions: []mipsInstruction{}}
 }
 
-func (p *MIPSProgram) Emit(i mipsInstruction) {
+// Emit appends an instruction to the program. Instructions not matching the opcode table are rejected,
+// raw lines are copied verbatim.
+func (p *MIPSProgram) Emit(i mipsInstruction) error {
+	if err := i.check(anyScope{}); err != nil {
+		return err
+	}
 	p.instructions = append(p.instructions, i)
+	return nil
 }
 
 func (p *MIPSProgram) Get() []mipsInstruction {
 	return p.instructions
 }
 
+// Lines returns the number of lines the program takes in a chip
+func (p *MIPSProgram) Lines() int {
+	n := 0
+	for _, instruction := range p.instructions {
+		n += instruction.lines()
+	}
+
+	return n
+}
+
+// Validate checks that the program can be pasted into a chip: it fits, and the operands of its instructions,
+// inline assembly included, are valid with the labels, aliases and defines the program declares
+func (p *MIPSProgram) Validate() error {
+	if n := p.Lines(); n > LineLimit {
+		return fmt.Errorf("%w: %d lines, at most %d fit", ErrTooManyLines, n, LineLimit)
+	}
+	for n, instruction := range p.instructions {
+		if line := instruction.String(); len(line) > MaxLineLength {
+			return p.lineError(n, fmt.Errorf("%w: %d characters, at most %d fit: %s", ErrLineTooLong, len(line), MaxLineLength, line))
+		}
+	}
+
+	sc := newParsedProgram()
+	instructions := make([]*mipsInstructionN, len(p.instructions))
+	for n, instruction := range p.instructions {
+		switch i := instruction.(type) {
+		case mipsLabel:
+			sc.labels[i.label] = lexer.Position{Line: n + 1}
+		case *mipsInstructionN:
+			instructions[n] = i
+		case mipsRaw:
+			tokens, _, err := ic11.Tokenize(i.text)
+			switch {
+			case err != nil:
+				return p.lineError(n, err)
+			case len(tokens) == 1 && strings.HasSuffix(tokens[0], ":"):
+				sc.labels[strings.TrimSuffix(tokens[0], ":")] = lexer.Position{Line: n + 1}
+			case len(tokens) > 0:
+				instructions[n] = newInstructionN(tokens[0], tokens[1:]...)
+			}
+		}
+		if instructions[n] != nil {
+			sc.declare(instructions[n])
+		}
+	}
+	for n, instruction := range instructions {
+		if instruction == nil {
+			continue
+		}
+		if err := instruction.check(sc); err != nil {
+			return p.lineError(n, err)
+		}
+	}
+
+	return nil
+}
+
+// lineError reports err on line n. Lines of inline assembly are reported at the block they come from.
+func (p *MIPSProgram) lineError(n int, err error) error {
+	if raw, ok := p.instructions[n].(mipsRaw); ok && raw.pos.Line != 0 {
+		return diagnostic.Wrap(raw.pos, err)
+	}
+
+	return fmt.Errorf("line %d: %w", n+1, err)
+}
+
 func (p *MIPSProgram) String() string {
 	var b strings.Builder
 	for _, instruction := range p.instructions {
@@ -0,0 +1,15 @@
+package ic11
+
+// BatchModes are the names of the ways batch instructions combine the values of devices, by their numeric values
+var BatchModes = []string{"Average", "Sum", "Minimum", "Maximum"}
+
+// IsBatchMode reports whether s is the name of a batch mode
+func IsBatchMode(s string) bool {
+	for _, mode := range BatchModes {
+		if mode == s {
+			return true
+		}
+	}
+
+	return false
+}
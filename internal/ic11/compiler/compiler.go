@@ -1,50 +1,161 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/greg2010/ic11c/internal/ic11/assembler"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
 	"github.com/greg2010/ic11c/internal/ic11/ir"
 	"github.com/greg2010/ic11c/internal/ic11/parser"
+	"github.com/greg2010/ic11c/internal/ic11/preprocessor"
 	"github.com/greg2010/ic11c/internal/ic11/regassign"
 )
 
+// ErrInternal is reported when a stage of the compiler panics, instead of crashing the caller
+var ErrInternal = errors.New("internal compiler error")
+
 type Compiler struct {
-	ast *parser.AST
-	ir  *ir.Frontend
+	ast   *parser.AST
+	ir    *ir.Frontend
+	diags *diagnostic.Collector
+	// program is the IC10 code given instead of µC, nil when compiling µC
+	program  *assembler.MIPSProgram
+	optimize int
+	rewrites []assembler.Rewrite
+}
+
+// Options configure all stages of the compiler
+type Options struct {
+	Preprocessor preprocessor.Config
+	Frontend     ir.FrontendOptions
+	Diagnostics  diagnostic.Options
+	// Optimize is the optimization level, 0 disables all optimizations.
+	// Level 1 rewrites the IC10 code with peephole rules, level 2 also eliminates common subexpressions in IR.
+	Optimize int
 }
 
-func New(files []io.Reader) (*Compiler, error) {
-	ast, err := parser.Parse(files)
+// OptimizationLevels are the supported values of Options.Optimize
+var OptimizationLevels = []int{0, 1, 2}
+
+// New parses and compiles files to IR.
+// If compilation fails, the returned error is a diagnostic.List with all errors and warnings found.
+func New(files []io.Reader, opts Options) (c *Compiler, err error) {
+	diags := diagnostic.NewCollector(opts.Diagnostics)
+	defer func() {
+		if r := recover(); r != nil {
+			diags.Report(lexer.Position{}, fmt.Errorf("%w: %v", ErrInternal, r))
+			c, err = nil, diags.Err()
+		}
+	}()
+
+	// Whatever could be parsed is compiled even after syntax errors, so that semantic errors are reported in the same run
+	ast, _ := parser.Parse(files, opts.Preprocessor, diags)
+	ir, err := ir.NewFrontend(ast, opts.Frontend, diags)
 	if err != nil {
 		return nil, err
 	}
 
-	ir, err := ir.NewFrontend(ast)
+	return &Compiler{
+		ast:      ast,
+		ir:       ir,
+		diags:    diags,
+		optimize: opts.Optimize,
+	}, nil
+}
+
+// NewIC10 parses hand-written IC10 code, so that it goes through the same checks as compiled code.
+// If parsing fails, the returned error is a diagnostic.List with all errors found.
+func NewIC10(files []io.Reader, opts Options) (*Compiler, error) {
+	diags := diagnostic.NewCollector(opts.Diagnostics)
+	program, err := assembler.Parse(files, diags)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Compiler{
-		ast: ast,
-		ir:  ir,
+		diags:    diags,
+		program:  program,
+		optimize: opts.Optimize,
 	}, nil
 }
 
+// IR returns the program compiled to IR, nil if the input is IC10 code
+func (c *Compiler) IR() *ir.Program {
+	if c.ir == nil {
+		return nil
+	}
+
+	return c.ir.Get()
+}
+
+// Rewrites returns the peephole rules applied to the code by the last call to Assemble
+func (c *Compiler) Rewrites() []assembler.Rewrite {
+	return c.rewrites
+}
+
+// Diagnostics returns the warnings reported during compilation
+func (c *Compiler) Diagnostics() diagnostic.List {
+	return c.diags.Diagnostics()
+}
+
 func (c *Compiler) Compile() (string, error) {
 	var b strings.Builder
-	fmt.Fprintln(&b, "raw IR:")
-	b.WriteString(c.ir.String())
-	asm, err := assembler.New(c.ir.Get(), regassign.NewDummyAssigner(c.ir.Get()))
+	if c.ir != nil {
+		fmt.Fprintln(&b, "raw IR:")
+		b.WriteString(c.ir.String())
+	}
+	asm, err := c.Assemble()
 	if err != nil {
 		return "", err
 	}
 	fmt.Fprintln(&b, "MIPS:")
-	b.WriteString(asm.String())
+	b.WriteString(asm)
 	//fmt.Fprintln(&b, "Block view:")
 	//blockProg := ir.NewBlockProgram(c.ir.Get())
 	//b.WriteString(blockProg.String())
 	return b.String(), nil
 }
+
+// Assemble compiles IR to IC10 code, ready to be pasted into the game or run by the emulator.
+// The code is checked to fit in a chip.
+func (c *Compiler) Assemble() (out string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.diags.Report(lexer.Position{}, fmt.Errorf("%w: %v", ErrInternal, r))
+			out, err = "", c.diags.Err()
+		}
+	}()
+
+	program := c.program
+	if program == nil {
+		prog := c.ir.Get()
+		if c.optimize >= 2 {
+			var err error
+			prog, err = ir.EliminateCommonSubexpressions(prog)
+			if err != nil {
+				c.diags.Report(lexer.Position{}, err)
+				return "", c.diags.Err()
+			}
+		}
+		asm, err := assembler.New(prog, regassign.NewDummyAssigner(prog))
+		if err != nil {
+			c.diags.Report(lexer.Position{}, err)
+			return "", c.diags.Err()
+		}
+		program = asm.Program()
+	}
+	if c.optimize > 0 {
+		program, c.rewrites = assembler.Peephole(program)
+	}
+
+	if err := program.Validate(); err != nil {
+		c.diags.Report(lexer.Position{}, err)
+		return "", c.diags.Err()
+	}
+
+	return program.String(), nil
+}
@@ -0,0 +1,25 @@
+package compiler
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/testutil"
+)
+
+func FuzzCompile(f *testing.F) {
+	testutil.AddSeeds(f, "testdata/*.uc")
+	f.Fuzz(func(t *testing.T, src string) {
+		for _, level := range OptimizationLevels {
+			c, err := New([]io.Reader{strings.NewReader(src)}, Options{Optimize: level})
+			if err == nil {
+				_, err = c.Compile()
+			}
+			if errors.Is(err, ErrInternal) {
+				t.Fatalf("-O%d: %v", level, err)
+			}
+		}
+	})
+}
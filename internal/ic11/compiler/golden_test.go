@@ -0,0 +1,63 @@
+package compiler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/testutil"
+)
+
+// TestGolden compiles every program in testdata and compares the IR, the IC10 code and the diagnostics
+// with the golden files next to it: name.ir, name.ic10 and name.diag. Empty outputs have no golden file.
+// Run with -update to regenerate the golden files.
+func TestGolden(t *testing.T) {
+	sources, err := filepath.Glob("testdata/*.uc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		source := source
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			outputs := compileGolden(t, source)
+			for _, ext := range []string{".ir", ".ic10", ".diag"} {
+				testutil.CheckGolden(t, strings.TrimSuffix(source, ".uc")+ext, outputs[ext])
+			}
+		})
+	}
+}
+
+// compileGolden compiles source with the default options of the command line and returns the outputs by golden file extension
+func compileGolden(t *testing.T, source string) map[string]string {
+	f, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	outputs := make(map[string]string)
+	c, err := New([]io.Reader{f}, Options{Optimize: 2})
+	if err == nil {
+		outputs[".ir"] = c.IR().String()
+		outputs[".ic10"], err = c.Assemble()
+	}
+
+	var diags diagnostic.List
+	switch {
+	case errors.As(err, &diags):
+	case err != nil:
+		t.Fatal(err)
+	default:
+		diags = c.Diagnostics()
+	}
+	if len(diags) > 0 {
+		outputs[".diag"] = diags.Error() + "\n"
+	}
+
+	return outputs
+}
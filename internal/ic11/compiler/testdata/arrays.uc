@@ -0,0 +1,11 @@
+int values[3];
+
+void main(void) {
+	int i;
+	i = 0;
+	while (i < 3) {
+		values[i] = load(d0, "Setting") * i;
+		i++;
+	}
+	store(d1, "Setting", values[2]);
+}
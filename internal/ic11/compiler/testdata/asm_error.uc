@@ -0,0 +1,8 @@
+void main(void) {
+	float a;
+	a = load(d0, "Setting");
+	asm(a) {
+		add r2 %0 N
+	}
+	store(d0, "Setting", a);
+}
@@ -0,0 +1,26 @@
+void main(void) {
+	float a;
+	float b;
+	asm {
+		move r0 0
+		move r1 1
+		move r2 2
+		move r3 3
+		move r4 4
+		move r5 5
+		move r6 6
+		move r7 7
+		move r8 8
+		move r9 9
+		move r10 10
+		move r11 11
+		move r12 12
+		move r13 13
+		move r14 14
+	}
+	asm(a) {
+		push %0
+		pop r15
+	}
+	b = a;
+}
@@ -0,0 +1,11 @@
+const string Mode = "Average";
+
+void main(void) {
+	float x;
+	x = load(d6, "Temperature");
+	x = load(d0, "Temperature)");
+	store_batch("Pump", "On", 1);
+	x = load_batch(hash("StructurePipeAnalysizer"), "Pressure", "Mean");
+	x = load_batch(hash("StructurePipeAnalysizer"), "Pressure", Mode);
+	x = "Cooling";
+}
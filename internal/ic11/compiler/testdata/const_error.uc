@@ -0,0 +1,8 @@
+void main(void) {
+	const int Zero = 0;
+	float x;
+	x = 1 / Zero;
+	store(d0, "Setting", x % 0);
+	store(d0, "Ratio", 2.5 % (1 - 1));
+	store(d0, "Mode", sin(1, 2));
+}
@@ -0,0 +1,14 @@
+const int Mask = 0xFF;
+const int Flags = 0b1010;
+const float Scale = 1.5e3;
+const int Pump = hash("StructureVolumePump");
+const int Half = 7 / 2;
+
+void main(void) {
+	store(d0, "Setting", Mask & Flags);
+	store(d0, "Ratio", Scale / 3);
+	store(d0, "Mode", 7 / 2);
+	store(d0, "Setting", Half);
+	store_batch(Pump, "On", 1);
+	store(d1, "Setting", load_batch(Pump, "Pressure", "Average"));
+}
@@ -0,0 +1,12 @@
+void main(void) {
+	float t;
+	while (1) {
+		t = load(d0, "Temperature");
+		if (t > 500) {
+			store(d1, "On", 0);
+		} else {
+			store(d1, "On", 1);
+		}
+		yield();
+	}
+}
@@ -0,0 +1,17 @@
+void main(void) {
+	float a;
+	float b;
+	float c;
+	volatile float v;
+	while (1) {
+		a = load(d0, "Temperature");
+		b = load(d0, "Temperature") * 2 + a;
+		v = load(d0, "Temperature");
+		store(d1, "Setting", a * 2 + a);
+		c = load(d0, "Temperature");
+		store(d0, "On", 1);
+		c = c + load(d0, "Temperature");
+		store(d1, "Setting", b + c + v);
+		yield();
+	}
+}
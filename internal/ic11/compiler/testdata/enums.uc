@@ -0,0 +1,10 @@
+enum Mode { Idle, Heating, Cooling = 5 };
+
+void main(void) {
+	enum Mode m;
+	m = Heating;
+	if (m == Cooling) {
+		store(d0, "Mode", Cooling);
+	}
+	store(d0, "Setting", m);
+}
@@ -0,0 +1,10 @@
+void main(void) {
+	float t;
+	t = load(d0, "Temperature");
+	asm(t) {
+		# Kelvin to Celsius
+		sub %0 %0 273.15
+	}
+	store(d1, "Setting", t);
+	asm("s db Setting %0", t);
+}
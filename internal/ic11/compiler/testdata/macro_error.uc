@@ -0,0 +1,12 @@
+#define VERYLONGNAME 1
+#define SUM(a, b) ((a) + (b))
+
+void main(void) {
+	float y;
+	y = VERYLONGNAME + q[2];
+	y = SUM(y, \
+		q[1]);
+	store(d0, "Setting", SUM(VERYLONGNAME, q[0]));
+	y = SUM(y,
+		q[3]) + q[4];
+}
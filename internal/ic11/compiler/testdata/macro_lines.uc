@@ -0,0 +1,15 @@
+#define CLAMP(v, lo, hi) max(lo, min(v, hi))
+#define db d1
+
+void main(void) {
+	float t;
+	t = CLAMP(load(d0, "Temperature"),
+	          273.15,
+	          t + 10);
+	store(d1, "Setting", CLAMP(t,
+		0, 100)); store(db, "On", 1);
+	asm(t) { s db Setting %0 }
+	asm(t) {
+		s db Setting %0
+	} store(db, "Mode", 1);
+}
@@ -0,0 +1,12 @@
+#define SENSOR d0
+#define THRESHOLD(x) ((x) * 2)
+#ifdef DEBUG
+#define LOG(v) store(db, "Setting", v)
+#else
+#define LOG(v)
+#endif
+
+void main(void) {
+	store(d1, "Setting", THRESHOLD(load(SENSOR, "Pressure")));
+	LOG(1);
+}
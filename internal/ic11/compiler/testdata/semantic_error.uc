@@ -0,0 +1,14 @@
+enum Color { Red, Green };
+enum Shape { Circle };
+const int Size = 4;
+const int Size = 5;
+
+void main(void) {
+	enum Color c;
+	c = Circle;
+	store(x, "On", 1);
+	store(d0, "On");
+}
+
+int buf[2];
+int buf[4];
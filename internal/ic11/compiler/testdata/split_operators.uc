@@ -0,0 +1,8 @@
+void main(void) {
+	int a;
+	a = = 3;
+	a = a < = 2;
+	a = a > > 1;
+	a + = 1;
+	a = a <= 2 || a >>> 1 != 0;
+}
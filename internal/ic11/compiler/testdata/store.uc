@@ -0,0 +1,4 @@
+void main(void) {
+	store(d0, "On", 1);
+	store(d1, "Setting", load(d0, "Temperature"));
+}
@@ -0,0 +1,6 @@
+void main(void) {
+	int a;
+	a = 1 +;
+	store(d0, "On" 1);
+	a = 2;
+}
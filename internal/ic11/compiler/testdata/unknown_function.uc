@@ -0,0 +1,8 @@
+void main(void) {
+	float x;
+	x = F(load(d0, "Setting"));
+	yield(1);
+	x = sleep(1);
+	store(d0, "Setting", hash(x));
+	max(x, 1);
+}
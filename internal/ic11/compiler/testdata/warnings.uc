@@ -0,0 +1,15 @@
+int count;
+
+void unused(void) {
+}
+
+void main(void) {
+	int count;
+	int idle;
+	count = 1;
+	count = 2;
+	store(d0, "Setting", count);
+	while (1) {
+		store(d0, "On", 1);
+	}
+}
@@ -0,0 +1,252 @@
+package decompiler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+)
+
+// A Block is a basic block of IC10 code: a sequence of instructions with a single entry and a single exit.
+// A jump, if any, is the last instruction of the block.
+type Block struct {
+	ID int
+	// Line is the line of the source the block starts at
+	Line int
+
+	code   []*emulator.Instruction
+	branch *emulator.Instruction
+	next   []*Block
+	prev   []*Block
+}
+
+// Next returns the successors of the block. For blocks ending with a conditional branch, the first one is
+// the block jumped to and the second one is the block that follows. nil successors stand for the end of the program.
+func (b *Block) Next() []*Block {
+	return b.next
+}
+
+// Code returns the instructions of the block, without the jump ending it
+func (b *Block) Code() []*emulator.Instruction {
+	return b.code
+}
+
+// Branch returns the jump ending the block, nil if the block falls through to the next one
+func (b *Block) Branch() *emulator.Instruction {
+	return b.branch
+}
+
+func (b *Block) String() string {
+	return fmt.Sprint(b.ID)
+}
+
+// CFG is the control flow graph of an IC10 program, made of the basic blocks reachable from its first line
+type CFG struct {
+	entrypoint *Block
+	blocks     []*Block
+}
+
+// NewCFG splits the program into basic blocks and links them by the jumps between them
+func NewCFG(p *emulator.Program) (*CFG, error) {
+	// Blocks start at the first line, at every label and jump target, and after every jump
+	leaders := map[int]bool{0: true}
+	for _, line := range p.Labels {
+		leaders[line] = true
+	}
+	targets := make(map[int]int)
+	for n, instr := range p.Lines {
+		if instr == nil || !isBranch(instr) {
+			continue
+		}
+		target, err := branchTarget(p, n, instr)
+		if err != nil {
+			return nil, diagnostic.Wrap(lexer.Position{Line: n + 1}, fmt.Errorf("%s: %w", instr, err))
+		}
+		targets[n] = target
+		leaders[target] = true
+		leaders[n+1] = true
+	}
+
+	starts := []int{}
+	for line := range leaders {
+		if line < len(p.Lines) {
+			starts = append(starts, line)
+		}
+	}
+	sort.Ints(starts)
+
+	cfg := &CFG{}
+	byLine := make(map[int]*Block)
+	for i, start := range starts {
+		end := len(p.Lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		b := &Block{ID: i, Line: start}
+		for _, instr := range p.Lines[start:end] {
+			switch {
+			case instr == nil:
+			case isBranch(instr):
+				b.branch = instr
+			default:
+				b.code = append(b.code, instr)
+			}
+		}
+		cfg.blocks = append(cfg.blocks, b)
+		byLine[start] = b
+	}
+
+	for i, b := range cfg.blocks {
+		end := len(p.Lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if b.branch != nil {
+			b.link(byLine[targets[end-1]])
+			if b.branch.Op == "j" || b.branch.Op == "jr" {
+				continue
+			}
+		}
+		b.link(byLine[end])
+	}
+
+	cfg.entrypoint = byLine[0]
+	cfg.removeUnreachable()
+	return cfg, nil
+}
+
+func (b *Block) link(next *Block) {
+	b.next = append(b.next, next)
+	if next != nil {
+		next.prev = append(next.prev, b)
+	}
+}
+
+// removeUnreachable drops the blocks that can't be reached from the entrypoint, e.g. the code after a jump
+func (cfg *CFG) removeUnreachable() {
+	reachable := make(map[*Block]bool)
+	for _, b := range cfg.Preorder() {
+		reachable[b] = true
+	}
+
+	blocks := []*Block{}
+	for _, b := range cfg.blocks {
+		if !reachable[b] {
+			continue
+		}
+		prev := []*Block{}
+		for _, p := range b.prev {
+			if reachable[p] {
+				prev = append(prev, p)
+			}
+		}
+		b.prev = prev
+		blocks = append(blocks, b)
+	}
+	cfg.blocks = blocks
+}
+
+// Entrypoint returns the block starting at the first line of the IC10 program, where the chip starts executing.
+// The block holds no instructions when the program has no code, e.g. a file of comments.
+func (cfg *CFG) Entrypoint() *Block {
+	return cfg.entrypoint
+}
+
+// Blocks returns the blocks in the order of the source
+func (cfg *CFG) Blocks() []*Block {
+	return cfg.blocks
+}
+
+// Preorder returns the blocks reachable from the entrypoint in depth first preorder
+func (cfg *CFG) Preorder() []*Block {
+	seen := make(map[*Block]bool)
+	order := []*Block{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if b == nil || seen[b] {
+			return
+		}
+		seen[b] = true
+		order = append(order, b)
+		for _, next := range b.next {
+			visit(next)
+		}
+	}
+	visit(cfg.entrypoint)
+
+	return order
+}
+
+func (cfg *CFG) String() string {
+	var b strings.Builder
+	for _, block := range cfg.blocks {
+		fmt.Fprintf(&b, "block %d (line %d) -> %v\n", block.ID, block.Line, block.next)
+		for _, instr := range block.code {
+			fmt.Fprintf(&b, "\t%s\n", instr)
+		}
+		if block.branch != nil {
+			fmt.Fprintf(&b, "\t%s\n", block.branch)
+		}
+	}
+
+	return b.String()
+}
+
+// isBranch reports whether the instruction may transfer control to another line
+func isBranch(instr *emulator.Instruction) bool {
+	switch {
+	case instr.Op == "j", instr.Op == "jr", instr.Op == "jal":
+		return true
+	case strings.HasPrefix(instr.Op, "br") && branchConditions[strings.TrimPrefix(instr.Op, "br")]:
+		return true
+	case strings.HasPrefix(instr.Op, "b"):
+		return branchConditions[strings.TrimSuffix(strings.TrimPrefix(instr.Op, "b"), "al")]
+	}
+
+	return false
+}
+
+// branchConditions are the conditions of IC10 branches: b<condition>, br<condition> and b<condition>al
+var branchConditions = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"eqz": true, "nez": true, "ltz": true, "lez": true, "gtz": true, "gez": true,
+	"ap": true, "na": true, "apz": true, "naz": true, "dse": true, "dns": true,
+}
+
+// branchTarget returns the line the instruction at line n jumps to. Lines past the end of the program are clamped to it.
+func branchTarget(p *emulator.Program, n int, instr *emulator.Instruction) (int, error) {
+	if instr.Op == "j" && instr.Args[0] == "ra" {
+		return 0, fmt.Errorf("%w: return from a subroutine", ErrUnsupported)
+	}
+	if instr.Op == "jal" || strings.HasSuffix(instr.Op, "al") && !strings.HasPrefix(instr.Op, "br") {
+		return 0, fmt.Errorf("%w: subroutine call", ErrUnsupported)
+	}
+
+	arg := instr.Args[len(instr.Args)-1]
+	var target float64
+	if line, found := p.Labels[arg]; found {
+		target = float64(line)
+	} else if v, found := p.Defines[arg]; found {
+		target = v
+	} else {
+		v, err := emulator.ParseNumber(arg)
+		if err != nil {
+			return 0, fmt.Errorf("%w: jump target %s", ErrUnsupported, arg)
+		}
+		target = v
+	}
+	if instr.Op == "jr" || strings.HasPrefix(instr.Op, "br") {
+		target += float64(n)
+	}
+
+	if target != math.Trunc(target) || target < 0 {
+		return 0, fmt.Errorf("%w: jump target %s", ErrUnsupported, arg)
+	}
+
+	return int(math.Min(target, float64(len(p.Lines)))), nil
+}
@@ -0,0 +1,549 @@
+package decompiler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+)
+
+var ErrUnsupported = errors.New("unsupported IC10 code")
+var ErrUnstructured = errors.New("control flow cannot be expressed in µC")
+
+var (
+	identRegexp    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	registerRegexp = regexp.MustCompile(`^r(1[0-5]|[0-9])$`)
+	indirectRegexp = regexp.MustCompile(`^r+[0-9]+$|^(sp|ra)$`)
+	deviceRegexp   = regexp.MustCompile(`^d([0-5]|b)(:[0-9])?$`)
+)
+
+// reserved are the words µC doesn't allow as names of variables, constants and macros
+var reserved = map[string]bool{
+	"int": true, "float": true, "string": true, "void": true, "const": true, "enum": true,
+	"if": true, "else": true, "while": true, "return": true, "asm": true, "main": true, "hash": true,
+	"load": true, "load_batch": true, "store": true, "store_batch": true, "yield": true, "sleep": true, "hcf": true,
+	"rand": true, "sin": true, "cos": true, "tan": true, "abs": true, "acos": true, "asin": true, "atan": true,
+	"ceil": true, "floor": true, "log": true, "sqrt": true, "round": true, "trunc": true,
+	"mod": true, "xor": true, "nor": true, "max": true, "min": true, "defined": true, "volatile": true,
+}
+
+// binaryOps maps IC10 instructions to the µC operators computing the same value
+var binaryOps = map[string]string{
+	"add": "+", "sub": "-", "mul": "*", "div": "/", "mod": "%",
+	"and": "&", "or": "|", "xor": "^", "sll": "<<", "sla": "<<", "sra": ">>", "srl": ">>>",
+	"seq": "==", "sne": "!=", "slt": "<", "sle": "<=", "sgt": ">", "sge": ">=",
+	"seqz": "==", "snez": "!=", "sltz": "<", "slez": "<=", "sgtz": ">", "sgez": ">=",
+}
+
+// functions maps IC10 instructions to the µC builtins computing the same value, by the number of their operands
+var functions = map[string]int{
+	"rand": 0,
+	"abs":  1, "acos": 1, "asin": 1, "atan": 1, "ceil": 1, "cos": 1, "floor": 1,
+	"log": 1, "round": 1, "sin": 1, "sqrt": 1, "tan": 1, "trunc": 1,
+	"max": 2, "min": 2, "nor": 2,
+}
+
+// condition is the µC comparison a branch makes, and its negation. Ordered comparisons have none, as neither a < b
+// nor a >= b holds if a or b is NaN, they are negated with !.
+type condition struct {
+	op, negated string
+}
+
+// conditions are the branch conditions that can be expressed in µC. Conditions ending with z compare with zero.
+var conditions = map[string]condition{
+	"eq": {"==", "!="}, "ne": {"!=", "=="}, "lt": {"<", ""}, "le": {"<=", ""}, "gt": {">", ""}, "ge": {">=", ""},
+	"eqz": {"==", "!="}, "nez": {"!=", "=="}, "ltz": {"<", ""}, "lez": {"<=", ""}, "gtz": {">", ""}, "gez": {">=", ""},
+}
+
+type decompiler struct {
+	program *emulator.Program
+	cfg     *CFG
+	// aliases maps alias names to registers and devices, names maps registers and devices to µC names
+	aliases map[string]string
+	names   map[string]string
+	// consts maps defines to the µC constants declared for them
+	consts  map[string]string
+	taken   map[string]bool
+	vars    []string
+	used    map[string]bool
+	loops   map[*Block]*loop
+	active  []*loop
+	visited map[*Block]bool
+	ipdom   map[*Block]*Block
+}
+
+// Decompile translates IC10 code to µC. Jumps are rebuilt as if and while statements, aliases of devices become
+// macros and aliases of registers become variables. Instructions µC has no equivalent for are kept as inline assembly.
+// Programs whose control flow can't be rebuilt are kept as inline assembly altogether.
+func Decompile(src string) (string, error) {
+	p, err := emulator.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := decompile(p)
+	if errors.Is(err, ErrUnsupported) || errors.Is(err, ErrUnstructured) {
+		return fallback(p, err), nil
+	}
+
+	return out, err
+}
+
+func decompile(p *emulator.Program) (string, error) {
+	cfg, err := NewCFG(p)
+	if err != nil {
+		return "", err
+	}
+	d := &decompiler{
+		program: p,
+		cfg:     cfg,
+		aliases: make(map[string]string),
+		names:   make(map[string]string),
+		consts:  make(map[string]string),
+		taken:   make(map[string]bool),
+		used:    make(map[string]bool),
+		loops:   make(map[*Block]*loop),
+		visited: make(map[*Block]bool),
+	}
+	d.declareNames()
+
+	var body []stmt
+	if cfg.entrypoint != nil {
+		if err := d.analyze(); err != nil {
+			return "", err
+		}
+		body, err = d.region(cfg.entrypoint, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var b strings.Builder
+	d.writeHeader(&b)
+	b.WriteString("void main(void) {\n")
+	for _, v := range d.vars {
+		fmt.Fprintf(&b, "\tfloat %s;\n", v)
+	}
+	if len(d.vars) > 0 && len(body) > 0 {
+		b.WriteString("\n")
+	}
+	writeStmts(&b, body, "\t")
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+// declareNames picks the µC names of aliases and defines. Names that are reused for several registers or devices,
+// or that aren't valid in µC, are dropped in favor of the register or device itself.
+func (d *decompiler) declareNames() {
+	targets := make(map[string]map[string]bool)
+	names := make(map[string]map[string]bool)
+	for _, instr := range d.program.Lines {
+		if instr == nil || instr.Op != "alias" {
+			continue
+		}
+		name, target := instr.Args[0], instr.Args[1]
+		if targets[name] == nil {
+			targets[name] = make(map[string]bool)
+		}
+		if names[target] == nil {
+			names[target] = make(map[string]bool)
+		}
+		targets[name][target] = true
+		names[target][name] = true
+	}
+	for name, t := range targets {
+		for target := range t {
+			if len(t) == 1 && len(names[target]) == 1 && (registerRegexp.MatchString(target) || deviceRegexp.MatchString(target)) {
+				d.aliases[name] = target
+				if d.validName(name) {
+					d.names[target] = name
+					d.taken[name] = true
+				}
+			}
+		}
+	}
+
+	for name := range d.program.Defines {
+		if _, found := d.aliases[name]; !found && d.validName(name) {
+			d.consts[name] = name
+			d.taken[name] = true
+		}
+	}
+}
+
+// validName reports whether name can be used in µC and doesn't clash with registers and devices
+func (d *decompiler) validName(name string) bool {
+	return identRegexp.MatchString(name) && !reserved[name] && !d.taken[name] &&
+		!registerRegexp.MatchString(name) && !deviceRegexp.MatchString(name)
+}
+
+// newName returns a name with the prefix that is not used yet
+func (d *decompiler) newName(prefix string) string {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		if d.validName(name) {
+			d.taken[name] = true
+			return name
+		}
+	}
+}
+
+// use declares the variable on its first use
+func (d *decompiler) use(v string) {
+	if !d.used[v] {
+		d.used[v] = true
+		d.vars = append(d.vars, v)
+	}
+}
+
+// writeHeader writes the macros of device aliases and the constants of defines
+func (d *decompiler) writeHeader(b *strings.Builder) {
+	devices := []string{}
+	for target, name := range d.names {
+		if deviceRegexp.MatchString(target) {
+			devices = append(devices, fmt.Sprintf("#define %s %s\n", name, target))
+		}
+	}
+	sort.Strings(devices)
+
+	consts := []string{}
+	for _, instr := range d.program.Lines {
+		if instr == nil || instr.Op != "define" || d.consts[instr.Args[0]] == "" {
+			continue
+		}
+		typ := "float"
+		if v := d.program.Defines[instr.Args[0]]; v == math.Trunc(v) {
+			typ = "int"
+		}
+		consts = append(consts, fmt.Sprintf("const %s %s = %s;\n", typ, d.consts[instr.Args[0]], literal(instr.Args[1])))
+	}
+
+	for _, lines := range [][]string{devices, consts} {
+		for _, line := range lines {
+			b.WriteString(line)
+		}
+		if len(lines) > 0 {
+			b.WriteString("\n")
+		}
+	}
+}
+
+// code translates the instructions of the block, except the jump ending it
+func (d *decompiler) code(b *Block) ([]stmt, error) {
+	stmts := []stmt{}
+	for _, instr := range b.code {
+		s, err := d.statement(instr)
+		if errors.Is(err, ErrUnsupported) {
+			s, err = d.asm(instr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", b.Line+1, instr, err)
+		}
+		if s != "" {
+			stmts = append(stmts, simpleStmt(s))
+		}
+	}
+
+	return stmts, nil
+}
+
+// statement translates an instruction to a µC statement, empty for instructions that only declare names
+func (d *decompiler) statement(instr *emulator.Instruction) (string, error) {
+	op, args := instr.Op, instr.Args
+	if op == "alias" || op == "define" {
+		return "", nil
+	}
+
+	operands := []string{}
+	format := ""
+	switch {
+	case op == "move":
+		format = "%s = %s;"
+		operands = []string{d.register(args[0]), d.value(args[1])}
+	case binaryOps[op] != "" && strings.HasSuffix(op, "z") && len(args) == 2:
+		format = "%s = %s " + binaryOps[op] + " 0;"
+		operands = []string{d.register(args[0]), d.value(args[1])}
+	case binaryOps[op] != "" && len(args) == 3:
+		format = "%s = %s " + binaryOps[op] + " %s;"
+		operands = []string{d.register(args[0]), d.value(args[1]), d.value(args[2])}
+	case op == "not":
+		format = "%s = ~%s;"
+		operands = []string{d.register(args[0]), d.value(args[1])}
+	case op == "select":
+		format = "%s = %s ? %s : %s;"
+		operands = []string{d.register(args[0]), d.value(args[1]), d.value(args[2]), d.value(args[3])}
+	case op == "l":
+		format = "%s = load(%s, %s);"
+		operands = []string{d.register(args[0]), d.device(args[1]), logicType(args[2])}
+	case op == "s":
+		format = "store(%s, %s, %s);"
+		operands = []string{d.device(args[0]), logicType(args[1]), d.value(args[2])}
+	case op == "lb":
+		format = "%s = load_batch(%s, %s, %s);"
+		operands = []string{d.register(args[0]), d.value(args[1]), logicType(args[2]), batchMode(args[3])}
+	case op == "sb":
+		format = "store_batch(%s, %s, %s);"
+		operands = []string{d.constant(args[0]), logicType(args[1]), d.value(args[2])}
+	case op == "yield" || op == "hcf":
+		return op + "();", nil
+	case op == "sleep":
+		format = "sleep(%s);"
+		operands = []string{d.value(args[0])}
+	default:
+		n, found := functions[op]
+		if !found || len(args) != n+1 {
+			return "", ErrUnsupported
+		}
+		params := []string{}
+		for _, arg := range args[1:] {
+			params = append(params, d.value(arg))
+		}
+		format = "%s = " + op + "(%s);"
+		operands = []string{d.register(args[0]), strings.Join(params, ", ")}
+	}
+
+	values := []any{}
+	for _, operand := range operands {
+		if operand == "" {
+			return "", ErrUnsupported
+		}
+		values = append(values, operand)
+	}
+	// Variables are declared once the whole statement is translated, instructions kept as inline assembly declare their own
+	d.useVars(operands...)
+
+	return fmt.Sprintf(format, values...), nil
+}
+
+// asm keeps an instruction as inline assembly. Registers are bound to the variables they are translated to.
+func (d *decompiler) asm(instr *emulator.Instruction) (string, error) {
+	args := []string{}
+	bound := []string{}
+	for _, arg := range instr.Args {
+		if target, found := d.aliases[arg]; found {
+			arg = target
+		}
+		switch {
+		case registerRegexp.MatchString(arg):
+			v := d.register(arg)
+			index := len(bound)
+			for i, b := range bound {
+				if b == v {
+					index = i
+				}
+			}
+			if index == len(bound) {
+				bound = append(bound, v)
+			}
+			args = append(args, fmt.Sprintf("%%%d", index))
+			continue
+		case indirectRegexp.MatchString(arg) && arg != "sp" && arg != "ra":
+			return "", fmt.Errorf("%w: indirect register %s", ErrUnsupported, arg)
+		case d.isLabel(arg):
+			return "", fmt.Errorf("%w: label %s used as a value", ErrUnsupported, arg)
+		}
+		if v, found := d.program.Defines[arg]; found {
+			arg = formatNumber(v)
+		}
+		// Quotes can't appear in the code of inline assembly, and % starts references to its operands
+		if strings.ContainsAny(arg, "\"%") {
+			if v, err := emulator.ParseNumber(arg); err == nil {
+				arg = formatNumber(v)
+			}
+		}
+		args = append(args, arg)
+	}
+
+	d.useVars(bound...)
+	code := strings.Join(append([]string{instr.Op}, args...), " ")
+	return fmt.Sprintf("asm(%s);", strings.Join(append([]string{strconv.Quote(code)}, bound...), ", ")), nil
+}
+
+// condition translates the condition of a branch, either the one making it jump or its negation
+func (d *decompiler) condition(branch *emulator.Instruction, taken bool) (string, error) {
+	name := strings.TrimPrefix(branch.Op, "b")
+	if strings.HasPrefix(branch.Op, "br") {
+		name = strings.TrimPrefix(branch.Op, "br")
+	}
+	c, found := conditions[name]
+	if !found {
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, branch)
+	}
+
+	op, negate := c.op, false
+	if !taken && c.negated != "" {
+		op = c.negated
+	} else if !taken {
+		negate = true
+	}
+	operands := []string{}
+	for _, arg := range branch.Args[:len(branch.Args)-1] {
+		v := d.value(arg)
+		if v == "" {
+			return "", fmt.Errorf("%w: %s", ErrUnsupported, branch)
+		}
+		operands = append(operands, v)
+	}
+	d.useVars(operands...)
+	if len(operands) == 1 {
+		operands = append(operands, "0")
+	}
+
+	if negate {
+		return fmt.Sprintf("!(%s %s %s)", operands[0], op, operands[1]), nil
+	}
+	return fmt.Sprintf("%s %s %s", operands[0], op, operands[1]), nil
+}
+
+// register returns the variable a register is translated to, empty if arg is not a register
+func (d *decompiler) register(arg string) string {
+	if target, found := d.aliases[arg]; found {
+		arg = target
+	}
+	if !registerRegexp.MatchString(arg) {
+		return ""
+	}
+	if name, found := d.names[arg]; found {
+		return name
+	}
+
+	return arg
+}
+
+// value translates an operand read as a number, empty if it can't be expressed in µC
+func (d *decompiler) value(arg string) string {
+	if v := d.register(arg); v != "" {
+		return v
+	}
+	if name, found := d.consts[arg]; found {
+		return name
+	}
+	if v, found := d.program.Defines[arg]; found {
+		return formatNumber(v)
+	}
+	if d.isLabel(arg) || d.aliases[arg] != "" {
+		return ""
+	}
+	if _, err := emulator.ParseNumber(arg); err != nil {
+		return ""
+	}
+
+	return literal(arg)
+}
+
+// constant translates an operand that must be constant in µC, e.g. the prefab hash of store_batch
+func (d *decompiler) constant(arg string) string {
+	if d.register(arg) != "" {
+		return ""
+	}
+
+	return d.value(arg)
+}
+
+// device translates a device operand, using the macro of its alias
+func (d *decompiler) device(arg string) string {
+	if target, found := d.aliases[arg]; found {
+		arg = target
+	}
+	if !deviceRegexp.MatchString(arg) {
+		return ""
+	}
+	if name, found := d.names[arg]; found {
+		return name
+	}
+
+	return arg
+}
+
+// useVars declares the operands that are variables
+func (d *decompiler) useVars(operands ...string) {
+	for _, operand := range operands {
+		if registerRegexp.MatchString(operand) || registerRegexp.MatchString(d.aliases[operand]) && d.names[d.aliases[operand]] == operand {
+			d.use(operand)
+		}
+	}
+}
+
+func (d *decompiler) isLabel(arg string) bool {
+	_, found := d.program.Labels[arg]
+	return found
+}
+
+// logicType translates a logic type operand to a string, empty if it's given by number
+func logicType(arg string) string {
+	if !identRegexp.MatchString(arg) {
+		return ""
+	}
+
+	return strconv.Quote(arg)
+}
+
+// batchMode translates a batch mode operand, given either by name or by number, to a string
+func batchMode(arg string) string {
+	for i, mode := range ic11.BatchModes {
+		if arg == mode || arg == strconv.Itoa(i) {
+			return strconv.Quote(mode)
+		}
+	}
+
+	return ""
+}
+
+// literal translates a number literal. Hashes of known prefabs are written with hash().
+func literal(arg string) string {
+	if strings.HasPrefix(arg, `HASH("`) {
+		return "hash" + strings.TrimPrefix(arg, "HASH")
+	}
+
+	v, err := emulator.ParseNumber(arg)
+	if err != nil {
+		return arg
+	}
+	if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+		if name, found := ic11.PrefabName(int(v)); found {
+			return fmt.Sprintf("hash(%q)", name)
+		}
+	}
+	switch {
+	case strings.HasPrefix(arg, "$"):
+		return "0x" + arg[1:]
+	case strings.HasPrefix(arg, "%"):
+		return "0b" + arg[1:]
+	}
+
+	return formatNumber(v)
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// fallback keeps the whole program as inline assembly, with a comment explaining why
+func fallback(p *emulator.Program, reason error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Kept as inline assembly: %s\n", reason)
+	b.WriteString("void main(void) {\n\tasm {\n")
+	labels := make(map[int][]string)
+	for label, line := range p.Labels {
+		labels[line] = append(labels[line], label)
+	}
+	for n, instr := range p.Lines {
+		sort.Strings(labels[n])
+		for _, label := range labels[n] {
+			fmt.Fprintf(&b, "\t\t%s:\n", label)
+		}
+		if instr != nil {
+			fmt.Fprintf(&b, "\t\t%s\n", instr)
+		}
+	}
+	b.WriteString("\t}\n}\n")
+
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package decompiler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/compiler"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+	"github.com/greg2010/ic11c/internal/testutil"
+)
+
+// TestDecompile decompiles every IC10 program in testdata, compares the result with the golden µC file next to it,
+// and checks that the µC program compiles and writes the same values to devices as the original one.
+// Run with -update to regenerate the golden files.
+func TestDecompile(t *testing.T) {
+	sources, err := filepath.Glob("testdata/*.ic10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		source := source
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			src, err := os.ReadFile(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decompile(string(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckGolden(t, strings.TrimSuffix(source, ".ic10")+".uc", got)
+
+			c, err := compiler.New([]io.Reader{strings.NewReader(got)}, compiler.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			asm, err := c.Assemble()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := run(t, string(src)), run(t, asm); got != want {
+				t.Errorf("trace of the decompiled program differs\n%s\ngot:\n%s\nwant:\n%s", asm, got, want)
+			}
+		})
+	}
+}
+
+// run runs the program against the scenario in testdata and returns the trace of device writes
+func run(t *testing.T, src string) string {
+	t.Helper()
+	program, err := emulator.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/scenario.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scenario, err := emulator.LoadScenario(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chip := emulator.New(program)
+	chip.LineLimit = 100000
+	if err := scenario.Attach(chip); err != nil {
+		t.Fatal(err)
+	}
+	err = scenario.Run(chip, scenario.Ticks)
+
+	var b strings.Builder
+	for _, w := range chip.Trace {
+		fmt.Fprintln(&b, w)
+	}
+	if err != nil && !errors.Is(err, emulator.ErrHalted) {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,396 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+)
+
+// A stmt is a µC statement rebuilt from IC10 code
+type stmt interface {
+	write(b *strings.Builder, indent string)
+}
+
+type simpleStmt string
+
+func (s simpleStmt) write(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%s%s\n", indent, s)
+}
+
+type ifStmt struct {
+	cond string
+	then []stmt
+	els  []stmt
+}
+
+func (s ifStmt) write(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%sif (%s) {\n", indent, s.cond)
+	writeStmts(b, s.then, indent+"\t")
+	if len(s.els) > 0 {
+		fmt.Fprintf(b, "%s} else {\n", indent)
+		writeStmts(b, s.els, indent+"\t")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+type whileStmt struct {
+	cond string
+	body []stmt
+}
+
+func (s whileStmt) write(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%swhile (%s) {\n", indent, s.cond)
+	writeStmts(b, s.body, indent+"\t")
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func writeStmts(b *strings.Builder, stmts []stmt, indent string) {
+	for _, s := range stmts {
+		s.write(b, indent)
+	}
+}
+
+// A loop is a natural loop of the CFG: the blocks from which the header can be reached through its back edges
+type loop struct {
+	header *Block
+	body   map[*Block]bool
+	exits  []edge
+	// exiting is the block leaving the loop at its end, as in do-while, and flag is the variable that keeps the loop going
+	exiting *Block
+	flag    string
+	entered bool
+}
+
+type edge struct {
+	from, to *Block
+}
+
+// analyze finds the loops of the CFG and the immediate postdominators of its blocks.
+// Loops entered other than through their header can't be rebuilt as while statements.
+func (d *decompiler) analyze() error {
+	order := d.cfg.Preorder()
+	dom := dominators(order)
+
+	backEdges := make(map[edge]bool)
+	onStack := make(map[*Block]bool)
+	done := make(map[*Block]bool)
+	var visit func(b *Block) error
+	visit = func(b *Block) error {
+		onStack[b] = true
+		for _, next := range b.next {
+			switch {
+			case next == nil || done[next]:
+			case onStack[next]:
+				if !dom[b][next] {
+					return fmt.Errorf("%w: loop at line %d is entered in the middle", ErrUnstructured, next.Line+1)
+				}
+				backEdges[edge{b, next}] = true
+			default:
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+		onStack[b] = false
+		done[b] = true
+		return nil
+	}
+	if err := visit(d.cfg.entrypoint); err != nil {
+		return err
+	}
+
+	for e := range backEdges {
+		l, found := d.loops[e.to]
+		if !found {
+			l = &loop{header: e.to, body: map[*Block]bool{e.to: true}}
+			d.loops[e.to] = l
+		}
+		l.addBody(e.from)
+	}
+	for _, l := range d.loops {
+		for _, b := range order {
+			if !l.body[b] {
+				continue
+			}
+			for _, next := range b.next {
+				if next == nil || !l.body[next] {
+					l.exits = append(l.exits, edge{b, next})
+				}
+			}
+		}
+	}
+
+	d.ipdom = immediatePostdominators(order, backEdges)
+	return nil
+}
+
+// addBody adds b and the blocks it's reached from to the body of the loop
+func (l *loop) addBody(b *Block) {
+	if l.body[b] {
+		return
+	}
+	l.body[b] = true
+	for _, prev := range b.prev {
+		l.addBody(prev)
+	}
+}
+
+// dominators returns the set of the blocks dominating each block. order must start with the entrypoint.
+func dominators(order []*Block) map[*Block]map[*Block]bool {
+	dom := make(map[*Block]map[*Block]bool)
+	for _, b := range order {
+		dom[b] = blockSet(order)
+	}
+	dom[order[0]] = map[*Block]bool{order[0]: true}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var set map[*Block]bool
+			for _, prev := range b.prev {
+				set = intersect(set, dom[prev])
+			}
+			set[b] = true
+			if len(set) != len(dom[b]) {
+				dom[b] = set
+				changed = true
+			}
+		}
+	}
+
+	return dom
+}
+
+// immediatePostdominators returns the block every path from a block goes through first,
+// once back edges are removed. nil stands for the end of the program and for the end of loop bodies.
+func immediatePostdominators(order []*Block, backEdges map[edge]bool) map[*Block]*Block {
+	pdom := make(map[*Block]map[*Block]bool)
+	universe := blockSet(order)
+	universe[nil] = true
+	for _, b := range order {
+		pdom[b] = universe
+	}
+	pdom[nil] = map[*Block]bool{nil: true}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			b := order[i]
+			var set map[*Block]bool
+			for _, next := range b.next {
+				if !backEdges[edge{b, next}] {
+					set = intersect(set, pdom[next])
+				}
+			}
+			if set == nil {
+				set = map[*Block]bool{nil: true}
+			}
+			set[b] = true
+			if len(set) != len(pdom[b]) {
+				pdom[b] = set
+				changed = true
+			}
+		}
+	}
+
+	// Postdominators of a block form a chain, the closest one has the most postdominators itself
+	ipdom := make(map[*Block]*Block)
+	for _, b := range order {
+		var closest *Block
+		for p := range pdom[b] {
+			if p != b && p != nil && (closest == nil || len(pdom[p]) > len(pdom[closest])) {
+				closest = p
+			}
+		}
+		ipdom[b] = closest
+	}
+
+	return ipdom
+}
+
+func blockSet(blocks []*Block) map[*Block]bool {
+	set := make(map[*Block]bool)
+	for _, b := range blocks {
+		set[b] = true
+	}
+
+	return set
+}
+
+// intersect returns the intersection of the sets as a new set, a copy of b if a is nil
+func intersect(a, b map[*Block]bool) map[*Block]bool {
+	set := make(map[*Block]bool)
+	for e := range b {
+		if a == nil || a[e] {
+			set[e] = true
+		}
+	}
+
+	return set
+}
+
+// region rebuilds the statements executed from block b until block stop is reached.
+// nil stop stands for the end of the program, or the end of the body of the innermost loop.
+func (d *decompiler) region(b, stop *Block) ([]stmt, error) {
+	stmts := []stmt{}
+	for b != stop {
+		l := d.innermost()
+		switch {
+		case b == nil:
+			if l != nil {
+				return nil, fmt.Errorf("%w: the program ends inside the loop at line %d", ErrUnstructured, l.header.Line+1)
+			}
+			return stmts, nil
+		case l != nil && b == l.header && d.visited[b]:
+			if stop != nil {
+				return nil, fmt.Errorf("%w: jump to the start of the loop at line %d", ErrUnstructured, b.Line+1)
+			}
+			return stmts, nil
+		case l != nil && !l.body[b]:
+			return nil, fmt.Errorf("%w: jump out of the loop at line %d", ErrUnstructured, l.header.Line+1)
+		case d.visited[b]:
+			return nil, fmt.Errorf("%w: line %d is reached from several places", ErrUnstructured, b.Line+1)
+		}
+
+		if l, found := d.loops[b]; found && !l.entered {
+			s, exit, err := d.loop(l)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, s...)
+			if len(l.exits) == 0 {
+				return stmts, nil
+			}
+			b = exit
+			continue
+		}
+
+		d.visited[b] = true
+		code, err := d.code(b)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, code...)
+
+		if l != nil && b == l.exiting {
+			cond, err := d.condition(b.branch, b.next[0] == l.header)
+			if err != nil {
+				return nil, err
+			}
+			return append(stmts, simpleStmt(fmt.Sprintf("%s = %s;", l.flag, cond))), nil
+		}
+		if len(b.next) == 1 {
+			b = b.next[0]
+			continue
+		}
+
+		follow := d.ipdom[b]
+		then, err := d.region(b.next[1], follow)
+		if err != nil {
+			return nil, err
+		}
+		els, err := d.region(b.next[0], follow)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.ifStmt(b.branch, then, els)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s...)
+
+		if follow == nil {
+			return stmts, nil
+		}
+		b = follow
+	}
+
+	return stmts, nil
+}
+
+// ifStmt rebuilds an if statement from a branch, with then executed if it's not taken and els if it is
+func (d *decompiler) ifStmt(branch *emulator.Instruction, then, els []stmt) ([]stmt, error) {
+	switch {
+	case len(then) == 0 && len(els) == 0:
+		return nil, nil
+	case len(then) == 0:
+		cond, err := d.condition(branch, true)
+		return []stmt{ifStmt{cond: cond, then: els}}, err
+	default:
+		cond, err := d.condition(branch, false)
+		return []stmt{ifStmt{cond: cond, then: then, els: els}}, err
+	}
+}
+
+// loop rebuilds a while statement from a loop and returns the block executed after it.
+// Loops can be left from the header, as in while, or from the end of the body, as in do-while.
+func (d *decompiler) loop(l *loop) ([]stmt, *Block, error) {
+	l.entered = true
+	h := l.header
+
+	if len(l.exits) == 0 {
+		body, err := d.loopBody(l, h)
+		return []stmt{whileStmt{cond: "1", body: body}}, nil, err
+	}
+	if len(l.exits) > 1 {
+		return nil, nil, fmt.Errorf("%w: the loop at line %d has several exits", ErrUnstructured, h.Line+1)
+	}
+
+	exit := l.exits[0]
+	selfLoop := len(h.next) == 2 && (h.next[0] == h || h.next[1] == h)
+	if exit.from == h && !selfLoop {
+		// The code before the condition is repeated at the end of the body, so that it runs before every check
+		d.visited[h] = true
+		code, err := d.code(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		inside := h.next[0]
+		if inside == exit.to {
+			inside = h.next[1]
+		}
+		cond, err := d.condition(h.branch, inside == h.next[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := d.loopBody(l, inside)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return append(code, whileStmt{cond: cond, body: append(body, code...)}), exit.to, nil
+	}
+
+	if len(exit.from.next) != 2 || exit.from.next[0] != h && exit.from.next[1] != h {
+		return nil, nil, fmt.Errorf("%w: the loop at line %d is left from the middle", ErrUnstructured, h.Line+1)
+	}
+	l.exiting = exit.from
+	l.flag = d.newName("loop")
+	d.use(l.flag)
+	body, err := d.loopBody(l, h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []stmt{simpleStmt(fmt.Sprintf("%s = 1;", l.flag)), whileStmt{cond: l.flag, body: body}}, exit.to, nil
+}
+
+// loopBody rebuilds the statements of the body of the loop, starting with block b
+func (d *decompiler) loopBody(l *loop, b *Block) ([]stmt, error) {
+	d.active = append(d.active, l)
+	defer func() {
+		d.active = d.active[:len(d.active)-1]
+	}()
+
+	return d.region(b, nil)
+}
+
+// innermost returns the loop whose body is being rebuilt, nil outside of loops
+func (d *decompiler) innermost() *loop {
+	if len(d.active) == 0 {
+		return nil
+	}
+
+	return d.active[len(d.active)-1]
+}
@@ -0,0 +1,19 @@
+void main(void) {
+	float r0;
+	float r1;
+	float loop0;
+	float r2;
+
+	r0 = 0;
+	r1 = 10;
+	loop0 = 1;
+	while (loop0) {
+		r0 = r0 + r1;
+		r1 = r1 - 1;
+		loop0 = r1 > 0;
+	}
+	store(db, "Setting", r0);
+	asm("push %0", r0);
+	asm("pop %0", r2);
+	store(d0, "Setting", r2);
+}
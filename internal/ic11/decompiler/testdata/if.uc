@@ -0,0 +1,14 @@
+void main(void) {
+	float r0;
+	float r1;
+
+	while (1) {
+		store(d1, "On", 0);
+		r0 = load(d0, "Temperature");
+		r1 = r0 > 400;
+		if (r1 == 0) {
+			store(d1, "On", 1);
+		}
+		yield();
+	}
+}
@@ -0,0 +1,18 @@
+void main(void) {
+	float outer;
+	float inner;
+	float loop0;
+
+	outer = 0;
+	while (!(outer >= 2)) {
+		inner = 0;
+		loop0 = 1;
+		while (loop0) {
+			store(d1, "Setting", inner);
+			inner = inner + 1;
+			loop0 = inner < 3;
+		}
+		outer = outer + 1;
+	}
+	store(d1, "On", 0b1);
+}
@@ -0,0 +1,18 @@
+// Kept as inline assembly: 11: jal fn: unsupported IC10 code: subroutine call
+void main(void) {
+	asm {
+		move r0 0
+		while:
+		bge r0 10 end
+		add r0 r0 1
+		beqz r0 skip
+		mul r1 r0 2
+		skip:
+		j while
+		end:
+		s db Setting r0
+		jal fn
+		fn:
+		j ra
+	}
+}
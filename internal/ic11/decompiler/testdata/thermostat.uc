@@ -0,0 +1,20 @@
+#define Heater d1
+#define Sensor d0
+
+const int Max = 500;
+
+void main(void) {
+	float temp;
+
+	while (1) {
+		yield();
+		temp = load(Sensor, "Temperature");
+		if (!(temp > Max)) {
+			store(Heater, "On", 1);
+		} else {
+			store(Heater, "On", 0);
+			store_batch(hash("StructureWallLight"), "On", 1);
+			store_batch(hash("StructureWallLight"), "Lock", 0);
+		}
+	}
+}
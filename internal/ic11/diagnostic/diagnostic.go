@@ -0,0 +1,320 @@
+package diagnostic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Severity of a diagnostic
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+
+	return "error"
+}
+
+// Diagnostic is an error or a warning tied to a position in the source
+type Diagnostic struct {
+	Severity Severity
+	Pos      lexer.Position
+	Err      error
+	// End is the position right after the source range the diagnostic refers to, if it's known
+	End lexer.Position
+	// Code is the name of the warning, empty for errors
+	Code string
+	// Notes point to other places in the source related to the diagnostic
+	Notes []Note
+	// Fixes are the suggested changes of the source resolving the diagnostic
+	Fixes []Fix
+	// SourceLine is the text of the line at Pos, printed below the message if it's known
+	SourceLine string
+}
+
+// Note is additional information related to a diagnostic, e.g. the location of a previous declaration
+type Note struct {
+	Pos     lexer.Position
+	Message string
+}
+
+// Fix is a suggested change of the source: the text between Pos and End is replaced by Text
+type Fix struct {
+	Description string
+	Pos         lexer.Position
+	End         lexer.Position
+	Text        string
+}
+
+// Wrap ties err to pos. Errors that already carry a position are returned as is,
+// so that the innermost, most precise position is kept.
+func Wrap(pos lexer.Position, err error) error {
+	var d *Diagnostic
+	if err == nil || errors.As(err, &d) {
+		return err
+	}
+
+	return &Diagnostic{Severity: Error, Pos: pos, Err: err}
+}
+
+// Location formats the position as file:line:col, omitting the parts that are not known
+func (d *Diagnostic) Location() string {
+	return location(d.Pos)
+}
+
+func location(pos lexer.Position) string {
+	parts := []string{}
+	if pos.Filename != "" {
+		parts = append(parts, pos.Filename)
+	}
+	if pos.Line > 0 {
+		parts = append(parts, fmt.Sprint(pos.Line))
+	}
+	if pos.Column > 0 {
+		parts = append(parts, fmt.Sprint(pos.Column))
+	}
+
+	return strings.Join(parts, ":")
+}
+
+func (d *Diagnostic) Error() string {
+	if loc := d.Location(); loc != "" {
+		return fmt.Sprintf("%s: %v", loc, d.Err)
+	}
+
+	return d.Err.Error()
+}
+
+// String formats the diagnostic as file:line:col: severity: message,
+// followed by the source line with a caret pointing at the column
+func (d *Diagnostic) String() string {
+	var b strings.Builder
+	if loc := d.Location(); loc != "" {
+		fmt.Fprintf(&b, "%s: ", loc)
+	}
+	fmt.Fprintf(&b, "%s: %v", d.Severity, d.Err)
+	if d.Code != "" {
+		fmt.Fprintf(&b, " [-W%s]", d.Code)
+	}
+
+	if d.SourceLine != "" {
+		fmt.Fprintf(&b, "\n%s", d.SourceLine)
+		if d.Pos.Column > 0 {
+			fmt.Fprintf(&b, "\n%s^", caretPadding(d.SourceLine, d.Pos.Column))
+		}
+	}
+
+	for _, note := range d.Notes {
+		b.WriteString("\n")
+		if loc := location(note.Pos); loc != "" {
+			fmt.Fprintf(&b, "%s: ", loc)
+		}
+		fmt.Fprintf(&b, "note: %s", note.Message)
+	}
+
+	return b.String()
+}
+
+// caretPadding returns whitespace that puts a caret under the column of line. Tabs are kept so that it's aligned in any editor.
+func caretPadding(line string, column int) string {
+	padding := []rune{}
+	for i, r := range []rune(line) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			padding = append(padding, '\t')
+		} else {
+			padding = append(padding, ' ')
+		}
+	}
+
+	return string(padding)
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// List is a list of diagnostics returned as an error by the compiler stages
+type List []*Diagnostic
+
+func (l List) Error() string {
+	lines := []string{}
+	for _, d := range l {
+		lines = append(lines, d.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap makes errors.Is and errors.As match errors of any diagnostic in the list
+func (l List) Unwrap() []error {
+	errs := []error{}
+	for _, d := range l {
+		errs = append(errs, d)
+	}
+
+	return errs
+}
+
+// Options configure how warnings are reported
+type Options struct {
+	// Disabled contains the names of warnings that are not reported
+	Disabled map[string]bool
+	// WarningsAsErrors reports warnings as errors, as with -Werror
+	WarningsAsErrors bool
+}
+
+// Collector gathers diagnostics from all compiler stages
+type Collector struct {
+	opts        Options
+	diagnostics []*Diagnostic
+	// sources maps file names to their lines, used to show the source of diagnostics
+	sources map[string][]string
+	// positions maps file names to the functions mapping positions in the text compiled to positions in the source
+	positions map[string]PositionMap
+}
+
+// A PositionMap maps a position in preprocessed text to the position in the source it comes from.
+// exact is false if the text at pos doesn't appear verbatim in the source, e.g. it comes from a macro.
+type PositionMap func(pos lexer.Position) (original lexer.Position, exact bool)
+
+func NewCollector(opts Options) *Collector {
+	return &Collector{
+		opts:        opts,
+		diagnostics: []*Diagnostic{},
+		sources:     make(map[string][]string),
+		positions:   make(map[string]PositionMap),
+	}
+}
+
+// AddSource registers the text of a file, so that diagnostics in it are printed with an excerpt of the source
+func (c *Collector) AddSource(name, text string) {
+	c.sources[name] = strings.Split(text, "\n")
+}
+
+// MapPositions registers how positions in the preprocessed text of a file map to its source.
+// Diagnostics in the file are reported at the mapped positions, fixes touching text that isn't in the source
+// are dropped.
+func (c *Collector) MapPositions(name string, m PositionMap) {
+	c.positions[name] = m
+}
+
+// Report adds an error at pos. Lists are flattened, errors that carry a position keep it.
+func (c *Collector) Report(pos lexer.Position, err error) {
+	var list List
+	if errors.As(err, &list) {
+		for _, d := range list {
+			c.add(d)
+		}
+		return
+	}
+
+	var d *Diagnostic
+	errors.As(Wrap(pos, err), &d)
+	c.add(d)
+}
+
+// Warn adds a warning, unless the warning named d.Code is disabled
+func (c *Collector) Warn(d *Diagnostic) {
+	if c.opts.Disabled[d.Code] {
+		return
+	}
+
+	d.Severity = Warning
+	if c.opts.WarningsAsErrors {
+		d.Severity = Error
+	}
+	c.add(d)
+}
+
+func (c *Collector) add(d *Diagnostic) {
+	if m, found := c.positions[d.Pos.Filename]; found {
+		d.Pos, _ = m(d.Pos)
+		d.End, _ = m(d.End)
+		for i := range d.Notes {
+			d.Notes[i].Pos, _ = m(d.Notes[i].Pos)
+		}
+		fixes := []Fix{}
+		for _, fix := range d.Fixes {
+			pos, exact := m(fix.Pos)
+			end, endExact := m(fix.End)
+			if exact && endExact {
+				fix.Pos, fix.End = pos, end
+				fixes = append(fixes, fix)
+			}
+		}
+		if d.Fixes != nil {
+			d.Fixes = fixes
+		}
+	}
+
+	lines := c.sources[d.Pos.Filename]
+	if d.SourceLine == "" && d.Pos.Line > 0 && d.Pos.Line <= len(lines) {
+		d.SourceLine = strings.TrimRight(lines[d.Pos.Line-1], "\r")
+	}
+
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+// HasErrors reports whether any errors were reported
+func (c *Collector) HasErrors() bool {
+	for _, d := range c.diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Diagnostics returns all diagnostics sorted by position, with duplicates removed
+func (c *Collector) Diagnostics() List {
+	sorted := append(List{}, c.diagnostics...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Pos.Filename != b.Pos.Filename {
+			return a.Pos.Filename < b.Pos.Filename
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		if a.Pos.Column != b.Pos.Column {
+			return a.Pos.Column < b.Pos.Column
+		}
+		return a.Severity < b.Severity
+	})
+
+	unique := List{}
+	seen := map[string]bool{}
+	for _, d := range sorted {
+		key := d.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, d)
+	}
+
+	return unique
+}
+
+// Err returns all diagnostics as an error if any errors were reported, nil otherwise
+func (c *Collector) Err() error {
+	if !c.HasErrors() {
+		return nil
+	}
+
+	return c.Diagnostics()
+}
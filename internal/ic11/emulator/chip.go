@@ -0,0 +1,378 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+var ErrInvalidOperand = errors.New("invalid operand")
+var ErrDeviceNotSet = errors.New("device not set")
+var ErrInvalidSlot = errors.New("invalid slot index")
+var ErrStackOverflow = errors.New("stack overflow")
+var ErrStackUnderflow = errors.New("stack underflow")
+var ErrInvalidAddress = errors.New("invalid memory address")
+var ErrHalted = errors.New("halted and caught fire")
+
+const (
+	// NumRegisters is the number of general purpose registers, sp and ra follow them
+	NumRegisters = 16
+	StackSize    = 512
+	// LinesPerTick is the maximum number of lines executed in a single game tick
+	LinesPerTick = 128
+	// TickSeconds is the duration of a game tick
+	TickSeconds = 0.5
+
+	regSP = NumRegisters
+	regRA = NumRegisters + 1
+)
+
+// constants are the names that can be used as values in any program
+var constants = map[string]float64{
+	"nan":     math.NaN(),
+	"pinf":    math.Inf(1),
+	"ninf":    math.Inf(-1),
+	"pi":      math.Pi,
+	"deg2rad": math.Pi / 180,
+	"rad2deg": 180 / math.Pi,
+	"epsilon": math.SmallestNonzeroFloat64,
+}
+
+// Chip is the state of an IC10 chip running a program in a housing
+type Chip struct {
+	// Registers contains r0-r15, sp and ra
+	Registers [NumRegisters + 2]float64
+	// Stack is the stack memory, also accessible as the memory of db
+	Stack [StackSize]float64
+	PC    int
+	// Devices are connected to the pins d0-d5, nil if the pin is not connected
+	Devices [6]*Device
+	// Housing is the device the chip is inserted in, db
+	Housing *Device
+	// Network contains the devices on the data network, targets of the batch instructions.
+	// Devices connected to the pins should be added here too if they are on the same network.
+	Network []*Device
+	// LineLimit is the maximum number of lines executed in a tick, LinesPerTick by default
+	LineLimit int
+	// Ticks is the number of ticks run so far
+	Ticks  int
+	Halted bool
+	// Trace contains all values written to devices
+	Trace []Write
+
+	program *Program
+	aliases map[string]string
+	// wakeTick is the tick the chip resumes execution at after sleep
+	wakeTick int
+	// next is the line executed after the current one
+	next int
+	// yielded is set when the current tick is over
+	yielded bool
+	rand    *rand.Rand
+}
+
+// New creates a chip running program. Random numbers are seeded with a constant, so that runs are reproducible.
+func New(program *Program) *Chip {
+	return &Chip{
+		Housing:   NewDevice(0),
+		LineLimit: LinesPerTick,
+		program:   program,
+		aliases:   make(map[string]string),
+		rand:      rand.New(rand.NewSource(1)),
+	}
+}
+
+// Run runs the chip for the given number of ticks, or until it halts
+func (c *Chip) Run(ticks int) error {
+	for i := 0; i < ticks && !c.Halted; i++ {
+		err := c.Tick()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tick runs a single game tick: lines are executed until yield or sleep, or until LineLimit lines were executed.
+// Every line counts, including blank and label lines.
+func (c *Chip) Tick() error {
+	defer func() { c.Ticks++ }()
+	if c.Halted || c.Ticks < c.wakeTick {
+		return nil
+	}
+
+	c.yielded = false
+	for i := 0; i < c.LineLimit && !c.yielded && !c.Halted; i++ {
+		err := c.Step()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step executes a single line. The chip halts on errors and at the end of the program.
+func (c *Chip) Step() error {
+	if c.Halted {
+		return nil
+	}
+	if c.PC < 0 || c.PC >= len(c.program.Lines) {
+		c.Halted = true
+		return nil
+	}
+
+	c.next = c.PC + 1
+	if instr := c.program.Lines[c.PC]; instr != nil {
+		err := opcodes[instr.Op].exec(c, instr.Args)
+		if err != nil {
+			c.Halted = true
+			return diagnostic.Wrap(lexer.Position{Line: c.PC + 1}, fmt.Errorf("%s: %w", instr, err))
+		}
+	}
+	c.PC = c.next
+
+	return nil
+}
+
+// yield ends the current tick, execution resumes after ticks ticks
+func (c *Chip) yield(ticks int) {
+	c.yielded = true
+	c.wakeTick = c.Ticks + ticks
+}
+
+// Operands
+
+// resolveAlias returns the register or device an alias refers to, or the name itself
+func (c *Chip) resolveAlias(name string) string {
+	if target, found := c.aliases[name]; found {
+		return target
+	}
+
+	return name
+}
+
+// register returns the index of the register referenced by arg: rN, sp, ra, an indirect rrN or an alias
+func (c *Chip) register(arg string) (int, error) {
+	arg = c.resolveAlias(arg)
+	switch arg {
+	case "sp":
+		return regSP, nil
+	case "ra":
+		return regRA, nil
+	}
+
+	indirections := 0
+	for strings.HasPrefix(arg[indirections:], "r") {
+		indirections++
+	}
+	n, err := strconv.Atoi(arg[indirections:])
+	if indirections == 0 || err != nil {
+		return 0, fmt.Errorf("%w: %s is not a register", ErrInvalidOperand, arg)
+	}
+	for ; indirections > 1; indirections-- {
+		if n < 0 || n >= len(c.Registers) {
+			break
+		}
+		n = int(c.Registers[n])
+	}
+	if n < 0 || n >= len(c.Registers) {
+		return 0, fmt.Errorf("%w: register %d is out of range", ErrInvalidOperand, n)
+	}
+
+	return n, nil
+}
+
+var registerRegexp = regexp.MustCompile(`^(r+[0-9]+|sp|ra)$`)
+
+func (c *Chip) isRegister(arg string) bool {
+	return registerRegexp.MatchString(c.resolveAlias(arg))
+}
+
+// value returns the value of arg: a register, a number, a define, a label or a constant
+func (c *Chip) value(arg string) (float64, error) {
+	if c.isRegister(arg) {
+		r, err := c.register(arg)
+		if err != nil {
+			return 0, err
+		}
+		return c.Registers[r], nil
+	}
+	if v, found := c.program.Defines[arg]; found {
+		return v, nil
+	}
+	if line, found := c.program.Labels[arg]; found {
+		return float64(line), nil
+	}
+	if v, found := constants[arg]; found {
+		return v, nil
+	}
+
+	return ParseNumber(arg)
+}
+
+// values returns values of all args
+func (c *Chip) values(args []string) ([]float64, error) {
+	vs := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := c.value(arg)
+		if err != nil {
+			return nil, err
+		}
+		vs[i] = v
+	}
+
+	return vs, nil
+}
+
+// set assigns v to the register referenced by arg
+func (c *Chip) set(arg string, v float64) error {
+	r, err := c.register(arg)
+	if err != nil {
+		return err
+	}
+	c.Registers[r] = v
+
+	return nil
+}
+
+// devicePin returns the pin referenced by arg: 0-5 for d0-d5 (possibly indirect drN), -1 for db
+func (c *Chip) devicePin(arg string) (int, error) {
+	arg = c.resolveAlias(arg)
+	if i := strings.Index(arg, ":"); i >= 0 {
+		// The network channel is irrelevant for the mock devices
+		arg = arg[:i]
+	}
+	if arg == "db" {
+		return -1, nil
+	}
+	if !strings.HasPrefix(arg, "d") {
+		return 0, fmt.Errorf("%w: %s is not a device", ErrInvalidOperand, arg)
+	}
+
+	var pin float64
+	var err error
+	if strings.HasPrefix(arg, "dr") {
+		pin, err = c.value(arg[1:])
+	} else {
+		pin, err = ParseNumber(arg[1:])
+	}
+	if err != nil || pin < 0 || pin >= float64(len(c.Devices)) || pin != math.Trunc(pin) {
+		return 0, fmt.Errorf("%w: %s is not a device", ErrInvalidOperand, arg)
+	}
+
+	return int(pin), nil
+}
+
+// device returns the device connected to the pin referenced by arg and the name of the pin
+func (c *Chip) device(arg string) (*Device, string, error) {
+	pin, err := c.devicePin(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	if pin < 0 {
+		return c.Housing, "db", nil
+	}
+	if c.Devices[pin] == nil {
+		return nil, "", fmt.Errorf("%w: d%d", ErrDeviceNotSet, pin)
+	}
+
+	return c.Devices[pin], fmt.Sprintf("d%d", pin), nil
+}
+
+// deviceByID returns the device with the reference id and its name in the trace
+func (c *Chip) deviceByID(arg string) (*Device, string, error) {
+	id, err := c.value(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	candidates := append([]*Device{c.Housing}, c.Devices[:]...)
+	for _, d := range append(candidates, c.Network...) {
+		if d != nil && d.ReferenceID == id {
+			return d, "id " + formatNumber(id), nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: reference id %s", ErrDeviceNotSet, formatNumber(id))
+}
+
+// memory returns the stack memory of a device, db is the memory of the chip
+func (c *Chip) memory(d *Device) []float64 {
+	if d == c.Housing {
+		return c.Stack[:]
+	}
+
+	return d.Memory
+}
+
+// address validates a memory address
+func address(mem []float64, v float64) (int, error) {
+	a := int(v)
+	if v != math.Trunc(v) || a < 0 || a >= len(mem) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAddress, formatNumber(v))
+	}
+
+	return a, nil
+}
+
+// record appends a write to the trace
+func (c *Chip) record(device string, slot int, field string, v float64) {
+	c.Trace = append(c.Trace, Write{Tick: c.Ticks, Device: device, Slot: slot, Field: field, Value: v})
+}
+
+// jump continues execution at the line
+func (c *Chip) jump(line float64) {
+	c.next = int(line)
+}
+
+// ParseNumber parses a number literal: decimal, hexadecimal ($FF), binary (%101), HASH("...") or STR("...")
+func ParseNumber(s string) (float64, error) {
+	switch {
+	case strings.HasPrefix(s, "HASH(\"") && strings.HasSuffix(s, "\")"):
+		return float64(ic11.ComputeHash(s[6 : len(s)-2])), nil
+	case strings.HasPrefix(s, "STR(\"") && strings.HasSuffix(s, "\")"):
+		return packString(s[5 : len(s)-2])
+	case strings.HasPrefix(s, "$"):
+		v, err := strconv.ParseInt(strings.ReplaceAll(s[1:], "_", ""), 16, 64)
+		if err == nil {
+			return float64(v), nil
+		}
+	case strings.HasPrefix(s, "%"):
+		v, err := strconv.ParseInt(strings.ReplaceAll(s[1:], "_", ""), 2, 64)
+		if err == nil {
+			return float64(v), nil
+		}
+	case s != "" && strings.ContainsRune("0123456789-+.", rune(s[0])):
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			return v, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrInvalidOperand, s)
+}
+
+// packString packs up to 6 ASCII characters into a number, as STR("...") does
+func packString(s string) (float64, error) {
+	if len(s) > 6 {
+		return 0, fmt.Errorf("%w: STR(%q) is longer than 6 characters", ErrInvalidOperand, s)
+	}
+	var v int64
+	for _, r := range s {
+		if r > 0x7f {
+			return 0, fmt.Errorf("%w: STR(%q) contains non-ASCII characters", ErrInvalidOperand, s)
+		}
+		v = v<<8 | int64(r)
+	}
+
+	return float64(v), nil
+}
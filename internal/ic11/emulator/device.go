@@ -0,0 +1,94 @@
+package emulator
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Device is a mock device the chip reads from and writes to
+type Device struct {
+	PrefabHash  float64
+	NameHash    float64
+	ReferenceID float64
+	// Logic contains logic values by logic type name, e.g. "On" or "Setting". Missing values read as 0.
+	Logic map[string]float64
+	// Slots contains logic values of every slot by logic slot type name, e.g. "Occupied"
+	Slots []map[string]float64
+	// Reagents maps a reagent mode (Contents, Required or Recipe) to values by reagent hash
+	Reagents map[string]map[float64]float64
+	// Memory is the stack memory accessed with get and put, nil if the device doesn't have one
+	Memory []float64
+}
+
+// NewDevice creates a device without slots and memory
+func NewDevice(prefabHash float64) *Device {
+	return &Device{
+		PrefabHash: prefabHash,
+		Logic:      make(map[string]float64),
+		Reagents:   make(map[string]map[float64]float64),
+	}
+}
+
+// Load returns a logic value, PrefabHash, NameHash and ReferenceId are derived from the device itself
+func (d *Device) Load(logicType string) float64 {
+	switch logicType {
+	case "PrefabHash":
+		return d.PrefabHash
+	case "NameHash":
+		return d.NameHash
+	case "ReferenceId":
+		return d.ReferenceID
+	}
+
+	return d.Logic[logicType]
+}
+
+// Store sets a logic value
+func (d *Device) Store(logicType string, v float64) {
+	if d.Logic == nil {
+		d.Logic = make(map[string]float64)
+	}
+	d.Logic[logicType] = v
+}
+
+func (d *Device) slot(index float64) (map[string]float64, error) {
+	i := int(index)
+	if float64(i) != index || i < 0 || i >= len(d.Slots) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlot, index)
+	}
+	if d.Slots[i] == nil {
+		d.Slots[i] = make(map[string]float64)
+	}
+
+	return d.Slots[i], nil
+}
+
+// reagentModes are the names of reagent modes by their numeric values
+var reagentModes = []string{"Contents", "Required", "Recipe"}
+
+func (d *Device) reagent(mode string, hash float64) float64 {
+	return d.Reagents[mode][hash]
+}
+
+// Write is a value written to a device, as recorded in the trace
+type Write struct {
+	Tick int
+	// Device is the pin of the device (d0-d5 or db), the reference id for sd, or the batch target
+	Device string
+	// Slot is the slot index for ss and sbs, -1 otherwise
+	Slot  int
+	Field string
+	Value float64
+}
+
+func (w Write) String() string {
+	if w.Slot >= 0 {
+		return fmt.Sprintf("tick %d: %s slot %d %s = %s", w.Tick, w.Device, w.Slot, w.Field, formatNumber(w.Value))
+	}
+
+	return fmt.Sprintf("tick %d: %s %s = %s", w.Tick, w.Device, w.Field, formatNumber(w.Value))
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,119 @@
+package emulator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+)
+
+func run(t *testing.T, src string, ticks int, setup func(c *Chip)) *Chip {
+	t.Helper()
+	p, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(p)
+	if setup != nil {
+		setup(c)
+	}
+	if err := c.Run(ticks); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestLoop(t *testing.T) {
+	c := run(t, `
+move r0 0
+loop:
+add r0 r0 1
+blt r0 10 loop
+s d0 Setting r0
+`, 1, func(c *Chip) { c.Devices[0] = NewDevice(0) })
+
+	if c.Devices[0].Logic["Setting"] != 10 {
+		t.Errorf("Setting = %v, want 10", c.Devices[0].Logic["Setting"])
+	}
+	if !c.Halted {
+		t.Error("chip should halt at the end of the program")
+	}
+}
+
+func TestTicks(t *testing.T) {
+	src := `alias counter d0
+start:
+l r0 counter Setting
+add r0 r0 1
+s counter Setting r0
+sleep 1
+j start`
+	c := run(t, src, 5, func(c *Chip) { c.Devices[0] = NewDevice(0) })
+
+	// sleep 1 skips 2 ticks, so the device is written at ticks 0, 2 and 4
+	trace := []string{}
+	for _, w := range c.Trace {
+		trace = append(trace, w.String())
+	}
+	want := "tick 0: d0 Setting = 1\ntick 2: d0 Setting = 2\ntick 4: d0 Setting = 3"
+	if got := strings.Join(trace, "\n"); got != want {
+		t.Errorf("trace:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLineLimit(t *testing.T) {
+	c := run(t, "add r0 r0 1\nj 0", 2, nil)
+
+	if c.Registers[0] != LinesPerTick {
+		t.Errorf("r0 = %v, want %d", c.Registers[0], LinesPerTick)
+	}
+}
+
+func TestStackAndBatch(t *testing.T) {
+	src := `define Light HASH("StructureWallLight")
+push 3
+push $A
+pop r1
+peek r2
+put db 100 %101
+get r3 db 100
+add r4 r1 r2
+add r4 r4 r3
+sb Light On r4
+lb r5 Light On Sum`
+	c := run(t, src, 1, func(c *Chip) {
+		light := float64(ic11.ComputeHash("StructureWallLight"))
+		c.Network = []*Device{NewDevice(light), NewDevice(light), NewDevice(0)}
+	})
+
+	if c.Registers[4] != 18 || c.Registers[5] != 36 || c.Registers[16] != 1 {
+		t.Errorf("r4 = %v, r5 = %v, sp = %v, want 18, 36, 1", c.Registers[4], c.Registers[5], c.Registers[16])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for src, want := range map[string]error{
+		"foo r0":        ErrUnknownInstruction,
+		"add r0 1":      ErrArity,
+		"a:\na:":        ErrDuplicateLabel,
+		"l r0 d0 On":    ErrDeviceNotSet,
+		"pop r0":        ErrStackUnderflow,
+		"move r0 x":     ErrInvalidOperand,
+		"hcf":           ErrHalted,
+		"get r0 db 512": ErrInvalidAddress,
+	} {
+		p, err := Parse(src)
+		if err == nil {
+			c := New(p)
+			err = c.Run(1)
+			if !c.Halted {
+				t.Errorf("%q: chip should halt on error", src)
+			}
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("%q: got %v, want %v", src, err, want)
+		}
+	}
+}
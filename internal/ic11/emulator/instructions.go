@@ -0,0 +1,642 @@
+package emulator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+)
+
+// opcode describes an IC10 instruction
+type opcode struct {
+	arity int
+	exec  func(c *Chip, args []string) error
+}
+
+// opcodes contains all supported instructions by name. Conditional instructions are added by init.
+var opcodes = map[string]opcode{
+	"move": {2, func(c *Chip, args []string) error {
+		v, err := c.value(args[1])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], v)
+	}},
+
+	"add":   binary(func(a, b float64) float64 { return a + b }),
+	"sub":   binary(func(a, b float64) float64 { return a - b }),
+	"mul":   binary(func(a, b float64) float64 { return a * b }),
+	"div":   binary(func(a, b float64) float64 { return a / b }),
+	"mod":   binary(mod),
+	"max":   binary(math.Max),
+	"min":   binary(math.Min),
+	"atan2": binary(math.Atan2),
+	"abs":   unary(math.Abs),
+	"ceil":  unary(math.Ceil),
+	"floor": unary(math.Floor),
+	// Rounding is half to even, as in C#
+	"round": unary(math.RoundToEven),
+	"trunc": unary(math.Trunc),
+	"sqrt":  unary(math.Sqrt),
+	"exp":   unary(math.Exp),
+	"log":   unary(math.Log),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"asin":  unary(math.Asin),
+	"acos":  unary(math.Acos),
+	"atan":  unary(math.Atan),
+	"lerp": ternary(func(a, b, t float64) float64 {
+		return a + (b-a)*math.Max(0, math.Min(1, t))
+	}),
+	"select": ternary(func(cond, a, b float64) float64 {
+		if cond != 0 {
+			return a
+		}
+		return b
+	}),
+	"rand": {1, func(c *Chip, args []string) error {
+		return c.set(args[0], c.rand.Float64())
+	}},
+
+	// Bitwise instructions operate on 64 bit integers
+	"and": bitwise(func(a, b int64) int64 { return a & b }),
+	"or":  bitwise(func(a, b int64) int64 { return a | b }),
+	"xor": bitwise(func(a, b int64) int64 { return a ^ b }),
+	"nor": bitwise(func(a, b int64) int64 { return ^(a | b) }),
+	"sll": bitwise(func(a, b int64) int64 { return a << uint64(b) }),
+	"sla": bitwise(func(a, b int64) int64 { return a << uint64(b) }),
+	"srl": bitwise(func(a, b int64) int64 { return int64(uint64(a) >> uint64(b)) }),
+	"sra": bitwise(func(a, b int64) int64 { return a >> uint64(b) }),
+	"not": unary(func(a float64) float64 { return float64(^int64(a)) }),
+
+	"j": {1, func(c *Chip, args []string) error {
+		line, err := c.value(args[0])
+		c.jump(line)
+		return err
+	}},
+	"jal": {1, func(c *Chip, args []string) error {
+		line, err := c.value(args[0])
+		c.Registers[regRA] = float64(c.PC + 1)
+		c.jump(line)
+		return err
+	}},
+	"jr": {1, func(c *Chip, args []string) error {
+		offset, err := c.value(args[0])
+		c.jump(float64(c.PC) + offset)
+		return err
+	}},
+
+	"yield": {0, func(c *Chip, args []string) error {
+		c.yield(1)
+		return nil
+	}},
+	"sleep": {1, func(c *Chip, args []string) error {
+		seconds, err := c.value(args[0])
+		if err != nil {
+			return err
+		}
+		c.yield(int(math.Max(1, math.Ceil(seconds/TickSeconds))))
+		return nil
+	}},
+	"hcf": {0, func(c *Chip, args []string) error {
+		return ErrHalted
+	}},
+	"alias": {2, func(c *Chip, args []string) error {
+		target := args[1]
+		if c.isRegister(target) {
+			r, err := c.register(target)
+			if err != nil {
+				return err
+			}
+			c.aliases[args[0]] = fmt.Sprintf("r%d", r)
+			return nil
+		}
+		pin, err := c.devicePin(target)
+		if err != nil {
+			return err
+		}
+		if pin < 0 {
+			c.aliases[args[0]] = "db"
+		} else {
+			c.aliases[args[0]] = fmt.Sprintf("d%d", pin)
+		}
+		return nil
+	}},
+	// Defines are resolved by Parse
+	"define": {2, func(c *Chip, args []string) error { return nil }},
+
+	// Stack
+	"push": {1, func(c *Chip, args []string) error {
+		v, err := c.value(args[0])
+		if err != nil {
+			return err
+		}
+		sp := int(c.Registers[regSP])
+		if sp < 0 || sp >= StackSize {
+			return ErrStackOverflow
+		}
+		c.Stack[sp] = v
+		c.Registers[regSP]++
+		return nil
+	}},
+	"pop": {1, func(c *Chip, args []string) error {
+		sp := int(c.Registers[regSP]) - 1
+		if sp < 0 || sp >= StackSize {
+			return ErrStackUnderflow
+		}
+		c.Registers[regSP]--
+		return c.set(args[0], c.Stack[sp])
+	}},
+	"peek": {1, func(c *Chip, args []string) error {
+		sp := int(c.Registers[regSP]) - 1
+		if sp < 0 || sp >= StackSize {
+			return ErrStackUnderflow
+		}
+		return c.set(args[0], c.Stack[sp])
+	}},
+	"poke": {2, func(c *Chip, args []string) error {
+		vs, err := c.values(args)
+		if err != nil {
+			return err
+		}
+		a, err := address(c.Stack[:], vs[0])
+		if err != nil {
+			return err
+		}
+		c.Stack[a] = vs[1]
+		return nil
+	}},
+	"get": {3, func(c *Chip, args []string) error {
+		d, _, err := c.device(args[1])
+		if err != nil {
+			return err
+		}
+		return c.get(args[0], d, args[2])
+	}},
+	"getd": {3, func(c *Chip, args []string) error {
+		d, _, err := c.deviceByID(args[1])
+		if err != nil {
+			return err
+		}
+		return c.get(args[0], d, args[2])
+	}},
+	"put": {3, func(c *Chip, args []string) error {
+		d, name, err := c.device(args[0])
+		if err != nil {
+			return err
+		}
+		return c.put(d, name, args[1], args[2])
+	}},
+	"putd": {3, func(c *Chip, args []string) error {
+		d, name, err := c.deviceByID(args[0])
+		if err != nil {
+			return err
+		}
+		return c.put(d, name, args[1], args[2])
+	}},
+	"clr": {1, func(c *Chip, args []string) error {
+		d, _, err := c.device(args[0])
+		if err != nil {
+			return err
+		}
+		mem := c.memory(d)
+		for i := range mem {
+			mem[i] = 0
+		}
+		return nil
+	}},
+
+	// Devices
+	"l": {3, func(c *Chip, args []string) error {
+		d, _, err := c.device(args[1])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], d.Load(args[2]))
+	}},
+	"ld": {3, func(c *Chip, args []string) error {
+		d, _, err := c.deviceByID(args[1])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], d.Load(args[2]))
+	}},
+	"s": {3, func(c *Chip, args []string) error {
+		d, name, err := c.device(args[0])
+		if err != nil {
+			return err
+		}
+		return c.store(d, name, args[1], args[2])
+	}},
+	"sd": {3, func(c *Chip, args []string) error {
+		d, name, err := c.deviceByID(args[0])
+		if err != nil {
+			return err
+		}
+		return c.store(d, name, args[1], args[2])
+	}},
+	"ls": {4, func(c *Chip, args []string) error {
+		d, _, err := c.device(args[1])
+		if err != nil {
+			return err
+		}
+		index, err := c.value(args[2])
+		if err != nil {
+			return err
+		}
+		slot, err := d.slot(index)
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], slot[args[3]])
+	}},
+	"ss": {4, func(c *Chip, args []string) error {
+		d, name, err := c.device(args[0])
+		if err != nil {
+			return err
+		}
+		vs, err := c.values([]string{args[1], args[3]})
+		if err != nil {
+			return err
+		}
+		slot, err := d.slot(vs[0])
+		if err != nil {
+			return err
+		}
+		slot[args[2]] = vs[1]
+		c.record(name, int(vs[0]), args[2], vs[1])
+		return nil
+	}},
+	"lr": {4, func(c *Chip, args []string) error {
+		d, _, err := c.device(args[1])
+		if err != nil {
+			return err
+		}
+		mode, err := c.enum(args[2], reagentModes)
+		if err != nil {
+			return err
+		}
+		hash, err := c.value(args[3])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], d.reagent(mode, hash))
+	}},
+
+	// Batch instructions access all devices on the network with the prefab hash (and the name hash)
+	"lb": {4, func(c *Chip, args []string) error {
+		return c.loadBatch(args[0], args[1], "", "", args[2], args[3])
+	}},
+	"lbn": {5, func(c *Chip, args []string) error {
+		return c.loadBatch(args[0], args[1], args[2], "", args[3], args[4])
+	}},
+	"lbs": {5, func(c *Chip, args []string) error {
+		return c.loadBatch(args[0], args[1], "", args[2], args[3], args[4])
+	}},
+	"lbns": {6, func(c *Chip, args []string) error {
+		return c.loadBatch(args[0], args[1], args[2], args[3], args[4], args[5])
+	}},
+	"sb": {3, func(c *Chip, args []string) error {
+		return c.storeBatch(args[0], "", "", args[1], args[2])
+	}},
+	"sbn": {4, func(c *Chip, args []string) error {
+		return c.storeBatch(args[0], args[1], "", args[2], args[3])
+	}},
+	"sbs": {4, func(c *Chip, args []string) error {
+		return c.storeBatch(args[0], "", args[1], args[2], args[3])
+	}},
+}
+
+// conditions are the comparisons used by the set (sCOND), branch (bCOND), branch and link (bCONDal)
+// and relative branch (brCOND) instructions
+var conditions = map[string]struct {
+	arity int
+	eval  func(v []float64) bool
+}{
+	"eq":  {2, func(v []float64) bool { return v[0] == v[1] }},
+	"ne":  {2, func(v []float64) bool { return v[0] != v[1] }},
+	"lt":  {2, func(v []float64) bool { return v[0] < v[1] }},
+	"le":  {2, func(v []float64) bool { return v[0] <= v[1] }},
+	"gt":  {2, func(v []float64) bool { return v[0] > v[1] }},
+	"ge":  {2, func(v []float64) bool { return v[0] >= v[1] }},
+	"eqz": {1, func(v []float64) bool { return v[0] == 0 }},
+	"nez": {1, func(v []float64) bool { return v[0] != 0 }},
+	"ltz": {1, func(v []float64) bool { return v[0] < 0 }},
+	"lez": {1, func(v []float64) bool { return v[0] <= 0 }},
+	"gtz": {1, func(v []float64) bool { return v[0] > 0 }},
+	"gez": {1, func(v []float64) bool { return v[0] >= 0 }},
+	"ap":  {3, func(v []float64) bool { return approx(v[0], v[1], v[2]) }},
+	"na":  {3, func(v []float64) bool { return !approx(v[0], v[1], v[2]) }},
+	"apz": {2, func(v []float64) bool { return approx(v[0], 0, v[1]) }},
+	"naz": {2, func(v []float64) bool { return !approx(v[0], 0, v[1]) }},
+}
+
+func init() {
+	for name, cond := range conditions {
+		cond := cond
+		opcodes["s"+name] = opcode{cond.arity + 1, func(c *Chip, args []string) error {
+			vs, err := c.values(args[1:])
+			if err != nil {
+				return err
+			}
+			return c.set(args[0], boolValue(cond.eval(vs)))
+		}}
+		opcodes["b"+name] = branch(cond.arity, false, false, func(c *Chip, args []string) (bool, error) {
+			vs, err := c.values(args)
+			return err == nil && cond.eval(vs), err
+		})
+		opcodes["b"+name+"al"] = branch(cond.arity, false, true, func(c *Chip, args []string) (bool, error) {
+			vs, err := c.values(args)
+			return err == nil && cond.eval(vs), err
+		})
+		opcodes["br"+name] = branch(cond.arity, true, false, func(c *Chip, args []string) (bool, error) {
+			vs, err := c.values(args)
+			return err == nil && cond.eval(vs), err
+		})
+	}
+
+	// Device conditions check whether a device is connected to the pin
+	deviceConditions := map[string]bool{"dse": true, "dns": false}
+	for name, set := range deviceConditions {
+		set := set
+		connected := func(c *Chip, args []string) (bool, error) {
+			pin, err := c.devicePin(args[0])
+			if err != nil {
+				return false, err
+			}
+			return (pin < 0 || c.Devices[pin] != nil) == set, nil
+		}
+		opcodes["s"+name] = opcode{2, func(c *Chip, args []string) error {
+			v, err := connected(c, args[1:])
+			if err != nil {
+				return err
+			}
+			return c.set(args[0], boolValue(v))
+		}}
+		opcodes["b"+name] = branch(1, false, false, connected)
+		opcodes["b"+name+"al"] = branch(1, false, true, connected)
+		opcodes["br"+name] = branch(1, true, false, connected)
+	}
+}
+
+// branch creates a branch instruction with arity operands followed by the target.
+// Relative branches jump by an offset, linking branches store the address of the next line to ra.
+func branch(arity int, relative, link bool, cond func(c *Chip, args []string) (bool, error)) opcode {
+	return opcode{arity + 1, func(c *Chip, args []string) error {
+		taken, err := cond(c, args[:arity])
+		if err != nil || !taken {
+			return err
+		}
+		target, err := c.value(args[arity])
+		if err != nil {
+			return err
+		}
+		if link {
+			c.Registers[regRA] = float64(c.PC + 1)
+		}
+		if relative {
+			target += float64(c.PC)
+		}
+		c.jump(target)
+		return nil
+	}}
+}
+
+func unary(f func(a float64) float64) opcode {
+	return opcode{2, func(c *Chip, args []string) error {
+		a, err := c.value(args[1])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], f(a))
+	}}
+}
+
+func binary(f func(a, b float64) float64) opcode {
+	return opcode{3, func(c *Chip, args []string) error {
+		vs, err := c.values(args[1:])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], f(vs[0], vs[1]))
+	}}
+}
+
+func ternary(f func(a, b, c float64) float64) opcode {
+	return opcode{4, func(c *Chip, args []string) error {
+		vs, err := c.values(args[1:])
+		if err != nil {
+			return err
+		}
+		return c.set(args[0], f(vs[0], vs[1], vs[2]))
+	}}
+}
+
+func bitwise(f func(a, b int64) int64) opcode {
+	return binary(func(a, b float64) float64 {
+		return float64(f(int64(a), int64(b)))
+	})
+}
+
+// mod returns the remainder with the sign of the divisor
+func mod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+	}
+
+	return r
+}
+
+// approx compares numbers with relative tolerance c, as sap does
+func approx(a, b, c float64) bool {
+	return math.Abs(a-b) <= math.Max(c*math.Max(math.Abs(a), math.Abs(b)), math.SmallestNonzeroFloat32*8)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Helpers shared by the device instructions
+
+// enum resolves an operand that is either a name from names or its index
+func (c *Chip) enum(arg string, names []string) (string, error) {
+	for _, name := range names {
+		if arg == name {
+			return name, nil
+		}
+	}
+	v, err := c.value(arg)
+	if err != nil {
+		return "", err
+	}
+	if v < 0 || int(v) >= len(names) || v != math.Trunc(v) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidOperand, arg)
+	}
+
+	return names[int(v)], nil
+}
+
+func (c *Chip) get(register string, d *Device, addr string) error {
+	v, err := c.value(addr)
+	if err != nil {
+		return err
+	}
+	mem := c.memory(d)
+	a, err := address(mem, v)
+	if err != nil {
+		return err
+	}
+
+	return c.set(register, mem[a])
+}
+
+// put writes to the memory of a device. Writes to the memory of the chip itself are not traced.
+func (c *Chip) put(d *Device, name string, addr string, value string) error {
+	vs, err := c.values([]string{addr, value})
+	if err != nil {
+		return err
+	}
+	mem := c.memory(d)
+	a, err := address(mem, vs[0])
+	if err != nil {
+		return err
+	}
+	mem[a] = vs[1]
+	if d != c.Housing {
+		c.record(name, -1, fmt.Sprintf("Memory[%d]", a), vs[1])
+	}
+
+	return nil
+}
+
+func (c *Chip) store(d *Device, name string, logicType string, value string) error {
+	v, err := c.value(value)
+	if err != nil {
+		return err
+	}
+	d.Store(logicType, v)
+	c.record(name, -1, logicType, v)
+
+	return nil
+}
+
+// batch returns the devices on the network matching the prefab hash and the optional name hash
+func (c *Chip) batch(prefabHash string, nameHash string) ([]*Device, string, error) {
+	prefab, err := c.value(prefabHash)
+	if err != nil {
+		return nil, "", err
+	}
+	target := "batch " + formatNumber(prefab)
+	var name float64
+	if nameHash != "" {
+		name, err = c.value(nameHash)
+		if err != nil {
+			return nil, "", err
+		}
+		target += " name " + formatNumber(name)
+	}
+
+	devices := []*Device{}
+	for _, d := range c.Network {
+		if d.PrefabHash == prefab && (nameHash == "" || d.NameHash == name) {
+			devices = append(devices, d)
+		}
+	}
+
+	return devices, target, nil
+}
+
+// loadBatch aggregates a logic value, or a slot value if slot is set, of the matching devices.
+// Sum of no devices is 0, other modes return NaN.
+func (c *Chip) loadBatch(register, prefabHash, nameHash, slot, logicType, mode string) error {
+	devices, _, err := c.batch(prefabHash, nameHash)
+	if err != nil {
+		return err
+	}
+	m, err := c.enum(mode, ic11.BatchModes)
+	if err != nil {
+		return err
+	}
+
+	values := make([]float64, 0, len(devices))
+	for _, d := range devices {
+		v := d.Load(logicType)
+		if slot != "" {
+			index, err := c.value(slot)
+			if err != nil {
+				return err
+			}
+			s, err := d.slot(index)
+			if err != nil {
+				continue
+			}
+			v = s[logicType]
+		}
+		values = append(values, v)
+	}
+
+	result := 0.0
+	switch {
+	case m == "Sum":
+		for _, v := range values {
+			result += v
+		}
+	case len(values) == 0:
+		result = math.NaN()
+	case m == "Average":
+		for _, v := range values {
+			result += v
+		}
+		result /= float64(len(values))
+	case m == "Minimum":
+		result = values[0]
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+	case m == "Maximum":
+		result = values[0]
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+	}
+
+	return c.set(register, result)
+}
+
+// storeBatch writes a logic value, or a slot value if slot is set, to all matching devices.
+// The write is traced once for the whole batch.
+func (c *Chip) storeBatch(prefabHash, nameHash, slot, logicType, value string) error {
+	devices, target, err := c.batch(prefabHash, nameHash)
+	if err != nil {
+		return err
+	}
+	v, err := c.value(value)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	if slot != "" {
+		s, err := c.value(slot)
+		if err != nil {
+			return err
+		}
+		index = int(s)
+	}
+	for _, d := range devices {
+		if index < 0 {
+			d.Store(logicType, v)
+		} else if s, err := d.slot(float64(index)); err == nil {
+			s[logicType] = v
+		}
+	}
+	c.record(target, index, logicType, v)
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+var ErrSyntax = ic11.ErrSyntax
+var ErrUnknownInstruction = errors.New("unknown instruction")
+var ErrArity = errors.New("wrong number of operands")
+var ErrDuplicateLabel = errors.New("duplicate label")
+
+// Instruction is a single line of IC10 code
+type Instruction struct {
+	Op   string
+	Args []string
+}
+
+func (i *Instruction) String() string {
+	if len(i.Args) == 0 {
+		return i.Op
+	}
+
+	return fmt.Sprintf("%s %s", i.Op, strings.Join(i.Args, " "))
+}
+
+// Program is a parsed IC10 program
+type Program struct {
+	// Lines contains an instruction for every line of the source, nil for blank, comment and label lines.
+	// These lines still take up a line number and an execution step, as they do in game.
+	Lines []*Instruction
+	// Labels maps labels to line numbers
+	Labels map[string]int
+	// Defines maps names declared by define to their values
+	Defines map[string]float64
+}
+
+// Parse parses IC10 source, e.g. the output of assembler.MIPSProgram.
+// Errors are positioned at the line of the source they were found at.
+func Parse(src string) (*Program, error) {
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	p := &Program{
+		Lines:   make([]*Instruction, len(lines)),
+		Labels:  make(map[string]int),
+		Defines: make(map[string]float64),
+	}
+
+	for n, line := range lines {
+		pos := lexer.Position{Line: n + 1}
+		tokens, _, err := ic11.Tokenize(line)
+		if err != nil {
+			return nil, diagnostic.Wrap(pos, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if len(tokens) == 1 && strings.HasSuffix(tokens[0], ":") {
+			label := strings.TrimSuffix(tokens[0], ":")
+			if _, found := p.Labels[label]; found {
+				return nil, diagnostic.Wrap(pos, fmt.Errorf("%w: %s", ErrDuplicateLabel, label))
+			}
+			p.Labels[label] = n
+			continue
+		}
+
+		instr := &Instruction{Op: tokens[0], Args: tokens[1:]}
+		op, found := opcodes[instr.Op]
+		if !found {
+			return nil, diagnostic.Wrap(pos, fmt.Errorf("%w: %s", ErrUnknownInstruction, instr.Op))
+		}
+		if len(instr.Args) != op.arity {
+			return nil, diagnostic.Wrap(pos, fmt.Errorf("%w: %s expects %d, got %d", ErrArity, instr.Op, op.arity, len(instr.Args)))
+		}
+		p.Lines[n] = instr
+	}
+
+	// Defines are visible in the whole program, regardless of where they are declared
+	for n, instr := range p.Lines {
+		if instr == nil || instr.Op != "define" {
+			continue
+		}
+		v, err := ParseNumber(instr.Args[1])
+		if err != nil {
+			return nil, diagnostic.Wrap(lexer.Position{Line: n + 1}, fmt.Errorf("%s: %w", instr, err))
+		}
+		p.Defines[instr.Args[0]] = v
+	}
+
+	return p, nil
+}
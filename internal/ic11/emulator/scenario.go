@@ -0,0 +1,184 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidScenario = errors.New("invalid scenario")
+
+// Scenario describes the devices a program runs against, in YAML or JSON:
+//
+//	ticks: 20
+//	devices:
+//	  d0:
+//	    prefab: StructureFurnace
+//	    logic: {Temperature: 300}
+//	    timeline:
+//	      10: {Temperature: 1200}
+//	network:
+//	  - prefab: StructureWallLight
+//	    name: Furnace Light
+//
+// Devices on the pins are on the network too.
+type Scenario struct {
+	// Ticks is the number of ticks to run, if not given otherwise
+	Ticks   int                    `yaml:"ticks"`
+	Housing *DeviceSpec            `yaml:"housing"`
+	Devices map[string]*DeviceSpec `yaml:"devices"`
+	Network []*DeviceSpec          `yaml:"network"`
+
+	timelines map[*Device]map[int]map[string]float64
+}
+
+// DeviceSpec describes a device and the changes of its logic values over time
+type DeviceSpec struct {
+	Prefab   Hash                          `yaml:"prefab"`
+	Name     Hash                          `yaml:"name"`
+	ID       float64                       `yaml:"id"`
+	Logic    map[string]float64            `yaml:"logic"`
+	Slots    []map[string]float64          `yaml:"slots"`
+	Reagents map[string]map[string]float64 `yaml:"reagents"`
+	Memory   int                           `yaml:"memory"`
+	// Timeline maps a tick to the logic values set at the start of the tick
+	Timeline map[int]map[string]float64 `yaml:"timeline"`
+}
+
+// Hash is a number, or a name that is hashed as with HASH("...")
+type Hash float64
+
+func (h *Hash) UnmarshalYAML(node *yaml.Node) error {
+	var v float64
+	if node.Decode(&v) == nil {
+		*h = Hash(v)
+		return nil
+	}
+
+	var name string
+	if err := node.Decode(&name); err != nil {
+		return err
+	}
+	*h = Hash(ic11.ComputeHash(name))
+	return nil
+}
+
+// LoadScenario reads a scenario in YAML or JSON
+func LoadScenario(r io.Reader) (*Scenario, error) {
+	var s Scenario
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	err := decoder.Decode(&s)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidScenario, err)
+	}
+
+	return &s, nil
+}
+
+// Attach connects the devices of the scenario to the chip
+func (s *Scenario) Attach(c *Chip) error {
+	housing, pins, network, err := s.Build()
+	if err != nil {
+		return err
+	}
+	if housing != nil {
+		c.Housing = housing
+	}
+	c.Devices = pins
+	c.Network = network
+
+	return nil
+}
+
+// Build creates the devices of the scenario: the housing (nil if not described), the devices on the pins and the network.
+// Apply changes the devices created by the last call.
+func (s *Scenario) Build() (*Device, [6]*Device, []*Device, error) {
+	var housing *Device
+	var pins [6]*Device
+	network := []*Device{}
+	s.timelines = make(map[*Device]map[int]map[string]float64)
+	if s.Housing != nil {
+		housing = s.device(s.Housing)
+	}
+	for pin, spec := range s.Devices {
+		n := pinIndex(pin)
+		if n < 0 || n >= len(pins) {
+			return nil, pins, nil, fmt.Errorf("%w: %s is not a device pin", ErrInvalidScenario, pin)
+		}
+		pins[n] = s.device(spec)
+	}
+	for _, d := range pins {
+		if d != nil {
+			network = append(network, d)
+		}
+	}
+	for _, spec := range s.Network {
+		network = append(network, s.device(spec))
+	}
+
+	return housing, pins, network, nil
+}
+
+// Apply sets the logic values the timelines of the devices have for the tick
+func (s *Scenario) Apply(tick int) {
+	for d, timeline := range s.timelines {
+		for logicType, v := range timeline[tick] {
+			d.Store(logicType, v)
+		}
+	}
+}
+
+// Run runs the chip for the given number of ticks, applying the timelines of the devices at the start of every tick
+func (s *Scenario) Run(c *Chip, ticks int) error {
+	for i := 0; i < ticks && !c.Halted; i++ {
+		s.Apply(c.Ticks)
+		err := c.Tick()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Scenario) device(spec *DeviceSpec) *Device {
+	d := NewDevice(float64(spec.Prefab))
+	d.NameHash = float64(spec.Name)
+	d.ReferenceID = spec.ID
+	for logicType, v := range spec.Logic {
+		d.Logic[logicType] = v
+	}
+	d.Slots = spec.Slots
+	for mode, values := range spec.Reagents {
+		d.Reagents[mode] = make(map[float64]float64)
+		for reagent, v := range values {
+			hash, err := strconv.ParseFloat(reagent, 64)
+			if err != nil {
+				hash = float64(ic11.ComputeHash(reagent))
+			}
+			d.Reagents[mode][hash] = v
+		}
+	}
+	if spec.Memory > 0 {
+		d.Memory = make([]float64, spec.Memory)
+	}
+	if len(spec.Timeline) > 0 {
+		s.timelines[d] = spec.Timeline
+	}
+
+	return d
+}
+
+// pinIndex returns the index of a pin d0-d5, or -1
+func pinIndex(pin string) int {
+	if len(pin) != 2 || pin[0] != 'd' || pin[1] < '0' || pin[1] > '9' {
+		return -1
+	}
+
+	return int(pin[1] - '0')
+}
@@ -0,0 +1,145 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/compiler"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+	"github.com/greg2010/ic11c/internal/ic11/ir"
+)
+
+// defaultTicks is the number of ticks programs run for if their scenario doesn't set it
+const defaultTicks = 20
+
+// stepLimit replaces the limit of lines per tick, so that ticks end at the same points in IR and in IC10
+const stepLimit = 100000
+
+// TestDifferential runs every program in testdata through the IR interpreter and through the emulator,
+// compiled at every optimization level, and compares the traces of device writes.
+// A program may have a scenario with the same name describing the devices it runs against.
+func TestDifferential(t *testing.T) {
+	sources, err := filepath.Glob("testdata/*.uc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		source := source
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			for _, level := range compiler.OptimizationLevels {
+				differential(t, source, level)
+			}
+		})
+	}
+}
+
+func differential(t *testing.T, source string, level int) {
+	t.Helper()
+	src, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := compiler.New([]io.Reader{strings.NewReader(string(src))}, compiler.Options{Optimize: level})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm, err := c.Assemble()
+	if err != nil {
+		t.Fatalf("-O%d: %v", level, err)
+	}
+	program, err := emulator.Parse(asm)
+	if err != nil {
+		t.Fatalf("-O%d: %v\n%s", level, err, asm)
+	}
+
+	scenarioFile := strings.TrimSuffix(source, ".uc") + ".yaml"
+	scenario := loadScenario(t, scenarioFile)
+	ticks := defaultTicks
+	if scenario.Ticks > 0 {
+		ticks = scenario.Ticks
+	}
+
+	chip := emulator.New(program)
+	chip.LineLimit = stepLimit
+	if err := scenario.Attach(chip); err != nil {
+		t.Fatal(err)
+	}
+	chipErr := scenario.Run(chip, ticks)
+	want := traceString(chip.Trace, chipErr)
+
+	// The IR is interpreted as the assembler gets it, optimized alike
+	prog := c.IR()
+	if level >= 2 {
+		prog, err = ir.EliminateCommonSubexpressions(prog)
+		if err != nil {
+			t.Fatalf("-O%d: %v", level, err)
+		}
+	}
+	interpreters := map[string]*Interpreter{
+		"Program":      New(prog),
+		"BlockProgram": NewBlocks(ir.NewBlockProgram(prog)),
+	}
+	for name, in := range interpreters {
+		scenario := loadScenario(t, scenarioFile)
+		housing, devices, network, err := scenario.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if housing != nil {
+			in.Housing = housing
+		}
+		in.Devices = devices
+		in.Network = network
+		in.StepLimit = stepLimit
+
+		var runErr error
+		for i := 0; i < ticks && !in.Halted && runErr == nil; i++ {
+			scenario.Apply(in.Ticks)
+			runErr = in.Tick()
+		}
+
+		if got := traceString(in.Trace, runErr); got != want {
+			t.Errorf("-O%d: %s trace differs from IC10\n%s\nIC10:\n%s\nIR:\n%s", level, name, asm, want, got)
+		}
+	}
+}
+
+func loadScenario(t *testing.T, name string) *emulator.Scenario {
+	t.Helper()
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return &emulator.Scenario{}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s, err := emulator.LoadScenario(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// traceString formats the writes and the reason the program stopped, if it failed
+func traceString(trace []emulator.Write, err error) string {
+	var b strings.Builder
+	for _, w := range trace {
+		fmt.Fprintln(&b, w)
+	}
+	if errors.Is(err, emulator.ErrHalted) {
+		fmt.Fprintln(&b, "halted")
+	} else if err != nil {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,534 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/emulator"
+	"github.com/greg2010/ic11c/internal/ic11/ir"
+)
+
+var ErrUnknownLabel = errors.New("unknown label")
+var ErrUnsupportedInstruction = errors.New("unsupported instruction")
+var ErrInvalidOperand = errors.New("invalid operand")
+
+// Interpreter executes IR against mock devices, with the semantics the instructions have once compiled to IC10.
+// Writes to the devices are traced the same way emulator.Chip traces them, so that the traces can be compared.
+type Interpreter struct {
+	Vars  map[ir.IRVar]float64
+	Stack [emulator.StackSize]float64
+	// Devices are connected to the pins d0-d5, nil if the pin is not connected
+	Devices [6]*emulator.Device
+	Housing *emulator.Device
+	Network []*emulator.Device
+	// StepLimit is the maximum number of instructions executed in a tick
+	StepLimit int
+	Ticks     int
+	Halted    bool
+	Trace     []emulator.Write
+
+	code     code
+	wakeTick int
+	yielded  bool
+	rand     *rand.Rand
+}
+
+// code is the position of the execution in a Program or a BlockProgram
+type code interface {
+	// fetch returns the current instruction, false at the end of the program
+	fetch() (ir.IRInstruction, bool)
+	// advance moves to the next instruction
+	advance()
+	jump(label ir.IRLabelType) error
+}
+
+// New creates an interpreter of a Program
+func New(program *ir.Program) *Interpreter {
+	labels := make(map[ir.IRLabelType]int)
+	for idx, instr := range program.Get() {
+		if label, ok := instr.(ir.IRLabel); ok {
+			labels[label.Label] = idx
+		}
+	}
+
+	return newInterpreter(&linearCode{instructions: program.Get(), labels: labels})
+}
+
+// NewBlocks creates an interpreter of a BlockProgram, following the edges between the blocks
+func NewBlocks(program *ir.BlockProgram) *Interpreter {
+	return newInterpreter(&blockCode{program: program, block: program.Entrypoint()})
+}
+
+func newInterpreter(c code) *Interpreter {
+	return &Interpreter{
+		Vars:      make(map[ir.IRVar]float64),
+		Housing:   emulator.NewDevice(0),
+		StepLimit: emulator.LinesPerTick,
+		code:      c,
+		rand:      rand.New(rand.NewSource(1)),
+	}
+}
+
+type linearCode struct {
+	instructions []ir.IRInstruction
+	labels       map[ir.IRLabelType]int
+	pc           int
+}
+
+func (c *linearCode) fetch() (ir.IRInstruction, bool) {
+	if c.pc >= len(c.instructions) {
+		return nil, false
+	}
+
+	return c.instructions[c.pc], true
+}
+
+func (c *linearCode) advance() {
+	c.pc++
+}
+
+func (c *linearCode) jump(label ir.IRLabelType) error {
+	pc, found := c.labels[label]
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknownLabel, label)
+	}
+	c.pc = pc
+
+	return nil
+}
+
+type blockCode struct {
+	program *ir.BlockProgram
+	block   *ir.BasicBlock
+	idx     int
+}
+
+func (c *blockCode) fetch() (ir.IRInstruction, bool) {
+	// Blocks that don't end with a jump fall through to their first successor
+	for c.block != nil && c.idx >= len(c.block.Instructions()) {
+		next := c.block.Next()
+		c.block = nil
+		c.idx = 0
+		if len(next) > 0 {
+			c.block = next[0]
+		}
+	}
+	if c.block == nil {
+		return nil, false
+	}
+
+	return c.block.Instructions()[c.idx], true
+}
+
+func (c *blockCode) advance() {
+	c.idx++
+}
+
+func (c *blockCode) jump(label ir.IRLabelType) error {
+	block, found := c.program.Block(label)
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknownLabel, label)
+	}
+	c.block = block
+	c.idx = 0
+
+	return nil
+}
+
+// Run runs the program for the given number of ticks, or until it halts
+func (in *Interpreter) Run(ticks int) error {
+	for i := 0; i < ticks && !in.Halted; i++ {
+		err := in.Tick()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tick executes instructions until yield or sleep, or until StepLimit instructions were executed
+func (in *Interpreter) Tick() error {
+	defer func() { in.Ticks++ }()
+	if in.Halted || in.Ticks < in.wakeTick {
+		return nil
+	}
+
+	in.yielded = false
+	for i := 0; i < in.StepLimit && !in.yielded && !in.Halted; i++ {
+		err := in.Step()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step executes a single instruction. The interpreter halts on errors and at the end of the program.
+func (in *Interpreter) Step() error {
+	instr, ok := in.code.fetch()
+	if !ok {
+		in.Halted = true
+		return nil
+	}
+
+	jumped, err := in.exec(instr)
+	if err != nil {
+		in.Halted = true
+		return fmt.Errorf("%s: %w", instr, err)
+	}
+	if !jumped {
+		in.code.advance()
+	}
+
+	return nil
+}
+
+// exec executes an instruction, jumped is true if it transferred control
+func (in *Interpreter) exec(instr ir.IRInstruction) (jumped bool, err error) {
+	switch i := instr.(type) {
+	case ir.IRAssignLiteral:
+		v, ok := i.ValueVar.Float()
+		if !ok {
+			return false, fmt.Errorf("%w: %s is not a number", ErrInvalidOperand, i.ValueVar)
+		}
+		in.Vars[i.Assignee] = v
+	case ir.IRAssignVar:
+		in.Vars[i.Assignee] = in.Vars[i.ValueVar]
+	case ir.IRAssignBinary:
+		v, err := binary(i.Op, in.Vars[i.L], in.Vars[i.R])
+		if err != nil {
+			return false, err
+		}
+		in.Vars[i.Assignee] = v
+	case ir.IRAssignUnary:
+		v, err := unary(i.Op, in.Vars[i.R])
+		if err != nil {
+			return false, err
+		}
+		in.Vars[i.Assignee] = v
+	case ir.IRAssignSelect:
+		if in.Vars[i.Cond] != 0 {
+			in.Vars[i.Assignee] = in.Vars[i.L]
+		} else {
+			in.Vars[i.Assignee] = in.Vars[i.R]
+		}
+	case ir.IRLabel:
+	case ir.IRGoto:
+		return true, in.code.jump(i.Label)
+	case ir.IRIfZ:
+		if in.Vars[i.Cond] == 0 {
+			return true, in.code.jump(i.Label)
+		}
+	case ir.IRStackLoad:
+		a, err := in.address(i.Address)
+		if err != nil {
+			return false, err
+		}
+		in.Vars[i.Ret] = in.Stack[a]
+	case ir.IRStackStore:
+		a, err := in.address(i.Address)
+		if err != nil {
+			return false, err
+		}
+		in.Stack[a] = in.Vars[i.Value]
+	case ir.IRBuiltinCallVoid:
+		return false, in.callVoid(i.BuiltinName, i.Params)
+	case ir.IRBuiltinCallRet:
+		v, err := in.callRet(i.BuiltinName, i.Params)
+		if err != nil {
+			return false, err
+		}
+		in.Vars[i.Ret] = v
+	default:
+		return false, ErrUnsupportedInstruction
+	}
+
+	return false, nil
+}
+
+func binary(op ir.IROp, l, r float64) (float64, error) {
+	switch op {
+	case ir.OpAdd:
+		return l + r, nil
+	case ir.OpSub:
+		return l - r, nil
+	case ir.OpMul:
+		return l * r, nil
+	case ir.OpDiv:
+		return l / r, nil
+	case ir.OpMod:
+		m := math.Mod(l, r)
+		if m != 0 && (m < 0) != (r < 0) {
+			m += r
+		}
+		return m, nil
+	case ir.OpEq:
+		return boolValue(l == r), nil
+	case ir.OpNe:
+		return boolValue(l != r), nil
+	case ir.OpLt:
+		return boolValue(l < r), nil
+	case ir.OpLe:
+		return boolValue(l <= r), nil
+	case ir.OpGt:
+		return boolValue(l > r), nil
+	case ir.OpGe:
+		return boolValue(l >= r), nil
+	case ir.OpAnd:
+		return boolValue(l != 0 && r != 0), nil
+	case ir.OpOr:
+		return boolValue(l != 0 || r != 0), nil
+	case ir.OpBitAnd:
+		return float64(int64(l) & int64(r)), nil
+	case ir.OpBitOr:
+		return float64(int64(l) | int64(r)), nil
+	case ir.OpBitXor:
+		return float64(int64(l) ^ int64(r)), nil
+	case ir.OpShl:
+		return float64(int64(l) << uint64(r)), nil
+	case ir.OpShr:
+		return float64(int64(l) >> uint64(r)), nil
+	case ir.OpShrU:
+		return float64(uint64(int64(l)) >> uint64(r)), nil
+	}
+
+	return 0, fmt.Errorf("%w: %s", ir.ErrUnknownOperator, op)
+}
+
+func unary(op ir.IROp, r float64) (float64, error) {
+	switch op {
+	case ir.OpNeg:
+		return 0 - r, nil
+	case ir.OpNot:
+		return boolValue(r == 0), nil
+	case ir.OpBitNot:
+		return float64(^int64(r)), nil
+	}
+
+	return 0, fmt.Errorf("%w: %s", ir.ErrUnknownOperator, op)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Builtins
+
+var builtins1 = map[string]func(float64) float64{
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"abs":   math.Abs,
+	"acos":  math.Acos,
+	"asin":  math.Asin,
+	"atan":  math.Atan,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"sqrt":  math.Sqrt,
+	"round": math.RoundToEven,
+	"trunc": math.Trunc,
+}
+
+var builtins2 = map[string]func(float64, float64) float64{
+	"mod": func(a, b float64) float64 {
+		v, _ := binary(ir.OpMod, a, b)
+		return v
+	},
+	"xor": func(a, b float64) float64 { return float64(int64(a) ^ int64(b)) },
+	"nor": func(a, b float64) float64 { return float64(^(int64(a) | int64(b))) },
+	"max": math.Max,
+	"min": math.Min,
+}
+
+func (in *Interpreter) callVoid(name string, params []ir.IRLiteralOrVar) error {
+	switch {
+	case name == "yield" && len(params) == 0:
+		in.yield(1)
+	case name == "sleep" && len(params) == 1:
+		seconds, err := in.number(params[0])
+		if err != nil {
+			return err
+		}
+		in.yield(int(math.Max(1, math.Ceil(seconds/emulator.TickSeconds))))
+	case name == "hcf" && len(params) == 0:
+		return emulator.ErrHalted
+	case name == "store" && len(params) == 3:
+		d, pin, err := in.device(params[0])
+		if err != nil {
+			return err
+		}
+		v, err := in.number(params[2])
+		if err != nil {
+			return err
+		}
+		d.Store(params[1].String(), v)
+		in.record(pin, params[1].String(), v)
+	case name == "store_batch" && len(params) == 3:
+		values, err := in.numbers(params[0], params[2])
+		if err != nil {
+			return err
+		}
+		for _, d := range in.Network {
+			if d.PrefabHash == values[0] {
+				d.Store(params[1].String(), values[1])
+			}
+		}
+		in.record("batch "+strconv.FormatFloat(values[0], 'f', -1, 64), params[1].String(), values[1])
+	default:
+		return fmt.Errorf("%w: %s with %d arguments", ErrUnsupportedInstruction, name, len(params))
+	}
+
+	return nil
+}
+
+func (in *Interpreter) callRet(name string, params []ir.IRLiteralOrVar) (float64, error) {
+	if f, found := builtins1[name]; found && len(params) == 1 {
+		v, err := in.number(params[0])
+		return f(v), err
+	}
+	if f, found := builtins2[name]; found && len(params) == 2 {
+		values, err := in.numbers(params...)
+		if err != nil {
+			return 0, err
+		}
+		return f(values[0], values[1]), nil
+	}
+
+	switch {
+	case name == "rand" && len(params) == 0:
+		return in.rand.Float64(), nil
+	case name == "load" && len(params) == 2:
+		d, _, err := in.device(params[0])
+		if err != nil {
+			return 0, err
+		}
+		return d.Load(params[1].String()), nil
+	case name == "load_batch" && len(params) == 3:
+		return in.loadBatch(params[0], params[1].String(), params[2])
+	}
+
+	return 0, fmt.Errorf("%w: %s with %d arguments", ErrUnsupportedInstruction, name, len(params))
+}
+
+func (in *Interpreter) loadBatch(hash ir.IRLiteralOrVar, logicType string, mode ir.IRLiteralOrVar) (float64, error) {
+	prefab, err := in.number(hash)
+	if err != nil {
+		return 0, err
+	}
+	m := mode.String()
+	if n, err := in.number(mode); err == nil && n >= 0 && int(n) < len(ic11.BatchModes) {
+		m = ic11.BatchModes[int(n)]
+	}
+
+	values := []float64{}
+	for _, d := range in.Network {
+		if d.PrefabHash == prefab {
+			values = append(values, d.Load(logicType))
+		}
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	switch {
+	case m == "Sum":
+		return sum, nil
+	case len(values) == 0:
+		return math.NaN(), nil
+	case m == "Average":
+		return sum / float64(len(values)), nil
+	case m == "Minimum", m == "Maximum":
+		result := values[0]
+		for _, v := range values[1:] {
+			if m == "Minimum" {
+				result = math.Min(result, v)
+			} else {
+				result = math.Max(result, v)
+			}
+		}
+		return result, nil
+	}
+
+	return 0, fmt.Errorf("%w: batch mode %s", ErrInvalidOperand, m)
+}
+
+// Helpers
+
+func (in *Interpreter) yield(ticks int) {
+	in.yielded = true
+	in.wakeTick = in.Ticks + ticks
+}
+
+func (in *Interpreter) record(device string, field string, v float64) {
+	in.Trace = append(in.Trace, emulator.Write{Tick: in.Ticks, Device: device, Slot: -1, Field: field, Value: v})
+}
+
+// number returns the value of a variable or a numeric literal
+func (in *Interpreter) number(operand ir.IRLiteralOrVar) (float64, error) {
+	if v, ok := operand.Var(); ok {
+		return in.Vars[v], nil
+	}
+	lit, _ := operand.Literal()
+	f, ok := lit.Float()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s is not a number", ErrInvalidOperand, operand)
+	}
+
+	return f, nil
+}
+
+func (in *Interpreter) numbers(operands ...ir.IRLiteralOrVar) ([]float64, error) {
+	values := make([]float64, len(operands))
+	for idx, operand := range operands {
+		v, err := in.number(operand)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = v
+	}
+
+	return values, nil
+}
+
+// device returns the device named by a literal operand, d0-d5 or db, and its name
+func (in *Interpreter) device(operand ir.IRLiteralOrVar) (*emulator.Device, string, error) {
+	name := operand.String()
+	if name == "db" {
+		return in.Housing, name, nil
+	}
+	for pin, d := range in.Devices {
+		if name != fmt.Sprintf("d%d", pin) {
+			continue
+		}
+		if d == nil {
+			return nil, "", fmt.Errorf("%w: %s", emulator.ErrDeviceNotSet, name)
+		}
+		return d, name, nil
+	}
+
+	return nil, "", fmt.Errorf("%w: %s is not a device", ErrInvalidOperand, name)
+}
+
+func (in *Interpreter) address(operand ir.IRLiteralOrVar) (int, error) {
+	v, err := in.number(operand)
+	if err != nil {
+		return 0, err
+	}
+	if v != math.Trunc(v) || v < 0 || v >= emulator.StackSize {
+		return 0, fmt.Errorf("%w: %s", emulator.ErrInvalidAddress, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return int(v), nil
+}
@@ -0,0 +1,7 @@
+// Operators with IC10 specific semantics
+void main(void) {
+	int a;
+	a = load(d0, "Setting");
+	store(d0, "Ratio", -7 % a);
+	store(d0, "Shift", (a << 2) | (-a >> 1));
+}
@@ -0,0 +1,12 @@
+// Sum of the last two samples, kept in an array on the stack
+int samples[2];
+
+void main(void) {
+	int i;
+	while (1) {
+		samples[i % 2] = load(d0, "Temperature");
+		i++;
+		store(d1, "Setting", samples[0] + samples[1]);
+		sleep(1);
+	}
+}
@@ -0,0 +1,12 @@
+void main(void) {
+	float a;
+	volatile float v;
+	while (1) {
+		a = load(d0, "Temperature");
+		v = load(d0, "Temperature");
+		store(d1, "Setting", a * 2 + load(d0, "Temperature"));
+		store(d0, "On", 1);
+		store(d1, "Setting", load(d0, "Temperature") + v);
+		yield();
+	}
+}
@@ -0,0 +1,15 @@
+const int Light = hash("StructureWallLight");
+
+void main(void) {
+	float t;
+	while (1) {
+		t = load(d0, "Temperature");
+		if (t > 1000) {
+			store(d1, "On", 0);
+			store_batch(Light, "On", 1);
+		} else {
+			store(d1, "On", 1);
+		}
+		yield();
+	}
+}
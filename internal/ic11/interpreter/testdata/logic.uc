@@ -0,0 +1,7 @@
+// Logic and rounding with IC10 specific semantics
+void main(void) {
+	int a;
+	a = load(d0, "Setting");
+	store(d0, "Logic", (a && 0) + !a);
+	store(d0, "Round", round(a / 2));
+}
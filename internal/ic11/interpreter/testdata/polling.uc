@@ -0,0 +1,9 @@
+void main(void) {
+	float a;
+	store(d1, "Setting", 1);
+	a = load(d0, "On");
+	while (a > 0) {
+		a = load(d0, "On");
+	}
+	store(d1, "Setting", 2);
+}
@@ -0,0 +1,114 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+var ErrInvalidArraySize = errors.New("array size must be a positive integer constant")
+var ErrStackOverflow = errors.New("arrays do not fit into stack memory")
+var ErrIndexOutOfBounds = errors.New("array index out of bounds")
+var ErrNotArray = errors.New("indexed variable is not an array")
+var ErrArrayAsScalar = errors.New("array used as a scalar")
+var ErrArrayRedeclared = errors.New("array redeclared")
+
+// StackSize is the number of words of stack memory available to an IC10 chip
+const StackSize = 512
+
+// array is a fixed size array allocated in stack memory.
+// Arrays are allocated from the top of the stack memory downwards,
+// so that the bottom of the stack remains available to push and pop.
+type array struct {
+	base int64
+	size int64
+}
+
+func (fr *Frontend) declareArray(a *parser.ArrayDec) error {
+	if _, found := fr.arrays[a.Name]; found {
+		return diagnostic.Wrap(a.Pos, fmt.Errorf("%w: %s", ErrArrayRedeclared, a.Name))
+	}
+
+	lit, err := fr.evalConst(a.Size)
+	if err != nil {
+		return diagnostic.Wrap(a.Size.Pos, fmt.Errorf("array %s: %w", a.Name, ErrInvalidArraySize))
+	}
+	size, ok := lit.int()
+	if !ok || size <= 0 {
+		return diagnostic.Wrap(a.Size.Pos, fmt.Errorf("array %s: %w", a.Name, ErrInvalidArraySize))
+	}
+
+	base := fr.stackTop - size
+	if base < 0 {
+		return fmt.Errorf("array %s: %w", a.Name, ErrStackOverflow)
+	}
+	fr.stackTop = base
+	fr.arrays[a.Name] = &array{base: base, size: size}
+
+	return nil
+}
+
+// compileArrayAddress emits the code computing stack address of arr[index].
+// Constant indexes are checked at compile time, others at runtime if bounds checking is enabled.
+func (fr *Frontend) compileArrayAddress(name string, index *parser.Expr) (*IRLiteralOrVar, error) {
+	arr, found := fr.arrays[name]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotArray, name)
+	}
+
+	if lit, err := fr.evalConst(index); err == nil {
+		i, ok := lit.int()
+		if !ok || i < 0 || i >= arr.size {
+			return nil, diagnostic.Wrap(index.Pos, fmt.Errorf("%w: %s[%s], size is %d", ErrIndexOutOfBounds, name, lit, arr.size))
+		}
+
+		addr := NewLiteralOrVarLiteral(*NewIntLiteral(arr.base + i))
+		return &addr, nil
+	}
+
+	i, err := fr.compileExpr(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if fr.opts.BoundsCheck {
+		fr.emitBoundsCheck(*i, arr.size)
+	}
+
+	base := fr.emitLiteral(*NewIntLiteral(arr.base))
+	addr := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: addr, L: *base, R: *i, Op: OpAdd})
+
+	addrVar := NewLiteralOrVarVar(addr)
+	return &addrVar, nil
+}
+
+// emitBoundsCheck emits the code halting the chip if index is not within [0, size)
+func (fr *Frontend) emitBoundsCheck(index IRVar, size int64) {
+	zero := fr.emitLiteral(*NewIntLiteral(0))
+	last := fr.emitLiteral(*NewIntLiteral(size - 1))
+	below := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: below, L: index, R: *zero, Op: OpLt})
+	above := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: above, L: *last, R: index, Op: OpLt})
+	outside := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: outside, L: below, R: above, Op: OpOr})
+
+	okLbl := fr.newLabel()
+	fr.emit(IRIfZ{Cond: outside, Label: okLbl})
+	fr.emit(IRBuiltinCallVoid{BuiltinName: "hcf"})
+	fr.emit(IRLabel{Label: okLbl})
+}
+
+func (fr *Frontend) compileArrayIndex(ai *parser.ArrayIndex) (*IRVar, error) {
+	addr, err := fr.compileArrayAddress(ai.Ident, ai.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	v := fr.newVar()
+	fr.emit(IRStackLoad{Ret: v, Address: *addr})
+	return &v, nil
+}
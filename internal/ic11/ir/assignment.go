@@ -0,0 +1,128 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+// lvalue is a resolved assignment target: a scalar variable or an element of an array
+type lvalue struct {
+	name IRVar
+	// address is set for array elements
+	address *IRLiteralOrVar
+}
+
+// resolveLValue checks that the target can be assigned to and computes the address of array elements
+func (fr *Frontend) resolveLValue(l *parser.LValue) (*lvalue, error) {
+	lv, err := fr.resolveLValueNode(l)
+	return lv, diagnostic.Wrap(l.Pos, err)
+}
+
+func (fr *Frontend) resolveLValueNode(l *parser.LValue) (*lvalue, error) {
+	if _, found := fr.consts[l.Ident]; found {
+		return nil, fmt.Errorf("%w: %s", ErrAssignToConst, l.Ident)
+	}
+
+	if l.Index != nil {
+		addr, err := fr.compileArrayAddress(l.Ident, l.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		return &lvalue{name: IRVar(l.Ident), address: addr}, nil
+	}
+
+	if _, found := fr.arrays[l.Ident]; found {
+		return nil, fmt.Errorf("%w: %s", ErrArrayAsScalar, l.Ident)
+	}
+
+	return &lvalue{name: IRVar(l.Ident)}, nil
+}
+
+// load returns a variable holding the current value of the lvalue
+func (fr *Frontend) load(lv *lvalue) IRVar {
+	if lv.address == nil {
+		return lv.name
+	}
+
+	v := fr.newVar()
+	fr.emit(IRStackLoad{Ret: v, Address: *lv.address})
+	return v
+}
+
+// store assigns v to the lvalue
+func (fr *Frontend) store(lv *lvalue, v IRVar) {
+	if lv.address == nil {
+		fr.named[lv.name] = true
+		fr.emit(IRAssignVar{Assignee: lv.name, ValueVar: v})
+		return
+	}
+
+	fr.emit(IRStackStore{Address: *lv.address, Value: v})
+}
+
+// compileAssignment compiles simple and compound assignments.
+// Compound assignments are desugared, i.e. a += b is compiled as a = a + b.
+// The returned variable holds the assigned value, so assignments can be chained and used in expressions.
+func (fr *Frontend) compileAssignment(a *parser.Assignment) (*IRVar, error) {
+	lv, err := fr.resolveLValue(a.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	outer := fr.inVolatile
+	fr.inVolatile = outer || lv.address == nil && fr.volatile[lv.name]
+	v, err := fr.compileExpr(a.Right)
+	fr.inVolatile = outer
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Op != "=" {
+		op, err := binaryOp(a.Op[:len(a.Op)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		res := fr.newVar()
+		fr.emit(IRAssignBinary{Assignee: res, L: fr.load(lv), R: *v, Op: op})
+		v = &res
+	}
+
+	fr.store(lv, *v)
+	return v, nil
+}
+
+// compileIncDec compiles ++ and -- operators.
+// Prefix form evaluates to the new value of the operand, postfix form to the old one.
+func (fr *Frontend) compileIncDec(l *parser.LValue, op string, prefix bool) (*IRVar, error) {
+	lv, err := fr.resolveLValue(l)
+	if err != nil {
+		return nil, err
+	}
+
+	old := fr.load(lv)
+	if !prefix && lv.address == nil {
+		// Scalar has to be copied, as it's going to be overwritten
+		cp := fr.newVar()
+		fr.emit(IRAssignVar{Assignee: cp, ValueVar: old})
+		old = cp
+	}
+
+	irOp := OpAdd
+	if op == "--" {
+		irOp = OpSub
+	}
+
+	one := fr.emitLiteral(*NewIntLiteral(1))
+	res := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: res, L: old, R: *one, Op: irOp})
+	fr.store(lv, res)
+
+	if prefix {
+		return &res, nil
+	}
+	return &old, nil
+}
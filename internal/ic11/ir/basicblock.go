@@ -3,6 +3,8 @@ package ir
 import (
 	"fmt"
 	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
 )
 
 // A basic block is defined as a sequence of instructions that has only one entrypoint and one exit
@@ -33,6 +35,17 @@ func (bb *BasicBlock) Print() string {
 	return b.String()
 }
 
+// Next returns the successors of the block. For blocks ending with IfZ, the first one is the block executed
+// if the condition is not zero; for blocks not ending with a jump, it's the block that follows.
+func (bb *BasicBlock) Next() []*BasicBlock {
+	return bb.next
+}
+
+// Instructions returns the instructions of the block
+func (bb *BasicBlock) Instructions() []IRInstruction {
+	return bb.program.Get()
+}
+
 func (bb *BasicBlock) addPrev(prev *BasicBlock) {
 	bb.prev = append(bb.prev, prev)
 }
@@ -41,6 +54,6 @@ func (bb *BasicBlock) addNext(next *BasicBlock) {
 	bb.next = append(bb.next, next)
 }
 
-func (bb *BasicBlock) emit(instr IRInstruction) {
-	bb.program.Emit(instr)
+func (bb *BasicBlock) emit(instr IRInstruction, pos lexer.Position) {
+	bb.program.EmitAt(instr, pos)
 }
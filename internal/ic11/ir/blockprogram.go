@@ -3,6 +3,7 @@ package ir
 import (
 	"strings"
 
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/greg2010/ic11c/internal/stack"
 )
 
@@ -40,6 +41,17 @@ func (bp *BlockProgram) String() string {
 	return b.String()
 }
 
+// Entrypoint returns the block the program starts at, nil if the program is empty
+func (bp *BlockProgram) Entrypoint() *BasicBlock {
+	return bp.entrypoint
+}
+
+// Block returns the block starting with the label
+func (bp *BlockProgram) Block(label IRLabelType) (*BasicBlock, bool) {
+	bb, found := bp.blockLabels[label]
+	return bb, found
+}
+
 func (bp *BlockProgram) FifoSort() []*BasicBlock {
 	arr := []*BasicBlock{}
 	c := make(chan *BasicBlock, len(bp.blocks))
@@ -88,9 +100,21 @@ func TraverseBasicBlockPreorder(bb *BasicBlock) []*BasicBlock {
 	return soFar
 }
 
+// Program returns the instructions of the blocks in the order they were emitted
+func (bp *BlockProgram) Program() *Program {
+	p := NewProgram()
+	for _, bb := range bp.blocks {
+		for i, instr := range bb.program.Get() {
+			p.EmitAt(instr, bb.program.Pos(i))
+		}
+	}
+
+	return p
+}
+
 func (bp *BlockProgram) process(p *Program) {
-	for _, i := range p.Get() {
-		bp.emit(i)
+	for idx, i := range p.Get() {
+		bp.emit(i, p.Pos(idx))
 	}
 }
 
@@ -123,19 +147,19 @@ func (bp *BlockProgram) findOrCreateBlockWithLabel(label IRLabelType) *BasicBloc
 	return bp.newBasicBlock(&label)
 }
 
-func (bp *BlockProgram) emitToCurrentBlock(instr IRInstruction) {
+func (bp *BlockProgram) emitToCurrentBlock(instr IRInstruction, pos lexer.Position) {
 	cur := bp.current
 	if cur == nil {
 		cur = bp.newBasicBlock(nil)
 		bp.setCurrent(cur)
 	}
-	cur.emit(instr)
+	cur.emit(instr, pos)
 	if bp.entrypoint == nil {
 		bp.entrypoint = cur
 	}
 }
 
-func (bp *BlockProgram) emit(instr IRInstruction) {
+func (bp *BlockProgram) emit(instr IRInstruction, pos lexer.Position) {
 	switch i := instr.(type) {
 	case IRLabel:
 		// IRLabel indicates a new block started
@@ -143,16 +167,16 @@ func (bp *BlockProgram) emit(instr IRInstruction) {
 		bp.linkToCurrent(block)
 		// Emit label instruction and set current to the block
 		bp.setCurrent(block)
-		block.emit(i)
+		block.emit(i, pos)
 	case IRGoto:
 		// IRGoto terminates current block
-		bp.emitToCurrentBlock(instr)
+		bp.emitToCurrentBlock(instr, pos)
 		next := bp.findOrCreateBlockWithLabel(i.Label)
 		bp.linkToCurrent(next)
 		// We just emitted goto, next non-label instruction won't belong to a block
 		bp.current = nil
 	case IRIfZ:
-		bp.emitToCurrentBlock(i)
+		bp.emitToCurrentBlock(i, pos)
 		// We just emitted ifZ, our next blocks are wherever goto leads and next sequential block
 		// that we create here without a label
 		nextSeq := bp.newBasicBlock(nil)
@@ -161,6 +185,6 @@ func (bp *BlockProgram) emit(instr IRInstruction) {
 		bp.linkToCurrent(nextGoto)
 		bp.setCurrent(nextSeq)
 	default:
-		bp.emitToCurrentBlock(instr)
+		bp.emitToCurrentBlock(instr, pos)
 	}
 }
@@ -0,0 +1,352 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+var ErrNotConstant = errors.New("expression is not constant")
+var ErrConstTypeMismatch = errors.New("constant type mismatch")
+var ErrConstRedeclared = errors.New("constant redeclared")
+var ErrAssignToConst = errors.New("assignment to constant")
+var ErrDivisionByZero = errors.New("division by zero in constant expression")
+
+// compileConstDec evaluates a const declaration and registers it in the frontend constant table
+func (fr *Frontend) compileConstDec(c *parser.ConstDec) error {
+	if _, found := fr.consts[c.Name]; found {
+		return fr.redeclared(c.Pos, c.Name)
+	}
+	fr.declared[c.Name] = c.Pos
+
+	lit, err := fr.evalConst(c.Value)
+	if err != nil {
+		return diagnostic.Wrap(c.Value.Pos, fmt.Errorf("const %s: %w", c.Name, err))
+	}
+
+	lit, err = convertConst(lit, c.Type)
+	if err != nil {
+		return fmt.Errorf("const %s: %w", c.Name, err)
+	}
+
+	fr.consts[c.Name] = *lit
+	return nil
+}
+
+// redeclared returns an error pointing to both declarations of a constant
+func (fr *Frontend) redeclared(pos lexer.Position, name string) error {
+	return &diagnostic.Diagnostic{
+		Pos:   pos,
+		Err:   fmt.Errorf("%w: %s", ErrConstRedeclared, name),
+		Notes: []diagnostic.Note{{Pos: fr.declared[name], Message: "previously declared here"}},
+	}
+}
+
+// convertConst converts literal to the declared type of a constant.
+// Numeric types are converted with C semantics, strings can only be assigned to strings.
+func convertConst(lit *IRLiteralType, typ string) (*IRLiteralType, error) {
+	switch typ {
+	case "int":
+		if lit.valueInt != nil {
+			return lit, nil
+		}
+		if lit.valueFloat != nil {
+			return NewIntLiteral(int64(*lit.valueFloat)), nil
+		}
+	case "float":
+		if lit.valueFloat != nil {
+			return lit, nil
+		}
+		if lit.valueInt != nil {
+			return NewFloatLiteral(float64(*lit.valueInt)), nil
+		}
+	case "string":
+		if lit.valueString != nil {
+			return lit, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: cannot use %s as %s", ErrConstTypeMismatch, lit, typ)
+}
+
+// evalConst evaluates expression at compile time.
+// Returns ErrNotConstant if the expression depends on anything that is not known at compile time.
+func (fr *Frontend) evalConst(e *parser.Expr) (*IRLiteralType, error) {
+	if e.Assignment != nil || e.PrefixIncDec != nil || e.PostfixIncDec != nil {
+		return nil, fmt.Errorf("%w: assignment", ErrNotConstant)
+	}
+
+	if e.Ternary != nil {
+		cond, err := fr.evalConst(e.Ternary.Cond)
+		if err != nil {
+			return nil, err
+		}
+
+		c, ok := cond.float()
+		if !ok {
+			return nil, fmt.Errorf("%w: condition must be a number", ErrConstTypeMismatch)
+		}
+		if c != 0 {
+			return fr.evalConst(e.Ternary.Then)
+		}
+		return fr.evalConst(e.Ternary.Else)
+	}
+
+	if e.Binary != nil {
+		l, err := fr.evalConst(e.Binary.LHS)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := fr.evalConst(e.Binary.RHS)
+		if err != nil {
+			return nil, err
+		}
+
+		return evalConstBinary(e.Binary.Op, l, r)
+	}
+
+	if e.Unary != nil {
+		r, err := fr.evalConst(e.Unary.RHS)
+		if err != nil {
+			return nil, err
+		}
+
+		return evalConstUnary(e.Unary.Op, r)
+	}
+
+	if e.Primary != nil {
+		return fr.evalConstPrimary(e.Primary)
+	}
+
+	return nil, ErrInvalidState
+}
+
+func (fr *Frontend) evalConstPrimary(p *parser.Primary) (*IRLiteralType, error) {
+	if p.Literal != nil {
+		return parserLiteralToIRLiteral(p.Literal)
+	}
+
+	if p.Ident != "" {
+		if lit, found := fr.consts[p.Ident]; found {
+			return &lit, nil
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrNotConstant, p.Ident)
+	}
+
+	if p.SubExpression != nil {
+		return fr.evalConst(p.SubExpression)
+	}
+
+	if p.CallFunc != nil {
+		return fr.evalConstCallFunc(p.CallFunc)
+	}
+
+	if p.ArrayIndex != nil {
+		return nil, fmt.Errorf("%w: %s[]", ErrNotConstant, p.ArrayIndex.Ident)
+	}
+
+	return nil, ErrInvalidState
+}
+
+// constBuiltins1 are the pure builtins of arity 1 that can be computed at compile time
+var constBuiltins1 = map[string]func(float64) float64{
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"abs":   math.Abs,
+	"acos":  math.Acos,
+	"asin":  math.Asin,
+	"atan":  math.Atan,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"sqrt":  math.Sqrt,
+	"round": math.Round,
+	"trunc": math.Trunc,
+}
+
+// constBuiltins2 are the pure builtins of arity 2 that can be computed at compile time
+var constBuiltins2 = map[string]func(float64, float64) float64{
+	"max": math.Max,
+	"min": math.Min,
+	"mod": func(a, b float64) float64 {
+		// IC10 mod always returns a non-negative result
+		return math.Mod(math.Mod(a, b)+b, b)
+	},
+}
+
+func (fr *Frontend) evalConstCallFunc(c *parser.CallFunc) (*IRLiteralType, error) {
+	if c.Ident == "hash" {
+		if len(c.Index) != 1 {
+			return nil, ErrInvalidFunctionCall
+		}
+
+		arg, err := fr.evalConst(c.Index[0])
+		if err != nil {
+			return nil, err
+		}
+		if arg.valueString == nil {
+			return nil, fmt.Errorf("%w: hash expects a string", ErrConstTypeMismatch)
+		}
+
+		return NewIntLiteral(int64(ic11.ComputeHash(string(*arg.valueString)))), nil
+	}
+
+	f1, found1 := constBuiltins1[c.Ident]
+	f2, found2 := constBuiltins2[c.Ident]
+	if !found1 && !found2 {
+		return nil, fmt.Errorf("%w: call to %s", ErrNotConstant, c.Ident)
+	}
+
+	var args []float64
+	for _, argExpr := range c.Index {
+		arg, err := fr.evalConst(argExpr)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := arg.float()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects a number", ErrConstTypeMismatch, c.Ident)
+		}
+		args = append(args, f)
+	}
+
+	if found1 {
+		if len(args) != 1 {
+			return nil, ErrInvalidFunctionCall
+		}
+		return NewFloatLiteral(f1(args[0])), nil
+	}
+
+	if len(args) != 2 {
+		return nil, ErrInvalidFunctionCall
+	}
+	return NewFloatLiteral(f2(args[0], args[1])), nil
+}
+
+func evalConstUnary(op string, r *IRLiteralType) (*IRLiteralType, error) {
+	switch op {
+	case "-":
+		if r.valueInt != nil {
+			return NewIntLiteral(-int64(*r.valueInt)), nil
+		}
+		if r.valueFloat != nil {
+			return NewFloatLiteral(-float64(*r.valueFloat)), nil
+		}
+	case "!":
+		if f, ok := r.float(); ok {
+			return boolLiteral(f == 0), nil
+		}
+	case "~":
+		if i, ok := r.int(); ok {
+			return NewIntLiteral(^i), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: cannot apply %s to %s", ErrConstTypeMismatch, op, r)
+}
+
+func evalConstBinary(op string, l, r *IRLiteralType) (*IRLiteralType, error) {
+	// Integer arithmetic is kept integer, except for division which is done as by IC10 div, in floating point
+	if l.valueInt != nil && r.valueInt != nil {
+		a, b := int64(*l.valueInt), int64(*r.valueInt)
+		switch op {
+		case "+":
+			return NewIntLiteral(a + b), nil
+		case "-":
+			return NewIntLiteral(a - b), nil
+		case "*":
+			return NewIntLiteral(a * b), nil
+		case "/":
+			if b == 0 {
+				return nil, ErrDivisionByZero
+			}
+			if a%b == 0 {
+				return NewIntLiteral(a / b), nil
+			}
+		case "%":
+			// % follows IC10 mod semantics, so that folded and computed results are the same
+			if b == 0 {
+				return nil, ErrDivisionByZero
+			}
+			return NewIntLiteral(((a % b) + b) % b), nil
+		}
+	}
+
+	// Bitwise operators are only defined for integral values
+	if a, okL := l.int(); okL {
+		if b, okR := r.int(); okR {
+			switch op {
+			case "&":
+				return NewIntLiteral(a & b), nil
+			case "|":
+				return NewIntLiteral(a | b), nil
+			case "^":
+				return NewIntLiteral(a ^ b), nil
+			case "<<":
+				return NewIntLiteral(a << uint64(b)), nil
+			case ">>":
+				return NewIntLiteral(a >> uint64(b)), nil
+			case ">>>":
+				return NewIntLiteral(int64(uint64(a) >> uint64(b))), nil
+			}
+		}
+	}
+
+	a, okL := l.float()
+	b, okR := r.float()
+	if !okL || !okR {
+		return nil, fmt.Errorf("%w: cannot apply %s to %s and %s", ErrConstTypeMismatch, op, l, r)
+	}
+
+	switch op {
+	case "+":
+		return NewFloatLiteral(a + b), nil
+	case "-":
+		return NewFloatLiteral(a - b), nil
+	case "*":
+		return NewFloatLiteral(a * b), nil
+	case "/":
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return NewFloatLiteral(a / b), nil
+	case "%":
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return NewFloatLiteral(constBuiltins2["mod"](a, b)), nil
+	case "==":
+		return boolLiteral(a == b), nil
+	case "!=":
+		return boolLiteral(a != b), nil
+	case "<":
+		return boolLiteral(a < b), nil
+	case "<=":
+		return boolLiteral(a <= b), nil
+	case ">":
+		return boolLiteral(a > b), nil
+	case ">=":
+		return boolLiteral(a >= b), nil
+	case "&&":
+		return boolLiteral(a != 0 && b != 0), nil
+	case "||":
+		return boolLiteral(a != 0 || b != 0), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %s", ErrConstTypeMismatch, op)
+	}
+}
+
+func boolLiteral(b bool) *IRLiteralType {
+	if b {
+		return NewIntLiteral(1)
+	}
+	return NewIntLiteral(0)
+}
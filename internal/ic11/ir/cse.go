@@ -0,0 +1,401 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commutativeOps are the binary operators whose operands can be swapped
+var commutativeOps = map[IROp]bool{
+	OpAdd:    true,
+	OpMul:    true,
+	OpEq:     true,
+	OpNe:     true,
+	OpAnd:    true,
+	OpOr:     true,
+	OpBitAnd: true,
+	OpBitOr:  true,
+	OpBitXor: true,
+}
+
+// A value is an expression computed earlier, held by a variable
+type value struct {
+	operands []IRVar
+	holder   IRVar
+	// load is set for device loads, device is the device read or "" if it may be any
+	load   bool
+	device string
+}
+
+// A valueTable numbers the values known at a point of the program: each expression is numbered by the variable
+// holding it, copies of a variable by the variable itself and variables holding literals by the literal
+type valueTable struct {
+	exprs    map[string]value
+	copies   map[IRVar]IRVar
+	literals map[IRVar]string
+}
+
+func newValueTable() *valueTable {
+	return &valueTable{
+		exprs:    make(map[string]value),
+		copies:   make(map[IRVar]IRVar),
+		literals: make(map[IRVar]string),
+	}
+}
+
+func (t *valueTable) clone() *valueTable {
+	c := newValueTable()
+	for key, v := range t.exprs {
+		c.exprs[key] = v
+	}
+	for v, src := range t.copies {
+		c.copies[v] = src
+	}
+	for v, lit := range t.literals {
+		c.literals[v] = lit
+	}
+
+	return c
+}
+
+// meet keeps the values known in both tables
+func (t *valueTable) meet(other *valueTable) {
+	for key, v := range t.exprs {
+		if o, found := other.exprs[key]; !found || o.holder != v.holder {
+			delete(t.exprs, key)
+		}
+	}
+	for v, src := range t.copies {
+		if other.copies[v] != src {
+			delete(t.copies, v)
+		}
+	}
+	for v, lit := range t.literals {
+		if o, found := other.literals[v]; !found || o != lit {
+			delete(t.literals, v)
+		}
+	}
+}
+
+func (t *valueTable) equal(other *valueTable) bool {
+	if len(t.exprs) != len(other.exprs) || len(t.copies) != len(other.copies) || len(t.literals) != len(other.literals) {
+		return false
+	}
+	for key, v := range t.exprs {
+		if o, found := other.exprs[key]; !found || o.holder != v.holder {
+			return false
+		}
+	}
+	for v, src := range t.copies {
+		if other.copies[v] != src {
+			return false
+		}
+	}
+	for v, lit := range t.literals {
+		if o, found := other.literals[v]; !found || o != lit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// canonical returns the variable v is a copy of, v itself if it's not a copy
+func (t *valueTable) canonical(v IRVar) IRVar {
+	if src, found := t.copies[v]; found {
+		return src
+	}
+
+	return v
+}
+
+// kill forgets the values v is part of, as v is assigned
+func (t *valueTable) kill(v IRVar) {
+	delete(t.literals, v)
+	delete(t.copies, v)
+	for c, src := range t.copies {
+		if src == v {
+			delete(t.copies, c)
+		}
+	}
+	for key, val := range t.exprs {
+		if val.holder == v || containsVar(val.operands, v) {
+			delete(t.exprs, key)
+		}
+	}
+}
+
+// invalidateLoads forgets the values loaded from the device, from any device if device is ""
+func (t *valueTable) invalidateLoads(device string) {
+	for key, val := range t.exprs {
+		if val.load && (device == "" || val.device == "" || val.device == device) {
+			delete(t.exprs, key)
+		}
+	}
+}
+
+// transfer numbers the values of the instructions, starting with what's known before them.
+// It returns the instructions with variables replaced by the ones they are copies of, and expressions computed
+// again replaced by copies. Instructions recomputing the value their assignee holds are returned as nil.
+func (t *valueTable) transfer(instructions []IRInstruction) []IRInstruction {
+	out := make([]IRInstruction, len(instructions))
+	for n, instr := range instructions {
+		instr = t.rewriteUses(instr)
+		assignee, assigns := def(instr)
+		val, key, isExpr := t.expression(instr)
+		if prev, found := t.exprs[key]; isExpr && found {
+			if prev.holder == assignee {
+				continue
+			}
+			instr = IRAssignVar{Assignee: assignee, ValueVar: prev.holder}
+			isExpr = false
+		}
+
+		t.effects(instr)
+		if assigns {
+			t.kill(assignee)
+		}
+		switch i := instr.(type) {
+		case IRAssignVar:
+			if i.ValueVar != i.Assignee {
+				t.copies[i.Assignee] = i.ValueVar
+			}
+		case IRAssignLiteral:
+			t.literals[i.Assignee] = i.ValueVar.String()
+		}
+		// a = a + 1 computes a value that a no longer holds once assigned
+		if isExpr && !containsVar(val.operands, assignee) {
+			val.holder = assignee
+			t.exprs[key] = val
+		}
+		out[n] = instr
+	}
+
+	return out
+}
+
+// effects forgets the values an instruction may change other than its assignee
+func (t *valueTable) effects(instr IRInstruction) {
+	switch i := instr.(type) {
+	case IRBuiltinCallVoid:
+		device := ""
+		if len(i.Params) > 0 && i.BuiltinName == "store" {
+			if lit, ok := i.Params[0].Literal(); ok {
+				device = lit.String()
+			}
+		}
+		t.invalidateLoads(device)
+	case IRInlineAsm:
+		// Inline assembly may assign its operands and use devices
+		*t = *newValueTable()
+	}
+}
+
+// rewriteUses replaces the variables an instruction reads by the ones they are copies of.
+// Operands of inline assembly are kept, as it may assign them.
+func (t *valueTable) rewriteUses(instr IRInstruction) IRInstruction {
+	params := func(params []IRLiteralOrVar) []IRLiteralOrVar {
+		rewritten := make([]IRLiteralOrVar, len(params))
+		for n, param := range params {
+			rewritten[n] = t.rewriteOperand(param)
+		}
+		return rewritten
+	}
+
+	switch i := instr.(type) {
+	case IRAssignVar:
+		i.ValueVar = t.canonical(i.ValueVar)
+		return i
+	case IRAssignBinary:
+		i.L, i.R = t.canonical(i.L), t.canonical(i.R)
+		return i
+	case IRAssignUnary:
+		i.R = t.canonical(i.R)
+		return i
+	case IRAssignSelect:
+		i.Cond, i.L, i.R = t.canonical(i.Cond), t.canonical(i.L), t.canonical(i.R)
+		return i
+	case IRIfZ:
+		i.Cond = t.canonical(i.Cond)
+		return i
+	case IRBuiltinCallVoid:
+		i.Params = params(i.Params)
+		return i
+	case IRBuiltinCallRet:
+		i.Params = params(i.Params)
+		return i
+	case IRStackLoad:
+		i.Address = t.rewriteOperand(i.Address)
+		return i
+	case IRStackStore:
+		i.Address = t.rewriteOperand(i.Address)
+		i.Value = t.canonical(i.Value)
+		return i
+	}
+
+	return instr
+}
+
+func (t *valueTable) rewriteOperand(operand IRLiteralOrVar) IRLiteralOrVar {
+	if v, ok := operand.Var(); ok {
+		return NewLiteralOrVarVar(t.canonical(v))
+	}
+
+	return operand
+}
+
+// operand returns the key of a variable in expressions: the literal it holds, or the variable itself
+func (t *valueTable) operand(v IRVar) string {
+	if lit, found := t.literals[v]; found {
+		return "l:" + lit
+	}
+
+	return "v:" + string(v)
+}
+
+// expression returns the value computed by a pure instruction and the key numbering it
+func (t *valueTable) expression(instr IRInstruction) (value, string, bool) {
+	switch i := instr.(type) {
+	case IRAssignBinary:
+		l, r := t.operand(i.L), t.operand(i.R)
+		if commutativeOps[i.Op] && r < l {
+			l, r = r, l
+		}
+		return value{operands: []IRVar{i.L, i.R}}, fmt.Sprintf("%d %s %s", i.Op, l, r), true
+	case IRAssignUnary:
+		return value{operands: []IRVar{i.R}}, fmt.Sprintf("%d %s", i.Op, t.operand(i.R)), true
+	case IRAssignSelect:
+		key := fmt.Sprintf("? %s %s %s", t.operand(i.Cond), t.operand(i.L), t.operand(i.R))
+		return value{operands: []IRVar{i.Cond, i.L, i.R}}, key, true
+	case IRBuiltinCallRet:
+		if !pureBuiltins[i.BuiltinName] || i.Volatile {
+			return value{}, "", false
+		}
+		val := value{load: i.BuiltinName == "load" || i.BuiltinName == "load_batch"}
+		var b strings.Builder
+		b.WriteString(i.BuiltinName)
+		for _, param := range i.Params {
+			if v, ok := param.Var(); ok {
+				val.operands = append(val.operands, v)
+				fmt.Fprintf(&b, " %s", t.operand(v))
+			} else {
+				fmt.Fprintf(&b, " l:%s", param)
+			}
+		}
+		if i.BuiltinName == "load" && len(i.Params) > 0 {
+			if lit, ok := i.Params[0].Literal(); ok {
+				val.device = lit.String()
+			}
+		}
+		return val, b.String(), true
+	}
+
+	return value{}, "", false
+}
+
+func containsVar(vars []IRVar, v IRVar) bool {
+	for _, x := range vars {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EliminateCommonSubexpressions replaces expressions computed again while a variable holds their value by copies
+// of the variable, with value numbering over the blocks of the program. Values known at the start of a block are
+// the ones known at the end of all blocks leading to it. Device loads are reused until the chip waits or a device is
+// written, unless they are volatile, and never around loops: a loop spans ticks even without yielding, as the chip
+// only runs a limited number of lines per tick. Copies and literals that are not read afterwards are removed.
+func EliminateCommonSubexpressions(p *Program) (*Program, error) {
+	bp := NewBlockProgram(p)
+	exits := make(map[*BasicBlock]*valueTable)
+	order := make(map[*BasicBlock]int, len(bp.blocks))
+	for n, bb := range bp.blocks {
+		order[bb] = n
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, bb := range bp.blocks {
+			t := bp.entryValues(bb, exits, order)
+			t.transfer(bb.Instructions())
+			if exit, found := exits[bb]; !found || !exit.equal(t) {
+				exits[bb] = t
+				changed = true
+			}
+		}
+	}
+
+	for _, bb := range bp.blocks {
+		instructions := bp.entryValues(bb, exits, order).transfer(bb.Instructions())
+		program := NewProgram()
+		for n, instr := range instructions {
+			if instr != nil {
+				program.EmitAt(instr, bb.program.Pos(n))
+			}
+		}
+		bb.program = program
+	}
+
+	return removeDeadCopies(bp.Program())
+}
+
+// entryValues returns the values known at the start of a block. Blocks leading to it that weren't numbered yet
+// are left out. Loads are dropped when the block is entered from itself or a block after it, as every loop has
+// such a back edge.
+func (bp *BlockProgram) entryValues(bb *BasicBlock, exits map[*BasicBlock]*valueTable, order map[*BasicBlock]int) *valueTable {
+	if bb == bp.entrypoint {
+		return newValueTable()
+	}
+
+	var t *valueTable
+	loop := false
+	for _, prev := range bb.prev {
+		loop = loop || order[prev] >= order[bb]
+		exit, found := exits[prev]
+		switch {
+		case !found:
+		case t == nil:
+			t = exit.clone()
+		default:
+			t.meet(exit)
+		}
+	}
+	if t == nil {
+		return newValueTable()
+	}
+	if loop {
+		t.invalidateLoads("")
+	}
+
+	return t
+}
+
+// removeDeadCopies removes copies and literals assigned to variables that are not read afterwards
+func removeDeadCopies(p *Program) (*Program, error) {
+	for {
+		live, err := liveOut(p.Get())
+		if err != nil {
+			return nil, err
+		}
+		out := NewProgram()
+		for n, instr := range p.Get() {
+			switch i := instr.(type) {
+			case IRAssignVar:
+				if !live[n][i.Assignee] {
+					continue
+				}
+			case IRAssignLiteral:
+				if !live[n][i.Assignee] {
+					continue
+				}
+			}
+			out.EmitAt(instr, p.Pos(n))
+		}
+		if len(out.Get()) == len(p.Get()) {
+			return out, nil
+		}
+		p = out
+	}
+}
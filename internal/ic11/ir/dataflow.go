@@ -0,0 +1,155 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownLabel = errors.New("unknown label")
+
+// uses returns the variables an instruction reads
+func uses(instr IRInstruction) []IRVar {
+	vars := []IRVar{}
+	addOperands := func(operands []IRLiteralOrVar) {
+		for _, operand := range operands {
+			if v, ok := operand.Var(); ok {
+				vars = append(vars, v)
+			}
+		}
+	}
+
+	switch i := instr.(type) {
+	case IRAssignVar:
+		vars = append(vars, i.ValueVar)
+	case IRAssignBinary:
+		vars = append(vars, i.L, i.R)
+	case IRAssignUnary:
+		vars = append(vars, i.R)
+	case IRAssignSelect:
+		vars = append(vars, i.Cond, i.L, i.R)
+	case IRIfZ:
+		vars = append(vars, i.Cond)
+	case IRBuiltinCallVoid:
+		addOperands(i.Params)
+	case IRBuiltinCallRet:
+		addOperands(i.Params)
+	case IRStackLoad:
+		addOperands([]IRLiteralOrVar{i.Address})
+	case IRStackStore:
+		addOperands([]IRLiteralOrVar{i.Address})
+		vars = append(vars, i.Value)
+	case IRInlineAsm:
+		// Inline assembly may read any of its operands
+		addOperands(i.Operands)
+	}
+
+	return vars
+}
+
+// def returns the variable an instruction assigns, if any
+func def(instr IRInstruction) (IRVar, bool) {
+	switch i := instr.(type) {
+	case IRAssignLiteral:
+		return i.Assignee, true
+	case IRAssignVar:
+		return i.Assignee, true
+	case IRAssignBinary:
+		return i.Assignee, true
+	case IRAssignUnary:
+		return i.Assignee, true
+	case IRAssignSelect:
+		return i.Assignee, true
+	case IRBuiltinCallRet:
+		return i.Ret, true
+	case IRStackLoad:
+		return i.Ret, true
+	}
+
+	return "", false
+}
+
+// successors returns indexes of the instructions that may be executed after each instruction.
+// It fails if an instruction jumps to a label that is not in the program.
+func successors(instructions []IRInstruction) ([][]int, error) {
+	labels := map[IRLabelType]int{}
+	for i, instr := range instructions {
+		if label, ok := instr.(IRLabel); ok {
+			labels[label.Label] = i
+		}
+	}
+
+	target := func(label IRLabelType) (int, error) {
+		i, found := labels[label]
+		if !found {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownLabel, label)
+		}
+		return i, nil
+	}
+
+	succ := make([][]int, len(instructions))
+	for i, instr := range instructions {
+		next := []int{}
+		if ifz, ok := instr.(IRIfZ); ok {
+			t, err := target(ifz.Label)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, t)
+		}
+		if jump, ok := instr.(IRGoto); ok {
+			t, err := target(jump.Label)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, t)
+		} else if i+1 < len(instructions) {
+			next = append(next, i+1)
+		}
+		succ[i] = next
+	}
+
+	return succ, nil
+}
+
+// liveOut computes the variables that may be read after each instruction before being assigned again
+func liveOut(instructions []IRInstruction) ([]map[IRVar]bool, error) {
+	succ, err := successors(instructions)
+	if err != nil {
+		return nil, err
+	}
+	liveIn := make([]map[IRVar]bool, len(instructions))
+	out := make([]map[IRVar]bool, len(instructions))
+	for i := range instructions {
+		liveIn[i] = map[IRVar]bool{}
+		out[i] = map[IRVar]bool{}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(instructions) - 1; i >= 0; i-- {
+			for _, s := range succ[i] {
+				for v := range liveIn[s] {
+					out[i][v] = true
+				}
+			}
+
+			in := map[IRVar]bool{}
+			for v := range out[i] {
+				in[v] = true
+			}
+			if v, ok := def(instructions[i]); ok {
+				delete(in, v)
+			}
+			for _, v := range uses(instructions[i]) {
+				in[v] = true
+			}
+
+			if len(in) != len(liveIn[i]) {
+				changed = true
+			}
+			liveIn[i] = in
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,18 @@
+package ir
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnknownLabel(t *testing.T) {
+	program := NewProgram()
+	program.Emit(IRLabel{Label: "start"})
+	program.Emit(IRIfZ{Cond: "a", Label: "end"})
+	program.Emit(IRGoto{Label: "start"})
+
+	_, err := EliminateCommonSubexpressions(program)
+	if !errors.Is(err, ErrUnknownLabel) {
+		t.Errorf("got %v, want %v", err, ErrUnknownLabel)
+	}
+}
@@ -0,0 +1,222 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+var ErrEnumRedeclared = errors.New("enum redeclared")
+var ErrInvalidEnumValue = errors.New("enum value must be an integer constant")
+var ErrUnknownType = errors.New("unknown type")
+var ErrEnumMismatch = errors.New("enum type mismatch")
+
+// compileEnumDec registers the values of an enum as integer constants
+func (fr *Frontend) compileEnumDec(e *parser.EnumDec) error {
+	if fr.enums[e.Name] {
+		return fmt.Errorf("%w: %s", ErrEnumRedeclared, e.Name)
+	}
+	fr.enums[e.Name] = true
+
+	next := int64(0)
+	for _, value := range e.Values {
+		if _, found := fr.consts[value.Name]; found {
+			return fr.redeclared(value.Pos, value.Name)
+		}
+		fr.declared[value.Name] = value.Pos
+
+		if value.Value != nil {
+			lit, err := fr.evalConst(value.Value)
+			if err != nil {
+				return diagnostic.Wrap(value.Value.Pos, fmt.Errorf("enum %s: %s: %w", e.Name, value.Name, err))
+			}
+			i, ok := lit.int()
+			if !ok {
+				return diagnostic.Wrap(value.Value.Pos, fmt.Errorf("enum %s: %s: %w", e.Name, value.Name, ErrInvalidEnumValue))
+			}
+			next = i
+		}
+
+		fr.consts[value.Name] = *NewIntLiteral(next)
+		fr.enumConsts[value.Name] = e.Name
+		next++
+	}
+
+	return nil
+}
+
+// declareScalar records the declared type of a variable, so that enum variables can be type checked
+func (fr *Frontend) declareScalar(s *parser.ScalarDec) error {
+	switch s.Type {
+	case "int", "float", "string":
+	default:
+		if !fr.enums[s.Type] {
+			return fmt.Errorf("%w: %s %s", ErrUnknownType, s.Type, s.Name)
+		}
+	}
+
+	fr.varTypes[s.Name] = s.Type
+	if s.Volatile {
+		fr.volatile[IRVar(s.Name)] = true
+	}
+	return nil
+}
+
+// checkStmtTypes checks the expressions of a statement, nested statements are checked when they are compiled
+func (fr *Frontend) checkStmtTypes(s *parser.Stmt) error {
+	exprs := []*parser.Expr{}
+	switch {
+	case s.Expr != nil:
+		exprs = append(exprs, s.Expr)
+	case s.IfStmt != nil:
+		exprs = append(exprs, s.IfStmt.Condition)
+	case s.WhileStmt != nil:
+		exprs = append(exprs, s.WhileStmt.Condition)
+	case s.ReturnStmt != nil:
+		exprs = append(exprs, s.ReturnStmt.Result)
+	case s.Assignment != nil:
+		exprs = append(exprs, &parser.Expr{Assignment: s.Assignment})
+	case s.CallFunc != nil:
+		exprs = append(exprs, s.CallFunc.Index...)
+	}
+
+	for _, e := range exprs {
+		if _, err := fr.typeOf(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// typeOf returns the type of an expression: a builtin type, a name of an enum,
+// or an empty string if the type is not known, e.g. for undeclared variables and builtin calls.
+// Enum values can only be combined with values of the same enum or of unknown type.
+func (fr *Frontend) typeOf(e *parser.Expr) (string, error) {
+	switch {
+	case e == nil:
+		return "", nil
+	case e.Assignment != nil:
+		return fr.typeOfAssignment(e.Assignment)
+	case e.PrefixIncDec != nil:
+		return fr.typeOfLValue(e.PrefixIncDec.Right)
+	case e.PostfixIncDec != nil:
+		return fr.typeOfLValue(e.PostfixIncDec.Left)
+	case e.Ternary != nil:
+		if _, err := fr.typeOf(e.Ternary.Cond); err != nil {
+			return "", err
+		}
+		return fr.typeOfOperands(":", e.Ternary.Pos, e.Ternary.Then, e.Ternary.Else)
+	case e.Binary != nil:
+		typ, err := fr.typeOfOperands(e.Binary.Op, e.Binary.Pos, e.Binary.LHS, e.Binary.RHS)
+		if err != nil {
+			return "", err
+		}
+		if fr.enums[typ] {
+			// Arithmetic on enum values and comparisons produce plain integers
+			return "int", nil
+		}
+		return typ, nil
+	case e.Unary != nil:
+		typ, err := fr.typeOf(e.Unary.RHS)
+		if err != nil || !fr.enums[typ] {
+			return typ, err
+		}
+		return "int", nil
+	case e.Primary != nil:
+		return fr.typeOfPrimary(e.Primary)
+	}
+
+	return "", nil
+}
+
+func (fr *Frontend) typeOfPrimary(p *parser.Primary) (string, error) {
+	switch {
+	case p.Literal != nil:
+		lit, err := parserLiteralToIRLiteral(p.Literal)
+		if err != nil {
+			return "", err
+		}
+		return literalType(lit), nil
+	case p.Ident != "":
+		if enum, found := fr.enumConsts[p.Ident]; found {
+			return enum, nil
+		}
+		if lit, found := fr.consts[p.Ident]; found {
+			return literalType(&lit), nil
+		}
+		return fr.varTypes[p.Ident], nil
+	case p.SubExpression != nil:
+		return fr.typeOf(p.SubExpression)
+	case p.ArrayIndex != nil:
+		_, err := fr.typeOf(p.ArrayIndex.Index)
+		return "", err
+	case p.CallFunc != nil:
+		for _, arg := range p.CallFunc.Index {
+			if _, err := fr.typeOf(arg); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (fr *Frontend) typeOfAssignment(a *parser.Assignment) (string, error) {
+	op := a.Op[:len(a.Op)-1]
+	if op == "" {
+		op = "="
+	}
+
+	return fr.typeOfOperands(op, a.Pos, &parser.Expr{Primary: &parser.Primary{Ident: a.Left.Ident}}, a.Right)
+}
+
+func (fr *Frontend) typeOfLValue(l *parser.LValue) (string, error) {
+	if l.Index != nil {
+		_, err := fr.typeOf(l.Index)
+		return "", err
+	}
+
+	return fr.varTypes[l.Ident], nil
+}
+
+// typeOfOperands checks that the operands of op can be combined and returns their common type
+func (fr *Frontend) typeOfOperands(op string, pos lexer.Position, lhs, rhs *parser.Expr) (string, error) {
+	l, err := fr.typeOf(lhs)
+	if err != nil {
+		return "", err
+	}
+	r, err := fr.typeOf(rhs)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case l == "" || r == "":
+		return "", nil
+	case l == r:
+		return l, nil
+	case fr.enums[l] || fr.enums[r]:
+		return "", diagnostic.Wrap(pos, fmt.Errorf("%w: cannot use %s with %s and %s", ErrEnumMismatch, op, l, r))
+	case l == "float" || r == "float":
+		return "float", nil
+	default:
+		return l, nil
+	}
+}
+
+func literalType(lit *IRLiteralType) string {
+	switch {
+	case lit.valueInt != nil:
+		return "int"
+	case lit.valueFloat != nil:
+		return "float"
+	case lit.valueString != nil:
+		return "string"
+	}
+
+	return ""
+}
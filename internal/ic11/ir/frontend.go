@@ -4,27 +4,68 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
 	"github.com/greg2010/ic11c/internal/ic11/parser"
 )
 
 var ErrInvalidFunctionCall = errors.New("invalid function call")
+var ErrUnknownFunction = errors.New("unknown function")
 var ErrInvalidState = errors.New("parser produced invalid state")
 var ErrMainFuncParameters = errors.New("main function cannot have parameters")
 
+// FrontendOptions configure the code generated by Frontend
+type FrontendOptions struct {
+	// BoundsCheck enables runtime checks of array indexes that are not known at compile time
+	BoundsCheck bool
+}
+
 type Frontend struct {
+	opts       FrontendOptions
+	diags      *diagnostic.Collector
 	varCount   int
 	labelCount int
 	program    *Program
+	consts     map[string]IRLiteralType
+	arrays     map[string]*array
+	stackTop   int64
+	// enums contains the names of declared enums, enumConsts maps enum values to their enum
+	enums      map[string]bool
+	enumConsts map[string]string
+	// varTypes contains declared types of scalar variables
+	varTypes map[string]string
+	// declared contains the positions of constant declarations
+	declared map[string]lexer.Position
+	// named contains the variables declared or assigned in the source, as opposed to temporaries
+	named map[IRVar]bool
+	// volatile contains the variables declared volatile. Calls in expressions assigned to them are marked volatile,
+	// so that device loads are made every time. inVolatile is set while such an expression is compiled.
+	volatile   map[IRVar]bool
+	inVolatile bool
+	// pos is the position of the statement being compiled
+	pos lexer.Position
 }
 
-func NewFrontend(ast *parser.AST) (*Frontend, error) {
+// NewFrontend compiles AST to IR. Errors are reported to diags, compilation resumes at the next statement or declaration.
+func NewFrontend(ast *parser.AST, opts FrontendOptions, diags *diagnostic.Collector) (*Frontend, error) {
 	ir := Frontend{
+		opts:       opts,
+		diags:      diags,
 		varCount:   0,
 		labelCount: 0,
 		program:    NewProgram(),
+		consts:     make(map[string]IRLiteralType),
+		arrays:     make(map[string]*array),
+		stackTop:   StackSize,
+		enums:      make(map[string]bool),
+		enumConsts: make(map[string]string),
+		varTypes:   make(map[string]string),
+		named:      make(map[IRVar]bool),
+		volatile:   make(map[IRVar]bool),
+		declared:   make(map[string]lexer.Position),
 	}
-	err := ir.compile(ast)
-	if err != nil {
+	ir.compile(ast)
+	if err := diags.Err(); err != nil {
 		return nil, err
 	}
 
@@ -52,5 +93,5 @@ func (ir *Frontend) newLabel() IRLabelType {
 }
 
 func (ir *Frontend) emit(instr IRInstruction) {
-	ir.program.Emit(instr)
+	ir.program.EmitAt(instr, ir.pos)
 }
@@ -2,36 +2,95 @@ package ir
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
 	"github.com/greg2010/ic11c/internal/ic11/parser"
 )
 
 // compile traverses the AST, calling corresponding compile* functions for each node type.
-func (fr *Frontend) compile(ast *parser.AST) error {
+// Errors are reported to the diagnostics collector, so that a single run finds as many of them as possible.
+func (fr *Frontend) compile(ast *parser.AST) {
+	if ast == nil {
+		return
+	}
+
+	// Global declarations are processed first so that they are visible in every function
+	for _, top := range ast.TopDec {
+		if top.EnumDec != nil {
+			fr.report(top.Pos, fr.compileEnumDec(top.EnumDec))
+		}
+		if top.VarDec != nil {
+			fr.report(top.Pos, fr.compileVarDec(top.VarDec))
+		}
+	}
+
 	for _, top := range ast.TopDec {
 		if top.FunDec != nil && top.FunDec.Name == "main" {
 			if len(top.FunDec.Parameters) != 0 {
-				return ErrMainFuncParameters
+				fr.report(top.Pos, ErrMainFuncParameters)
+				continue
 			}
 
-			err := fr.compileFunDec(top.FunDec)
-			if err != nil {
-				return err
-			}
+			fr.compileFunDec(top.FunDec)
 		}
 	}
 
-	return nil
+	fr.checkDeclarations(ast)
+	fr.checkUnusedAssignments()
+}
+
+// report adds err to the diagnostics, unless it's nil
+func (fr *Frontend) report(pos lexer.Position, err error) {
+	if err != nil {
+		fr.diags.Report(pos, err)
+	}
 }
 
 // AST -> IR compile methods
 
-func (fr *Frontend) compileFunDec(f *parser.FunDec) error {
-	for _, stmt := range f.FunBody.Stmts.Stmts {
-		err := fr.compileStmt(stmt)
-		if err != nil {
-			return err
+func (fr *Frontend) compileFunDec(f *parser.FunDec) {
+	if f.FunBody == nil {
+		return
+	}
+
+	// Local constants are only visible in the function
+	for _, local := range f.FunBody.Locals {
+		if c := local.ConstDec; c != nil {
+			if _, found := fr.consts[c.Name]; !found {
+				defer delete(fr.consts, c.Name)
+				defer delete(fr.declared, c.Name)
+			}
 		}
+		fr.report(local.Pos, fr.compileVarDec(local))
+	}
+
+	fr.compileStmts(f.FunBody.Stmts.Stmts)
+}
+
+// compileStmts compiles a list of statements. An error in one statement is reported and the next one is compiled.
+func (fr *Frontend) compileStmts(stmts []*parser.Stmt) {
+	for _, stmt := range stmts {
+		fr.pos = stmt.Pos
+		fr.report(stmt.Pos, fr.compileStmt(stmt))
+	}
+}
+
+// compileVarDec registers constants and allocates arrays. Scalars need no code to be emitted.
+func (fr *Frontend) compileVarDec(v *parser.VarDec) error {
+	if v.ConstDec != nil {
+		return fr.compileConstDec(v.ConstDec)
+	}
+
+	if v.ArrayDec != nil {
+		return fr.declareArray(v.ArrayDec)
+	}
+
+	if v.ScalarDec != nil {
+		return fr.declareScalar(v.ScalarDec)
 	}
 
 	return nil
@@ -42,6 +101,10 @@ func (fr *Frontend) compileStmt(s *parser.Stmt) error {
 		return nil
 	}
 
+	if err := fr.checkStmtTypes(s); err != nil {
+		return err
+	}
+
 	if s.Expr != nil {
 		_, err := fr.compileExpr(s.Expr)
 		if err != nil {
@@ -68,8 +131,12 @@ func (fr *Frontend) compileStmt(s *parser.Stmt) error {
 		return nil
 	}
 
+	if s.AsmStmt != nil {
+		return fr.compileAsmStmt(s.AsmStmt)
+	}
+
 	if s.Assignment != nil {
-		err := fr.compileAssignment(s.Assignment)
+		_, err := fr.compileAssignment(s.Assignment)
 		if err != nil {
 			return err
 		}
@@ -86,13 +153,7 @@ func (fr *Frontend) compileStmt(s *parser.Stmt) error {
 	}
 
 	if s.Block != nil {
-		for _, subStmt := range s.Block.Stmts {
-			err := fr.compileStmt(subStmt)
-			if err != nil {
-				return err
-			}
-		}
-
+		fr.compileStmts(s.Block.Stmts)
 		return nil
 	}
 
@@ -108,10 +169,23 @@ func (fr *Frontend) compilePrimary(p *parser.Primary) (*IRVar, error) {
 
 	// If variable, just return it
 	if p.Ident != "" {
+		// Constants are inlined as literals
+		if lit, found := fr.consts[p.Ident]; found {
+			return fr.compileConst(lit)
+		}
+
+		if _, found := fr.arrays[p.Ident]; found {
+			return nil, fmt.Errorf("%w: %s", ErrArrayAsScalar, p.Ident)
+		}
+
 		v := IRVar(p.Ident)
 		return &v, nil
 	}
 
+	if p.ArrayIndex != nil {
+		return fr.compileArrayIndex(p.ArrayIndex)
+	}
+
 	if p.SubExpression != nil {
 		return fr.compileExpr(p.SubExpression)
 	}
@@ -129,12 +203,57 @@ func (fr *Frontend) compileLiteral(l *parser.Literal) (*IRVar, error) {
 		return nil, err
 	}
 
+	return fr.compileConst(*lit)
+}
+
+// compileConst emits a constant used as a value. Strings are only taken by builtins, IC10 has no string values.
+func (fr *Frontend) compileConst(lit IRLiteralType) (*IRVar, error) {
+	if lit.valueString != nil {
+		return nil, fmt.Errorf("%w: cannot use string %q as a value", ErrConstTypeMismatch, string(*lit.valueString))
+	}
+
+	return fr.emitLiteral(lit), nil
+}
+
+func (fr *Frontend) emitLiteral(lit IRLiteralType) *IRVar {
 	v := fr.newVar()
-	fr.emit(IRAssignLiteral{Assignee: v, ValueVar: *lit})
-	return &v, nil
+	fr.emit(IRAssignLiteral{Assignee: v, ValueVar: lit})
+	return &v
 }
 
+// compileExpr compiles an expression. Errors are tied to the position of the innermost expression they occurred in.
 func (fr *Frontend) compileExpr(e *parser.Expr) (*IRVar, error) {
+	v, err := fr.compileExprNode(e)
+	return v, diagnostic.Wrap(e.Pos, err)
+}
+
+func (fr *Frontend) compileExprNode(e *parser.Expr) (*IRVar, error) {
+	// Expressions that can be computed at compile time are folded into a single literal, errors computing them are
+	// errors of the program
+	lit, err := fr.evalConst(e)
+	if err == nil {
+		return fr.compileConst(*lit)
+	}
+	if !errors.Is(err, ErrNotConstant) {
+		return nil, err
+	}
+
+	if e.Assignment != nil {
+		return fr.compileAssignment(e.Assignment)
+	}
+
+	if e.PrefixIncDec != nil {
+		return fr.compileIncDec(e.PrefixIncDec.Right, e.PrefixIncDec.Op, true)
+	}
+
+	if e.PostfixIncDec != nil {
+		return fr.compileIncDec(e.PostfixIncDec.Left, e.PostfixIncDec.Op, false)
+	}
+
+	if e.Ternary != nil {
+		return fr.compileTernary(e.Ternary)
+	}
+
 	if e.Binary != nil {
 		return fr.compileBinary(e.Binary)
 	}
@@ -151,39 +270,124 @@ func (fr *Frontend) compileExpr(e *parser.Expr) (*IRVar, error) {
 }
 
 func (fr *Frontend) compileBinary(b *parser.Binary) (*IRVar, error) {
+	op, err := binaryOp(b.Op)
+	if err != nil {
+		return nil, err
+	}
+
+	if (op == OpAnd || op == OpOr) && hasSideEffects(b.RHS) {
+		return fr.compileShortCircuit(b, op)
+	}
+
 	v := fr.newVar()
 
-	l, err := fr.compilePrimary(b.LHS)
+	l, err := fr.compileExpr(b.LHS)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := fr.compilePrimary(b.RHS)
+	r, err := fr.compileExpr(b.RHS)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO convert binary operations to the known set of ops
-	fr.emit(IRAssignBinary{Assignee: v, L: *l, R: *r, Op: b.Op})
+	fr.emit(IRAssignBinary{Assignee: v, L: *l, R: *r, Op: op})
 	return &v, nil
 }
 
-func (fr *Frontend) compileUnary(u *parser.Unary) (*IRVar, error) {
-	// TODO implement unary ops
-	return nil, errors.New("invalid unary state")
+// compileShortCircuit compiles && and || so that the right side is only evaluated when it determines the result
+func (fr *Frontend) compileShortCircuit(b *parser.Binary, op IROp) (*IRVar, error) {
+	l, err := fr.compileExpr(b.LHS)
+	if err != nil {
+		return nil, err
+	}
+
+	zero := fr.emitLiteral(*NewIntLiteral(0))
+	v := fr.newVar()
+	fr.emit(IRAssignBinary{Assignee: v, L: *l, R: *zero, Op: OpNe})
+
+	// && skips the right side if the left one is false, || if it's true
+	skip := v
+	if op == OpOr {
+		skip = fr.newVar()
+		fr.emit(IRAssignUnary{Assignee: skip, R: v, Op: OpNot})
+	}
+	endLbl := fr.newLabel()
+	fr.emit(IRIfZ{Cond: skip, Label: endLbl})
+
+	r, err := fr.compileExpr(b.RHS)
+	if err != nil {
+		return nil, err
+	}
+	fr.emit(IRAssignBinary{Assignee: v, L: *r, R: *zero, Op: OpNe})
+	fr.emit(IRLabel{Label: endLbl})
+
+	return &v, nil
 }
 
-func (fr *Frontend) compileAssignment(a *parser.Assignment) error {
-	v, err := fr.compileExpr(a.Right)
+// compileTernary compiles c ? a : b into a single select instruction.
+// If any of the branches has side effects, only the selected branch may be evaluated, so branches are emitted instead.
+func (fr *Frontend) compileTernary(t *parser.Ternary) (*IRVar, error) {
+	cond, err := fr.compileExpr(t.Cond)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fr.emit(IRAssignVar{Assignee: IRVar(a.Left), ValueVar: *v})
-	return nil
+	v := fr.newVar()
+	if !hasSideEffects(t.Then) && !hasSideEffects(t.Else) {
+		l, err := fr.compileExpr(t.Then)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := fr.compileExpr(t.Else)
+		if err != nil {
+			return nil, err
+		}
+
+		fr.emit(IRAssignSelect{Assignee: v, Cond: *cond, L: *l, R: *r})
+		return &v, nil
+	}
+
+	elseLbl := fr.newLabel()
+	endLbl := fr.newLabel()
+	fr.emit(IRIfZ{Cond: *cond, Label: elseLbl})
+	l, err := fr.compileExpr(t.Then)
+	if err != nil {
+		return nil, err
+	}
+	fr.emit(IRAssignVar{Assignee: v, ValueVar: *l})
+	fr.emit(IRGoto{Label: endLbl})
+
+	fr.emit(IRLabel{Label: elseLbl})
+	r, err := fr.compileExpr(t.Else)
+	if err != nil {
+		return nil, err
+	}
+	fr.emit(IRAssignVar{Assignee: v, ValueVar: *r})
+	fr.emit(IRLabel{Label: endLbl})
+
+	return &v, nil
+}
+
+func (fr *Frontend) compileUnary(u *parser.Unary) (*IRVar, error) {
+	op, err := unaryOp(u.Op)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := fr.compileExpr(u.RHS)
+	if err != nil {
+		return nil, err
+	}
+
+	v := fr.newVar()
+	fr.emit(IRAssignUnary{Assignee: v, R: *r, Op: op})
+	return &v, nil
 }
 
 func (fr *Frontend) compileIfStmt(i *parser.IfStmt) error {
+	fr.checkCondition(i.Condition, nil)
 	cond, err := fr.compileExpr(i.Condition)
 	if err != nil {
 		return err
@@ -219,6 +423,7 @@ func (fr *Frontend) compileIfStmt(i *parser.IfStmt) error {
 }
 
 func (fr *Frontend) compileWhileStmt(w *parser.WhileStmt) error {
+	fr.checkCondition(w.Condition, w)
 	l1 := fr.newLabel()
 	l2 := fr.newLabel()
 
@@ -241,7 +446,50 @@ func (fr *Frontend) compileWhileStmt(w *parser.WhileStmt) error {
 	return nil
 }
 
+// builtinSignature is the number of arguments of a builtin function, and whether it returns a value
+type builtinSignature struct {
+	params int
+	ret    bool
+}
+
+// builtinSignatures are the builtin functions calls are compiled to, hash is only computed at compile time
+var builtinSignatures = map[string]builtinSignature{
+	"load": {2, true}, "load_batch": {3, true}, "store": {3, false}, "store_batch": {3, false},
+	"yield": {0, false}, "sleep": {1, false}, "hcf": {0, false}, "rand": {0, true},
+	"sin": {1, true}, "cos": {1, true}, "tan": {1, true}, "abs": {1, true}, "acos": {1, true}, "asin": {1, true},
+	"atan": {1, true}, "ceil": {1, true}, "floor": {1, true}, "log": {1, true}, "sqrt": {1, true},
+	"round": {1, true}, "trunc": {1, true}, "mod": {2, true}, "xor": {2, true}, "nor": {2, true}, "max": {2, true},
+	"min": {2, true},
+}
+
+// checkCall checks that a call is made to a builtin with the right number of arguments, and that it returns a value
+// if ret is set
+func checkCall(c *parser.CallFunc, ret bool) error {
+	sig, found := builtinSignatures[c.Ident]
+	switch {
+	case c.Ident == "hash":
+		return diagnostic.Wrap(c.Pos, fmt.Errorf("%w: hash expects a constant string", ErrInvalidFunctionCall))
+	case !found:
+		return diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s", ErrUnknownFunction, c.Ident))
+	case len(c.Index) != sig.params:
+		return diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s expects %d arguments", ErrInvalidFunctionCall, c.Ident, sig.params))
+	case ret && !sig.ret:
+		return diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s does not return a value", ErrInvalidFunctionCall, c.Ident))
+	}
+
+	return nil
+}
+
+// compileVoidCallFunc compiles a call made as a statement. The result of builtins returning a value is discarded.
 func (fr *Frontend) compileVoidCallFunc(c *parser.CallFunc) error {
+	if err := checkCall(c, false); err != nil {
+		return err
+	}
+	if builtinSignatures[c.Ident].ret {
+		_, err := fr.compileRetCallFunc(c)
+		return err
+	}
+
 	switch c.Ident {
 	case "store":
 		fallthrough
@@ -265,9 +513,15 @@ func (fr *Frontend) compileVoidCallFunc(c *parser.CallFunc) error {
 }
 
 func (fr *Frontend) compileRetCallFunc(c *parser.CallFunc) (*IRVar, error) {
+	if err := checkCall(c, true); err != nil {
+		return nil, err
+	}
+
 	switch c.Ident {
 	case "load":
 		return fr.compileBuiltinLoadFunc(c)
+	case "load_batch":
+		return fr.compileBuiltinLoadBatch(c)
 	default:
 		v := fr.newVar()
 
@@ -281,32 +535,60 @@ func (fr *Frontend) compileRetCallFunc(c *parser.CallFunc) (*IRVar, error) {
 			args = append(args, IRLiteralOrVar{v: argV})
 		}
 
-		instr := IRBuiltinCallRet{BuiltinName: c.Ident, Params: args, Ret: v}
+		instr := IRBuiltinCallRet{BuiltinName: c.Ident, Params: args, Ret: v, Volatile: fr.inVolatile}
 		fr.emit(instr)
 
 		return &v, nil
 	}
 }
 
+var (
+	// deviceRegexp matches the devices a chip is connected to, with an optional channel
+	deviceRegexp = regexp.MustCompile(`^(d[0-5]|db)(:[0-9]+)?$`)
+	// logicTypeRegexp matches names of logic types
+	logicTypeRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// deviceArg resolves the device argument of a builtin
+func deviceArg(c *parser.CallFunc, e *parser.Expr) (*IRLiteralType, error) {
+	if e.Primary == nil || !deviceRegexp.MatchString(e.Primary.Ident) {
+		return nil, diagnostic.Wrap(e.Pos, fmt.Errorf("%w: %s expects a device", ErrInvalidFunctionCall, c.Ident))
+	}
+
+	return NewStringLiteral(e.Primary.Ident), nil
+}
+
+// constArg resolves an argument of a builtin that is written literally in IC10: a number, or a string that name accepts.
+// what describes the argument in errors.
+func (fr *Frontend) constArg(c *parser.CallFunc, e *parser.Expr, what string, name func(string) bool) (*IRLiteralType, error) {
+	lit, err := fr.evalConst(e)
+	if err == nil && lit.valueString != nil && !name(string(*lit.valueString)) {
+		err = ErrConstTypeMismatch
+	}
+	if err != nil {
+		return nil, diagnostic.Wrap(e.Pos, fmt.Errorf("%w: %s expects a constant %s", ErrInvalidFunctionCall, c.Ident, what))
+	}
+
+	return lit, nil
+}
+
+func isLogicType(s string) bool {
+	return logicTypeRegexp.MatchString(s)
+}
+
 func (fr *Frontend) compileBuiltinLoadFunc(c *parser.CallFunc) (*IRVar, error) {
 	if len(c.Index) < 2 {
-		return nil, ErrInvalidFunctionCall
+		return nil, diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s expects 2 arguments", ErrInvalidFunctionCall, c.Ident))
 	}
 
 	// First arg is device (passed as ident)
-	device := c.Index[0]
-	if device.Primary == nil || device.Primary.Ident == "" {
-		return nil, ErrInvalidFunctionCall
-	}
-	arg0 := NewStringLiteral(device.Primary.Ident)
-
-	// Second arg is device's Variable (passed as string)
-	deviceVar := c.Index[1]
-	if deviceVar.Primary == nil || deviceVar.Primary.Literal == nil {
-		return nil, ErrInvalidFunctionCall
+	arg0, err := deviceArg(c, c.Index[0])
+	if err != nil {
+		return nil, err
 	}
 
-	arg1, err := parserLiteralToIRLiteral(deviceVar.Primary.Literal)
+	// Second arg is device's Variable (passed as string or a string constant)
+	arg1, err := fr.constArg(c, c.Index[1], "logic type", isLogicType)
 	if err != nil {
 		return nil, err
 	}
@@ -321,32 +603,57 @@ func (fr *Frontend) compileBuiltinLoadFunc(c *parser.CallFunc) (*IRVar, error) {
 	}
 
 	v := fr.newVar()
-	instr := IRBuiltinCallRet{c.Ident, args, v}
+	instr := IRBuiltinCallRet{BuiltinName: c.Ident, Params: args, Ret: v, Volatile: fr.inVolatile}
 	fr.emit(instr)
 	return &v, nil
 }
 
+// compileBuiltinLoadBatch compiles load_batch(hash, logicType, mode). The logic type and the batch mode are resolved literally.
+func (fr *Frontend) compileBuiltinLoadBatch(c *parser.CallFunc) (*IRVar, error) {
+	if len(c.Index) != 3 {
+		return nil, diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s expects 3 arguments", ErrInvalidFunctionCall, c.Ident))
+	}
+
+	hash, err := fr.compileExpr(c.Index[0])
+	if err != nil {
+		return nil, err
+	}
+	logicType, err := fr.constArg(c, c.Index[1], "logic type", isLogicType)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := fr.constArg(c, c.Index[2], "batch mode", ic11.IsBatchMode)
+	if err != nil {
+		return nil, err
+	}
+	args := []IRLiteralOrVar{{v: hash}, {lit: logicType}, {lit: mode}}
+
+	v := fr.newVar()
+	fr.emit(IRBuiltinCallRet{BuiltinName: c.Ident, Params: args, Ret: v, Volatile: fr.inVolatile})
+	return &v, nil
+}
+
 // compileBuiltinStore compiles store and store_batch builtins into IR assembly
 // this is required because these functions take special arguments that must be resolved literally
 func (fr *Frontend) compileBuiltinStore(c *parser.CallFunc) error {
 	if len(c.Index) < 3 {
-		return ErrInvalidFunctionCall
+		return diagnostic.Wrap(c.Pos, fmt.Errorf("%w: %s expects 3 arguments", ErrInvalidFunctionCall, c.Ident))
 	}
 
-	// First arg is device (passed as ident)
-	device := c.Index[0]
-	if device.Primary == nil || device.Primary.Ident == "" {
-		return ErrInvalidFunctionCall
+	// First arg is device (passed as ident), or a constant prefab hash for store_batch
+	var arg0 *IRLiteralType
+	var err error
+	if c.Ident == "store_batch" {
+		arg0, err = fr.constArg(c, c.Index[0], "prefab hash", func(string) bool { return false })
+	} else {
+		arg0, err = deviceArg(c, c.Index[0])
 	}
-	arg0 := NewStringLiteral(device.Primary.Ident)
-
-	// Second arg is device's Variable (passed as string)
-	deviceVar := c.Index[1]
-	if deviceVar.Primary == nil || deviceVar.Primary.Literal == nil {
-		return ErrInvalidFunctionCall
+	if err != nil {
+		return err
 	}
 
-	arg1, err := parserLiteralToIRLiteral(deviceVar.Primary.Literal)
+	// Second arg is device's Variable (passed as string or a string constant)
+	arg1, err := fr.constArg(c, c.Index[1], "logic type", isLogicType)
 	if err != nil {
 		return err
 	}
@@ -376,7 +683,7 @@ func (fr *Frontend) compileBuiltinStore(c *parser.CallFunc) error {
 
 func parserLiteralToIRLiteral(l *parser.Literal) (*IRLiteralType, error) {
 	if l.Int != nil {
-		return NewIntLiteral(*l.Int), nil
+		return NewIntLiteral(int64(*l.Int)), nil
 	}
 	if l.Float != nil {
 		return NewFloatLiteral(*l.Float), nil
@@ -0,0 +1,26 @@
+package ir
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+	"github.com/greg2010/ic11c/internal/ic11/preprocessor"
+	"github.com/greg2010/ic11c/internal/testutil"
+)
+
+func FuzzNewFrontend(f *testing.F) {
+	testutil.AddSeeds(f, "../compiler/testdata/*.uc", "../interpreter/testdata/*.uc")
+	f.Fuzz(func(t *testing.T, src string) {
+		diags := diagnostic.NewCollector(diagnostic.Options{})
+		ast, _ := parser.Parse([]io.Reader{strings.NewReader(src)}, preprocessor.Config{}, diags)
+		fr, err := NewFrontend(ast, FrontendOptions{BoundsCheck: true}, diags)
+		if err != nil {
+			return
+		}
+		_ = fr.String()
+		_ = NewBlockProgram(fr.Get()).String()
+	})
+}
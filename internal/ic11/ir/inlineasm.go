@@ -0,0 +1,108 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+var ErrInvalidAsmOperand = errors.New("invalid inline assembly operand")
+
+// asmOperandRegexp matches operand references (%0) and escaped percent signs (%%)
+var asmOperandRegexp = regexp.MustCompile(`%(%|\d+)`)
+
+// asmRegisterRegexp matches registers referenced directly, including indirect ones (rr0)
+var asmRegisterRegexp = regexp.MustCompile(`\br+(1[0-7]|[0-9])\b`)
+
+// asmStackRegexp matches lines changing sp or ra implicitly: stack instructions and jumps storing the return address
+var asmStackRegexp = regexp.MustCompile(`^(push|pop|peek|jal|b[a-z]+al)\b|\b(sp|ra)\b`)
+
+// Registers sp and ra are aliases of r16 and r17
+const (
+	spRegister = 16
+	raRegister = 17
+)
+
+func (fr *Frontend) compileAsmStmt(a *parser.AsmStmt) error {
+	code, names := a.Code, a.Operands
+	if a.Block != nil {
+		code, names = a.Block.Code, a.Block.Operands
+	}
+
+	// Variables are bound directly, so that the code can both read and write them. Constants are substituted.
+	operands := []IRLiteralOrVar{}
+	for _, name := range names {
+		if lit, found := fr.consts[name]; found {
+			operands = append(operands, NewLiteralOrVarLiteral(lit))
+			continue
+		}
+		if _, found := fr.arrays[name]; found {
+			return fmt.Errorf("%w: %s is an array", ErrInvalidAsmOperand, name)
+		}
+
+		operands = append(operands, NewLiteralOrVarVar(IRVar(name)))
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		for _, ref := range asmOperandRegexp.FindAllStringSubmatch(line, -1) {
+			if ref[1] == "%" {
+				continue
+			}
+			if i, _ := strconv.Atoi(ref[1]); i >= len(operands) {
+				return fmt.Errorf("%w: %s, %d operands bound", ErrInvalidAsmOperand, ref[0], len(operands))
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	fr.emit(IRInlineAsm{Lines: lines, Operands: operands})
+	return nil
+}
+
+// Expand returns the lines of the block with operand references replaced by the result of operand
+func (ir IRInlineAsm) Expand(operand func(IRLiteralOrVar) string) []string {
+	expanded := []string{}
+	for _, line := range ir.Lines {
+		expanded = append(expanded, asmOperandRegexp.ReplaceAllStringFunc(line, func(ref string) string {
+			if ref == "%%" {
+				return "%"
+			}
+			i, _ := strconv.Atoi(ref[1:])
+			return operand(ir.Operands[i])
+		}))
+	}
+
+	return expanded
+}
+
+// Clobbers returns the registers the block uses directly, sp and ra included when stack or link instructions use
+// them implicitly. These must not be assigned to variables.
+func (ir IRInlineAsm) Clobbers() []int {
+	clobbers := []int{}
+	for _, line := range ir.Lines {
+		// Operand references are not registers, %% escapes are skipped along with them, and so are comments
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = asmOperandRegexp.ReplaceAllString(line, " ")
+		for _, match := range asmRegisterRegexp.FindAllStringSubmatch(line, -1) {
+			register, _ := strconv.Atoi(match[1])
+			clobbers = append(clobbers, register)
+		}
+		if asmStackRegexp.MatchString(line) {
+			clobbers = append(clobbers, spRegister, raRegister)
+		}
+	}
+
+	return clobbers
+}
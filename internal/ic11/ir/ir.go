@@ -17,6 +17,9 @@ type IRLabelType string
 
 // Compound helper types
 
+// invalidOperand is printed in place of an operand that holds no value, so that malformed IR can still be dumped
+const invalidOperand = "<invalid>"
+
 type IRLiteralType struct {
 	// Only one of these can be set
 	valueInt    *IRIntConst
@@ -61,7 +64,45 @@ func (lit IRLiteralType) String() string {
 		return string(*lit.valueLabel)
 	}
 
-	panic("empty IRLiteralType")
+	return invalidOperand
+}
+
+// valid reports whether the literal holds a value
+func (lit IRLiteralType) valid() bool {
+	return lit.valueInt != nil || lit.valueFloat != nil || lit.valueString != nil || lit.valueLabel != nil
+}
+
+// Integer returns value of the literal if it is an integral number
+func (lit IRLiteralType) Integer() (int64, bool) {
+	return lit.int()
+}
+
+// Float returns value of the literal if it is a number
+func (lit IRLiteralType) Float() (float64, bool) {
+	return lit.float()
+}
+
+// float returns numeric value of the literal. ok is false if the literal is not a number
+func (lit IRLiteralType) float() (f float64, ok bool) {
+	if lit.valueInt != nil {
+		return float64(*lit.valueInt), true
+	}
+
+	if lit.valueFloat != nil {
+		return float64(*lit.valueFloat), true
+	}
+
+	return 0, false
+}
+
+// int returns integer value of the literal. ok is false if the literal is not an integral number
+func (lit IRLiteralType) int() (i int64, ok bool) {
+	f, ok := lit.float()
+	if !ok || f != float64(int64(f)) {
+		return 0, false
+	}
+
+	return int64(f), true
 }
 
 type IRLiteralOrVar struct {
@@ -78,6 +119,33 @@ func NewLiteralOrVarVar(v IRVar) IRLiteralOrVar {
 	return IRLiteralOrVar{v: &v}
 }
 
+// Var returns the variable if IRLiteralOrVar holds one
+func (litOrVar IRLiteralOrVar) Var() (IRVar, bool) {
+	if litOrVar.v == nil {
+		return "", false
+	}
+
+	return *litOrVar.v, true
+}
+
+// Valid reports whether IRLiteralOrVar holds a variable or a literal with a value
+func (litOrVar IRLiteralOrVar) Valid() bool {
+	if litOrVar.v != nil {
+		return true
+	}
+
+	return litOrVar.lit != nil && litOrVar.lit.valid()
+}
+
+// Literal returns the literal if IRLiteralOrVar holds one
+func (litOrVar IRLiteralOrVar) Literal() (IRLiteralType, bool) {
+	if litOrVar.lit == nil {
+		return IRLiteralType{}, false
+	}
+
+	return *litOrVar.lit, true
+}
+
 func (litOrVar IRLiteralOrVar) String() string {
 	if litOrVar.lit != nil {
 		return litOrVar.lit.String()
@@ -87,7 +155,7 @@ func (litOrVar IRLiteralOrVar) String() string {
 		return string(*litOrVar.v)
 	}
 
-	panic("empty irliteralOrVar")
+	return invalidOperand
 }
 
 // All instructions must implement the following interface
@@ -111,8 +179,23 @@ type IRAssignBinary struct {
 	Assignee IRVar
 	L        IRVar
 	R        IRVar
-	// Op can be one of '+', '-', '*', '/', '==', '<', '&&', '||'
-	Op string
+	// Op is one of the binary operators
+	Op IROp
+}
+
+type IRAssignUnary struct {
+	Assignee IRVar
+	R        IRVar
+	// Op is one of the unary operators
+	Op IROp
+}
+
+// IRAssignSelect assigns L to Assignee if Cond is not zero, R otherwise
+type IRAssignSelect struct {
+	Assignee IRVar
+	Cond     IRVar
+	L        IRVar
+	R        IRVar
 }
 
 type IRLabel struct {
@@ -136,6 +219,26 @@ type IRBuiltinCallRet struct {
 	BuiltinName string
 	Params      []IRLiteralOrVar
 	Ret         IRVar
+	// Volatile calls are always made, their results are never reused
+	Volatile bool
+}
+
+// IRStackLoad reads a word of the stack memory at Address
+type IRStackLoad struct {
+	Ret     IRVar
+	Address IRLiteralOrVar
+}
+
+// IRStackStore writes Value to the stack memory at Address
+type IRStackStore struct {
+	Address IRLiteralOrVar
+	Value   IRVar
+}
+
+// IRInlineAsm is an opaque block of IC10 code. %0, %1, ... in Lines refer to Operands, %% is a literal %.
+type IRInlineAsm struct {
+	Lines    []string
+	Operands []IRLiteralOrVar
 }
 
 // All of the IR instructions implement String() to assist with debugging
@@ -144,6 +247,14 @@ func (ir IRAssignBinary) String() string {
 	return fmt.Sprintf("%s = %s %s %s;", ir.Assignee, ir.L, ir.Op, ir.R)
 }
 
+func (ir IRAssignUnary) String() string {
+	return fmt.Sprintf("%s = %s%s;", ir.Assignee, ir.Op, ir.R)
+}
+
+func (ir IRAssignSelect) String() string {
+	return fmt.Sprintf("%s = %s ? %s : %s;", ir.Assignee, ir.Cond, ir.L, ir.R)
+}
+
 func (ir IRLabel) String() string {
 	return fmt.Sprintf("%s:", ir.Label)
 }
@@ -177,5 +288,25 @@ func (ir IRBuiltinCallRet) String() string {
 	for _, param := range ir.Params {
 		strParams = append(strParams, param.String())
 	}
-	return fmt.Sprintf("%s = Bcall %s %s;", ir.Ret, ir.BuiltinName, strings.Join(strParams, " "))
+	name := ir.BuiltinName
+	if ir.Volatile {
+		name = "volatile " + name
+	}
+	return fmt.Sprintf("%s = Bcall %s %s;", ir.Ret, name, strings.Join(strParams, " "))
+}
+
+func (ir IRStackLoad) String() string {
+	return fmt.Sprintf("%s = Stack[%s];", ir.Ret, ir.Address)
+}
+
+func (ir IRStackStore) String() string {
+	return fmt.Sprintf("Stack[%s] = %s;", ir.Address, ir.Value)
+}
+
+func (ir IRInlineAsm) String() string {
+	strOperands := []string{}
+	for _, operand := range ir.Operands {
+		strOperands = append(strOperands, operand.String())
+	}
+	return fmt.Sprintf("Asm %q %s;", strings.Join(ir.Lines, "\n"), strings.Join(strOperands, " "))
 }
@@ -0,0 +1,118 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownOperator = errors.New("unknown operator")
+
+// IROp is an operator of IRAssignBinary or IRAssignUnary
+type IROp int
+
+// Binary operators
+const (
+	OpAdd IROp = iota
+	OpSub
+	OpMul
+	OpDiv
+	// OpMod follows IC10 semantics: the result is never negative
+	OpMod
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	// OpAnd and OpOr are logical operators, their result is either 0 or 1
+	OpAnd
+	OpOr
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	// OpShr is an arithmetic shift, OpShrU is a logical one
+	OpShr
+	OpShrU
+)
+
+// Unary operators
+const (
+	OpNeg IROp = iota + OpShrU + 1
+	OpNot
+	OpBitNot
+)
+
+var binaryOpNames = map[IROp]string{
+	OpAdd:    "+",
+	OpSub:    "-",
+	OpMul:    "*",
+	OpDiv:    "/",
+	OpMod:    "%",
+	OpEq:     "==",
+	OpNe:     "!=",
+	OpLt:     "<",
+	OpLe:     "<=",
+	OpGt:     ">",
+	OpGe:     ">=",
+	OpAnd:    "&&",
+	OpOr:     "||",
+	OpBitAnd: "&",
+	OpBitOr:  "|",
+	OpBitXor: "^",
+	OpShl:    "<<",
+	OpShr:    ">>",
+	OpShrU:   ">>>",
+}
+
+var unaryOpNames = map[IROp]string{
+	OpNeg:    "-",
+	OpNot:    "!",
+	OpBitNot: "~",
+}
+
+func (op IROp) String() string {
+	if name, found := binaryOpNames[op]; found {
+		return name
+	}
+
+	if name, found := unaryOpNames[op]; found {
+		return name
+	}
+
+	return fmt.Sprintf("IROp(%d)", int(op))
+}
+
+// IsBinary reports whether op is an operator of IRAssignBinary
+func (op IROp) IsBinary() bool {
+	_, found := binaryOpNames[op]
+	return found
+}
+
+// IsUnary reports whether op is an operator of IRAssignUnary
+func (op IROp) IsUnary() bool {
+	_, found := unaryOpNames[op]
+	return found
+}
+
+// binaryOp converts source representation of a binary operator to IROp
+func binaryOp(s string) (IROp, error) {
+	for op, name := range binaryOpNames {
+		if name == s {
+			return op, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownOperator, s)
+}
+
+// unaryOp converts source representation of a unary operator to IROp
+func unaryOp(s string) (IROp, error) {
+	for op, name := range unaryOpNames {
+		if name == s {
+			return op, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownOperator, s)
+}
@@ -3,19 +3,34 @@ package ir
 import (
 	"fmt"
 	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
 )
 
 // An IR Program is a list of IR instructions
 type Program struct {
 	instructions []IRInstruction
+	// positions are the positions in the source of the statements instructions were compiled from
+	positions []lexer.Position
 }
 
 func NewProgram() *Program {
-	return &Program{instructions: []IRInstruction{}}
+	return &Program{instructions: []IRInstruction{}, positions: []lexer.Position{}}
 }
 
 func (p *Program) Emit(i IRInstruction) {
+	p.EmitAt(i, lexer.Position{})
+}
+
+// EmitAt emits an instruction compiled from the source at pos
+func (p *Program) EmitAt(i IRInstruction, pos lexer.Position) {
 	p.instructions = append(p.instructions, i)
+	p.positions = append(p.positions, pos)
+}
+
+// Pos returns the position in the source of the i-th instruction
+func (p *Program) Pos(i int) lexer.Position {
+	return p.positions[i]
 }
 
 func (p *Program) Get() []IRInstruction {
@@ -0,0 +1,69 @@
+package ir
+
+import "github.com/greg2010/ic11c/internal/ic11/parser"
+
+// pureBuiltins are the builtins that can be evaluated any number of times without changing the program state
+var pureBuiltins = map[string]bool{
+	"hash":       true,
+	"load":       true,
+	"load_batch": true,
+	"sin":        true,
+	"cos":        true,
+	"tan":        true,
+	"abs":        true,
+	"acos":       true,
+	"asin":       true,
+	"atan":       true,
+	"ceil":       true,
+	"floor":      true,
+	"log":        true,
+	"sqrt":       true,
+	"round":      true,
+	"trunc":      true,
+	"mod":        true,
+	"xor":        true,
+	"nor":        true,
+	"max":        true,
+	"min":        true,
+}
+
+// hasSideEffects reports whether evaluating the expression may change the program state,
+// i.e. it contains assignments or calls to functions that are not pure builtins
+func hasSideEffects(e *parser.Expr) bool {
+	switch {
+	case e == nil:
+		return false
+	case e.Assignment != nil, e.PrefixIncDec != nil, e.PostfixIncDec != nil:
+		return true
+	case e.Ternary != nil:
+		return hasSideEffects(e.Ternary.Cond) || hasSideEffects(e.Ternary.Then) || hasSideEffects(e.Ternary.Else)
+	case e.Binary != nil:
+		return hasSideEffects(e.Binary.LHS) || hasSideEffects(e.Binary.RHS)
+	case e.Unary != nil:
+		return hasSideEffects(e.Unary.RHS)
+	case e.Primary != nil:
+		return primaryHasSideEffects(e.Primary)
+	}
+
+	return false
+}
+
+func primaryHasSideEffects(p *parser.Primary) bool {
+	switch {
+	case p.SubExpression != nil:
+		return hasSideEffects(p.SubExpression)
+	case p.ArrayIndex != nil:
+		return hasSideEffects(p.ArrayIndex.Index)
+	case p.CallFunc != nil:
+		if !pureBuiltins[p.CallFunc.Ident] {
+			return true
+		}
+		for _, arg := range p.CallFunc.Index {
+			if hasSideEffects(arg) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
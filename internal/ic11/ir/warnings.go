@@ -0,0 +1,223 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/parser"
+)
+
+// Names of the warnings reported by the frontend, enabled with -W<name> and disabled with -Wno-<name>
+const (
+	WarnUnusedVariable    = "unused-variable"
+	WarnUnusedParameter   = "unused-parameter"
+	WarnUnusedFunction    = "unused-function"
+	WarnUnusedAssignment  = "unused-assignment"
+	WarnShadow            = "shadow"
+	WarnInfiniteLoop      = "infinite-loop"
+	WarnConstantCondition = "constant-condition"
+)
+
+// Warnings lists the names of all warnings
+var Warnings = []string{
+	WarnUnusedVariable,
+	WarnUnusedParameter,
+	WarnUnusedFunction,
+	WarnUnusedAssignment,
+	WarnShadow,
+	WarnInfiniteLoop,
+	WarnConstantCondition,
+}
+
+var ErrUnusedVariable = errors.New("unused variable")
+var ErrUnusedParameter = errors.New("unused parameter")
+var ErrUnusedFunction = errors.New("unused function")
+var ErrUnusedAssignment = errors.New("assigned value is never read")
+var ErrShadow = errors.New("shadowed declaration")
+var ErrInfiniteLoop = errors.New("infinite loop without yield or sleep exceeds the instruction limit of a tick")
+var ErrConstantCondition = errors.New("condition is constant")
+
+func (fr *Frontend) warn(code string, pos lexer.Position, err error) {
+	fr.diags.Warn(&diagnostic.Diagnostic{Code: code, Pos: pos, Err: err})
+}
+
+// checkCondition warns about if and while conditions that are known at compile time.
+// while (1) is the usual main loop, so loops with a literal condition are only checked for yield and sleep.
+func (fr *Frontend) checkCondition(cond *parser.Expr, loop *parser.WhileStmt) {
+	lit, err := fr.evalConst(cond)
+	if err != nil {
+		return
+	}
+	value, ok := lit.float()
+	if !ok {
+		return
+	}
+
+	if loop == nil || cond.Primary == nil || cond.Primary.Literal == nil || value == 0 {
+		fr.warn(WarnConstantCondition, cond.Pos, fmt.Errorf("%w: always %t", ErrConstantCondition, value != 0))
+	}
+	if loop != nil && value != 0 && !yields(loop.Body) {
+		d := &diagnostic.Diagnostic{Code: WarnInfiniteLoop, Pos: loop.Pos, Err: ErrInfiniteLoop}
+		if loop.Body.Block != nil {
+			// The body starts with the opening brace
+			pos := loop.Body.Pos
+			pos.Column++
+			pos.Offset++
+			d.Fixes = append(d.Fixes, diagnostic.Fix{Description: "yield at the start of every iteration", Pos: pos, End: pos, Text: " yield();"})
+		}
+		fr.diags.Warn(d)
+	}
+}
+
+// yields reports whether a statement contains a call to yield or sleep
+func yields(s *parser.Stmt) bool {
+	found := false
+	parser.Walk(s, func(node any) bool {
+		switch n := node.(type) {
+		case *parser.CallFunc:
+			found = found || n.Ident == "yield" || n.Ident == "sleep"
+		case *parser.AsmStmt:
+			code := n.Code
+			if n.Block != nil {
+				code = n.Block.Code
+			}
+			for _, field := range strings.Fields(code) {
+				found = found || field == "yield" || field == "sleep"
+			}
+		}
+		return !found
+	})
+
+	return found
+}
+
+// checkDeclarations warns about unused functions, unused and shadowing local variables and parameters
+func (fr *Frontend) checkDeclarations(ast *parser.AST) {
+	globals := map[string]lexer.Position{}
+	called := map[string]bool{}
+	for _, top := range ast.TopDec {
+		if top.VarDec != nil {
+			globals[varDecName(top.VarDec)] = top.VarDec.Pos
+		}
+		if top.EnumDec != nil {
+			for _, value := range top.EnumDec.Values {
+				globals[value.Name] = value.Pos
+			}
+		}
+	}
+	parser.Walk(ast, func(node any) bool {
+		if c, ok := node.(*parser.CallFunc); ok {
+			called[c.Ident] = true
+		}
+		return true
+	})
+
+	for _, top := range ast.TopDec {
+		f := top.FunDec
+		if f == nil || f.FunBody == nil {
+			continue
+		}
+		if f.Name != "main" && !called[f.Name] {
+			fr.diags.Warn(&diagnostic.Diagnostic{
+				Code:  WarnUnusedFunction,
+				Pos:   f.Pos,
+				End:   f.EndPos,
+				Err:   fmt.Errorf("%w: %s", ErrUnusedFunction, f.Name),
+				Fixes: []diagnostic.Fix{{Description: "remove the function", Pos: f.Pos, End: f.EndPos}},
+			})
+		}
+
+		used := referencedNames(f.FunBody.Stmts)
+		params := map[string]bool{}
+		for _, param := range f.Parameters {
+			params[param.Scalar.Name] = true
+			if global, found := globals[param.Scalar.Name]; found {
+				fr.warnShadow(param.Pos, fmt.Sprintf("parameter %s shadows a global", param.Scalar.Name), global)
+			}
+			if !used[param.Scalar.Name] {
+				fr.warn(WarnUnusedParameter, param.Pos, fmt.Errorf("%w: %s", ErrUnusedParameter, param.Scalar.Name))
+			}
+		}
+
+		for _, local := range f.FunBody.Locals {
+			name := varDecName(local)
+			if params[name] {
+				fr.warn(WarnShadow, local.Pos, fmt.Errorf("%w: local %s shadows a parameter", ErrShadow, name))
+			} else if global, found := globals[name]; found {
+				fr.warnShadow(local.Pos, fmt.Sprintf("local %s shadows a global", name), global)
+			}
+			if !used[name] {
+				fr.warn(WarnUnusedVariable, local.Pos, fmt.Errorf("%w: %s", ErrUnusedVariable, name))
+			}
+		}
+	}
+}
+
+func (fr *Frontend) warnShadow(pos lexer.Position, message string, global lexer.Position) {
+	fr.diags.Warn(&diagnostic.Diagnostic{
+		Code:  WarnShadow,
+		Pos:   pos,
+		Err:   fmt.Errorf("%w: %s", ErrShadow, message),
+		Notes: []diagnostic.Note{{Pos: global, Message: "global declared here"}},
+	})
+}
+
+func varDecName(v *parser.VarDec) string {
+	switch {
+	case v.ConstDec != nil:
+		return v.ConstDec.Name
+	case v.ArrayDec != nil:
+		return v.ArrayDec.Name
+	case v.ScalarDec != nil:
+		return v.ScalarDec.Name
+	}
+
+	return ""
+}
+
+// referencedNames returns the names of all variables and constants a node reads or writes
+func referencedNames(node any) map[string]bool {
+	names := map[string]bool{}
+	parser.Walk(node, func(node any) bool {
+		switch n := node.(type) {
+		case *parser.Primary:
+			names[n.Ident] = true
+		case *parser.ArrayIndex:
+			names[n.Ident] = true
+		case *parser.LValue:
+			names[n.Ident] = true
+		case *parser.AsmStmt:
+			for _, operand := range n.Operands {
+				names[operand] = true
+			}
+		case *parser.AsmBlock:
+			for _, operand := range n.Operands {
+				names[operand] = true
+			}
+		}
+		return true
+	})
+
+	return names
+}
+
+// checkUnusedAssignments warns about values assigned to variables that are never read afterwards
+func (fr *Frontend) checkUnusedAssignments() {
+	instructions := fr.program.Get()
+	live, err := liveOut(instructions)
+	if err != nil {
+		fr.report(lexer.Position{}, err)
+		return
+	}
+	for i, instr := range instructions {
+		assign, ok := instr.(IRAssignVar)
+		if !ok || !fr.named[assign.Assignee] || live[i][assign.Assignee] {
+			continue
+		}
+
+		fr.warn(WarnUnusedAssignment, fr.program.Pos(i), fmt.Errorf("%w: %s", ErrUnusedAssignment, assign.Assignee))
+	}
+}
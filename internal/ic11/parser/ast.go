@@ -3,6 +3,11 @@
 package parser
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 )
@@ -11,14 +16,17 @@ var (
 	lex = lexer.MustSimple([]lexer.SimpleRule{
 		{Name: "comment", Pattern: `//.*|/\*.*?\*/`},
 		{Name: "whitespace", Pattern: `\s+`},
-		{Name: "Define", Pattern: "#define"},
+		{Name: "AsmBlock", Pattern: `\basm\s*(\([^()]*\))?\s*\{[^}]*\}`},
 		{Name: "Type", Pattern: `\b(int|float|string)\b`},
-		{Name: "Device", Pattern: "d([0-6]|b)(:[0-9])?"},
+		{Name: "Device", Pattern: `\bd([0-6]|b)(:[0-9])?\b`},
 		{Name: "Ident", Pattern: `\b([a-zA-Z_][a-zA-Z0-9_]*)\b`},
-		{Name: "Punct", Pattern: `[-,()*/+%{};&\|!=:<>]|\[|\]`},
+		{Name: "IncDec", Pattern: `\+\+|--`},
+		// Operators of more than one character are single tokens, so that "a < = b" is not read as "a <= b"
+		{Name: "Operator", Pattern: `>>>|<<|>>|<=|>=|==|!=|&&|\|\||[-+*/%]=`},
+		{Name: "Punct", Pattern: `[-,()*/+%{};&\|!=:<>^~?]|\[|\]`},
 		{Name: "QuotedStr", Pattern: `"(.*?)"`},
-		{Name: "Float", Pattern: `\d+(?:\.\d+)?`},
-		{Name: "Int", Pattern: `\d+`},
+		{Name: "Float", Pattern: `(\d[\d_]*)?\.\d[\d_]*([eE][-+]?\d+)?|\d[\d_]*[eE][-+]?\d+`},
+		{Name: "Int", Pattern: `0[xX][\da-fA-F_]+|0[bB][01_]+|\d[\d_]*`},
 	})
 
 	// Build basicParser
@@ -26,13 +34,6 @@ var (
 		participle.Unquote("QuotedStr"),
 		participle.Lexer(lex),
 		participle.UseLookahead(600))
-	mappingParser = func(mapFunc participle.Mapper) *participle.Parser[AST] {
-		return participle.MustBuild[AST](
-			participle.Unquote("QuotedStr"),
-			participle.Map(mapFunc),
-			participle.Lexer(lex),
-			participle.UseLookahead(600))
-	}
 )
 
 // https://www.it.uu.se/katalog/aleji304/CompilersProject/uc.html
@@ -63,28 +64,57 @@ func mergeAST(p1, p2 *AST) *AST {
 type TopDec struct {
 	Pos lexer.Position
 
-	FunDec    *FunDec    `  @@`
-	DefineDec *DefineDec `| @@`
-	VarDec    *VarDec    `| @@ ";"`
+	FunDec  *FunDec  `  @@`
+	EnumDec *EnumDec `| @@ ";"`
+	VarDec  *VarDec  `| @@ ";"`
 }
 
-type DefineDec struct {
-	Pos    lexer.Position
-	Name   string   `Define @Ident`
-	Device string   `@Device`
-	Value  *Literal `| @@`
+// EnumDec declares an enumeration. Values without an explicit value are numbered from the previous one.
+type EnumDec struct {
+	Pos lexer.Position
+
+	Name   string       `"enum" @Ident`
+	Values []*EnumValue `"{" (@@ ("," @@)* ","?)? "}"`
+}
+
+type EnumValue struct {
+	Pos lexer.Position
+
+	Name  string `@Ident`
+	Value *Expr  `("=" @@)?`
 }
 
 type VarDec struct {
 	Pos lexer.Position
 
-	ScalarDec ScalarDec `@@`
+	ConstDec  *ConstDec  `  @@`
+	ArrayDec  *ArrayDec  `| @@`
+	ScalarDec *ScalarDec `| @@`
 }
 
-type ScalarDec struct {
+type ConstDec struct {
+	Pos lexer.Position
+
+	Type  string `"const" @Type`
+	Name  string `@Ident "="`
+	Value *Expr  `@@`
+}
+
+type ArrayDec struct {
 	Pos lexer.Position
 
 	Name string `Type @Ident`
+	Size *Expr  `"[" @@ "]"`
+}
+
+type ScalarDec struct {
+	Pos lexer.Position
+
+	// Volatile variables always get values freshly loaded from devices, see Frontend.volatile
+	Volatile bool `@"volatile"?`
+	// Type is either a builtin type or a name of an enum
+	Type string `( @Type | "enum" @Ident )`
+	Name string `@Ident`
 }
 
 type ReturnStmt struct {
@@ -108,6 +138,46 @@ type IfStmt struct {
 	Else      *Stmt `("else" @@)?`
 }
 
+// AsmStmt is an inline IC10 assembly statement, either asm(a, b) { ... } or asm("...", a, b).
+// Operands are bound to %0, %1, ... in the code.
+type AsmStmt struct {
+	Pos lexer.Position
+
+	Block    *AsmBlock `  @AsmBlock`
+	Code     string    `| "asm" "(" @QuotedStr`
+	Operands []string  `  ("," @Ident)* ")"`
+}
+
+// AsmBlock is an inline assembly block. The lexer captures it as a single token, so that the code is kept verbatim.
+type AsmBlock struct {
+	Operands []string
+	Code     string
+}
+
+var identRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (a *AsmBlock) Capture(values []string) error {
+	s := strings.TrimSpace(strings.TrimPrefix(strings.Join(values, ""), "asm"))
+	if strings.HasPrefix(s, "(") {
+		end := strings.Index(s, ")")
+		operands := strings.TrimSpace(s[1:end])
+		for _, operand := range strings.Split(operands, ",") {
+			if operands == "" {
+				break
+			}
+			operand = strings.TrimSpace(operand)
+			if !identRegexp.MatchString(operand) {
+				return fmt.Errorf("invalid asm operand %q", operand)
+			}
+			a.Operands = append(a.Operands, operand)
+		}
+		s = strings.TrimSpace(s[end+1:])
+	}
+
+	a.Code = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	return nil
+}
+
 type Stmts struct {
 	Pos lexer.Position
 
@@ -120,6 +190,7 @@ type Stmt struct {
 	IfStmt     *IfStmt     `  @@`
 	ReturnStmt *ReturnStmt `| @@`
 	WhileStmt  *WhileStmt  `| @@`
+	AsmStmt    *AsmStmt    `| @@`
 	Assignment *Assignment `| @@`
 	CallFunc   *CallFunc   `| @@`
 	Expr       *Expr       `| @@`
@@ -135,7 +206,8 @@ type FunBody struct {
 }
 
 type FunDec struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	ReturnType string       `@(Type | "void")`
 	Name       string       `@Ident`
@@ -152,46 +224,150 @@ type Parameter struct {
 type Assignment struct {
 	Pos lexer.Position
 
-	Left  string `@Ident "="`
-	Right *Expr  `@@`
+	Left  *LValue `@@`
+	Op    string  `@( "=" | "+=" | "-=" | "*=" | "/=" | "%=" )`
+	Right *Expr   `@@`
+}
+
+type LValue struct {
+	Pos lexer.Position
+
+	Ident string `@Ident`
+	Index *Expr  `("[" @@ "]")?`
+}
+
+type PrefixIncDec struct {
+	Pos lexer.Position
+
+	Op    string  `@IncDec`
+	Right *LValue `@@`
+}
+
+type PostfixIncDec struct {
+	Pos lexer.Position
+
+	Left *LValue `@@`
+	Op   string  `@IncDec`
 }
 
 type Expr struct {
 	Pos lexer.Position
 
-	Binary  *Binary  ` @@`
-	Primary *Primary `| @@`
-	Unary   *Unary   `| @@`
+	Assignment *Assignment `  @@`
+	Chain      *OpChain    `| @@`
+	Then       *Expr       `  ("?" @@`
+	Else       *Expr       `   ":" @@)?`
+
+	// Expression tree built from Chain after parsing, only one of these is set
+	Ternary       *Ternary
+	Binary        *Binary
+	Unary         *Unary
+	PrefixIncDec  *PrefixIncDec
+	PostfixIncDec *PostfixIncDec
+	Primary       *Primary
+}
+
+// OpChain is a sequence of operands separated by binary operators, as it appears in the source.
+// Operator precedence is applied when the chain is folded into an expression tree.
+type OpChain struct {
+	Pos lexer.Position
+
+	Head *Operand     `@@`
+	Tail []*OpOperand `@@*`
+}
+
+type OpOperand struct {
+	Pos lexer.Position
+
+	Op      string   `@( "||" | "&&" | "|" | "&" | "^" | "==" | "!=" | "<<" | ">>>" | ">>" | "<=" | ">=" | "<" | ">" | "+" | "-" | "*" | "/" | "%" )`
+	Operand *Operand `@@`
+}
+
+type Operand struct {
+	Pos lexer.Position
+
+	PrefixIncDec  *PrefixIncDec  `  @@`
+	Unary         *Unary         `| @@`
+	PostfixIncDec *PostfixIncDec `| @@`
+	Primary       *Primary       `| @@`
+}
+
+type Ternary struct {
+	Pos lexer.Position
+
+	Cond *Expr
+	Then *Expr
+	Else *Expr
 }
 
 type Binary struct {
 	Pos lexer.Position
 
-	LHS *Primary `@@`
-	Op  string   `@( "|" "|" | "&" "&" | "!" "=" | ("!"|"<"|">") "="? | "=" "=" | "+" | "-" | "/" | "*" )`
-	RHS *Primary `@@`
+	LHS *Expr
+	Op  string
+	RHS *Expr
 }
 
 type Unary struct {
 	Pos lexer.Position
 
-	Op  string   `@( "-" | "!" )`
-	RHS *Primary `@@`
+	Op      string   `@( "-" | "!" | "~" )`
+	Operand *Operand `@@`
+
+	// RHS is built from Operand after parsing, Operand is cleared then
+	RHS *Expr
 }
 
 type Primary struct {
 	Pos lexer.Position
 
-	CallFunc      *CallFunc `  @@`
-	Literal       *Literal  `| @@`
-	Ident         string    `| @Ident`
-	SubExpression *Expr     `| "(" @@ ")" `
+	CallFunc      *CallFunc   `  @@`
+	ArrayIndex    *ArrayIndex `| @@`
+	Literal       *Literal    `| @@`
+	Ident         string      `| @(Ident | Device)`
+	SubExpression *Expr       `| "(" @@ ")" `
 }
 
+type ArrayIndex struct {
+	Pos lexer.Position
+
+	Ident string `@Ident`
+	Index *Expr  `"[" @@ "]"`
+}
+
+// Literal is a number or a string literal.
+// Negative numbers are parsed as unary minus applied to a literal, and folded at compile time.
 type Literal struct {
-	Int    *int64   `  @('-'? Int)`
-	Float  *float64 `| @('-'? Float)`
-	String *string  `| @QuotedStr`
+	Int    *IntValue `  @Int`
+	Float  *float64  `| @Float`
+	String *string   `| @QuotedStr`
+}
+
+// IntValue is an integer literal in decimal, hexadecimal (0x) or binary (0b) notation.
+// Digits can be separated by underscores.
+type IntValue int64
+
+func (i *IntValue) Capture(values []string) error {
+	s := strings.ReplaceAll(strings.Join(values, ""), "_", "")
+	base := 10
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+			s = s[2:]
+		case 'b', 'B':
+			base = 2
+			s = s[2:]
+		}
+	}
+
+	v, err := strconv.ParseInt(s, base, 64)
+	if err != nil {
+		return err
+	}
+
+	*i = IntValue(v)
+	return nil
 }
 
 // Special function types
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"reflect"
+)
+
+// binaryPrecedence maps binary operators to their precedence. Higher binds tighter, as in C.
+var binaryPrecedence = map[string]int{
+	"||":  1,
+	"&&":  2,
+	"|":   3,
+	"^":   4,
+	"&":   5,
+	"==":  6,
+	"!=":  6,
+	"<":   7,
+	"<=":  7,
+	">":   7,
+	">=":  7,
+	"<<":  8,
+	">>":  8,
+	">>>": 8,
+	"+":   9,
+	"-":   9,
+	"*":   10,
+	"/":   10,
+	"%":   10,
+}
+
+// foldExprs builds expression trees for every expression in the AST.
+// It walks the grammar fields of the nodes (the ones with struct tags) using reflection,
+// so that new node types don't have to be registered anywhere.
+func foldExprs(node any) {
+	foldValue(reflect.ValueOf(node))
+}
+
+var exprType = reflect.TypeOf(&Expr{})
+
+func foldValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		// Nested expressions are folded first, as folding discards the chain
+		foldValue(v.Elem())
+		if v.Type() == exprType {
+			v.Interface().(*Expr).fold()
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			foldValue(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			// Untagged fields are populated by folding and point to the nodes reachable from the tagged ones
+			if v.Type().Field(i).Tag == "" {
+				continue
+			}
+			foldValue(v.Field(i))
+		}
+	}
+}
+
+// fold populates the expression tree fields from the chain of operands
+func (e *Expr) fold() {
+	if e.Chain == nil {
+		return
+	}
+
+	operands := []*Expr{operandExpr(e.Chain.Head)}
+	ops := []*OpOperand{}
+	for _, tail := range e.Chain.Tail {
+		operands = append(operands, operandExpr(tail.Operand))
+		ops = append(ops, tail)
+	}
+
+	c := &chainFolder{operands: operands, ops: ops}
+	cond := c.climb(0)
+	if e.Then == nil {
+		*e = *cond
+		return
+	}
+
+	// The chain is the condition of a ternary expression
+	*e = Expr{
+		Pos:     e.Pos,
+		Ternary: &Ternary{Pos: e.Pos, Cond: cond, Then: e.Then, Else: e.Else},
+	}
+}
+
+// chainFolder builds a tree from the operands and operators of a chain using precedence climbing
+type chainFolder struct {
+	operands []*Expr
+	ops      []*OpOperand
+	pos      int
+}
+
+func (c *chainFolder) climb(minPrecedence int) *Expr {
+	lhs := c.operands[c.pos]
+	for c.pos < len(c.ops) {
+		op := c.ops[c.pos]
+		precedence := binaryPrecedence[op.Op]
+		if precedence < minPrecedence {
+			break
+		}
+
+		c.pos++
+		// All binary operators are left associative, so the right side only takes tighter operators
+		rhs := c.climb(precedence + 1)
+		lhs = &Expr{
+			Pos:    lhs.Pos,
+			Binary: &Binary{Pos: op.Pos, LHS: lhs, Op: op.Op, RHS: rhs},
+		}
+	}
+
+	return lhs
+}
+
+// operandExpr converts an operand to an expression
+func operandExpr(o *Operand) *Expr {
+	e := &Expr{Pos: o.Pos}
+	switch {
+	case o.PrefixIncDec != nil:
+		e.PrefixIncDec = o.PrefixIncDec
+	case o.Unary != nil:
+		o.Unary.RHS = operandExpr(o.Unary.Operand)
+		// The operand is only reachable through the tree from now on
+		o.Unary.Operand = nil
+		e.Unary = o.Unary
+	case o.PostfixIncDec != nil:
+		e.PostfixIncDec = o.PostfixIncDec
+	default:
+		e.Primary = o.Primary
+	}
+
+	return e
+}
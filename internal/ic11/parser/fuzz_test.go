@@ -0,0 +1,25 @@
+package parser
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/preprocessor"
+	"github.com/greg2010/ic11c/internal/testutil"
+)
+
+func FuzzParse(f *testing.F) {
+	testutil.AddSeeds(f, "../compiler/testdata/*.uc")
+	f.Fuzz(func(t *testing.T, src string) {
+		diags := diagnostic.NewCollector(diagnostic.Options{})
+		ast, err := Parse([]io.Reader{strings.NewReader(src)}, preprocessor.Config{}, diags)
+		if err == nil && diags.HasErrors() {
+			t.Errorf("errors were reported, but Parse succeeded")
+		}
+		if ast != nil {
+			Walk(ast, func(node any) bool { return true })
+		}
+	})
+}
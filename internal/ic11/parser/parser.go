@@ -1,98 +1,182 @@
 package parser
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"strings"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/greg2010/ic11c/internal/ic11/preprocessor"
 )
 
-func Parse(files []io.Reader) (*AST, error) {
-	readFiles := []string{}
+// maxSyntaxErrors limits the number of syntax errors reported for a single unit
+const maxSyntaxErrors = 100
+
+// Parse preprocesses and parses files, merging them into a single AST.
+// Files that are included by other files are parsed as separate units ahead of the including file.
+// Errors are reported to diags. After a syntax error parsing resumes at the next statement,
+// and the returned AST contains everything that could be parsed, so that it can be checked further.
+func Parse(files []io.Reader, conf preprocessor.Config, diags *diagnostic.Collector) (*AST, error) {
+	pp, err := preprocessor.New(conf)
+	if err != nil {
+		diags.Report(lexer.Position{}, err)
+		return nil, diags.Err()
+	}
+
+	units := []preprocessor.Unit{}
 	for _, file := range files {
-		bytes, err := io.ReadAll(file)
+		name := sourceName(file)
+		processed, err := pp.Process(name, file)
 		if err != nil {
-			return nil, err
+			diags.Report(lexer.Position{Filename: name}, err)
+			continue
 		}
-		readFiles = append(readFiles, (string(bytes)))
+		units = append(units, processed...)
 	}
 
-	initAST, err := parse(readersFromStrings(readFiles), basicParser)
-	if err != nil {
-		return nil, err
+	return parse(units, basicParser, diags), diags.Err()
+}
+
+func parse(units []preprocessor.Unit, parser *participle.Parser[AST], diags *diagnostic.Collector) *AST {
+	var ast *AST
+	for _, unit := range units {
+		astSoFar := parseUnit(unit, parser, diags)
+		if astSoFar == nil {
+			continue
+		}
+		foldExprs(astSoFar)
+		ast = mergeAST(ast, astSoFar)
 	}
 
-	defMap := genPreprocessorMapping(initAST)
-	mapFunc := mappingFunc(defMap)
+	return ast
+}
 
-	retAST, err := parse(readersFromStrings(readFiles), mappingParser(mapFunc))
-	if err != nil {
-		return nil, err
+// parseUnit parses a single unit. On a syntax error the offending statement is blanked out and the unit is parsed again.
+func parseUnit(unit preprocessor.Unit, parser *participle.Parser[AST], diags *diagnostic.Collector) *AST {
+	diags.AddSource(unit.Name, unit.Source)
+	diags.MapPositions(unit.Name, unit.Original)
+
+	text := unit.Text
+	for i := 0; i < maxSyntaxErrors; i++ {
+		ast, err := parser.Parse(unit.Name, strings.NewReader(text))
+		if err == nil {
+			return ast
+		}
+
+		var perr participle.Error
+		if !errors.As(err, &perr) {
+			diags.Report(lexer.Position{Filename: unit.Name}, err)
+			return nil
+		}
+		d := &diagnostic.Diagnostic{Pos: perr.Position(), End: perr.Position(), Err: errors.New(perr.Message())}
+		var unexpected *participle.UnexpectedTokenError
+		if errors.As(err, &unexpected) {
+			d.End.Column += len(unexpected.Unexpected.Value)
+			d.End.Offset += len(unexpected.Unexpected.Value)
+		}
+		diags.Report(d.Pos, d)
+
+		recovered, ok := skipStatement(text, perr.Position().Offset)
+		if !ok {
+			return nil
+		}
+		text = recovered
 	}
 
-	return retAST, nil
+	return nil
 }
 
-// mappingFunc returns a token -> token mapping function based on a go map
-func mappingFunc(m map[string]string) func(lexer.Token) (lexer.Token, error) {
-	return func(t lexer.Token) (lexer.Token, error) {
-		if replaceVal, found := m[t.String()]; found {
-			return lexer.Token{Type: t.Type, Pos: t.Pos, Value: replaceVal}, nil
+// skipStatement replaces the statement containing offset with whitespace, keeping line breaks so that positions don't change.
+// Statements end with ';' or a block in braces. Returns false if nothing could be skipped.
+func skipStatement(text string, offset int) (string, bool) {
+	boundaries := statementBoundaries(text)
+
+	start := 0
+	for _, b := range boundaries {
+		if b >= offset {
+			break
 		}
-		return t, nil
+		start = b + 1
 	}
-}
 
-// genPreprocessorMapping generates a preprocessor mapping from #define statements in AST
-func genPreprocessorMapping(ast *AST) map[string]string {
-	defMap := make(map[string]string)
-	for _, top := range ast.TopDec {
-		if top.DefineDec != nil {
-			if top.DefineDec.Device != "" {
-				defMap[top.DefineDec.Name] = top.DefineDec.Device
-				continue
+	end := len(text)
+	depth := 0
+scan:
+	for _, b := range boundaries {
+		if b < start {
+			continue
+		}
+		switch text[b] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				end = b
+				break scan
 			}
-
-			if top.DefineDec.Value != nil {
-				if top.DefineDec.Value.String != nil {
-					defMap[top.DefineDec.Name] = *top.DefineDec.Value.String
-					continue
-				}
-				if top.DefineDec.Value.Int != nil {
-					defMap[top.DefineDec.Name] = fmt.Sprintf("%d", *top.DefineDec.Value.Int)
-					continue
-				}
-				if top.DefineDec.Value.Float != nil {
-					defMap[top.DefineDec.Name] = fmt.Sprintf("%f", *top.DefineDec.Value.Float)
-					continue
-				}
+			depth--
+			if depth == 0 {
+				end = b + 1
+				break scan
 			}
+		case ';':
+			if depth == 0 {
+				end = b + 1
+				break scan
+			}
+		}
+	}
+
+	// The error is at the boundary itself, e.g. an unmatched brace
+	if strings.TrimSpace(text[start:end]) == "" {
+		if offset >= len(text) {
+			return "", false
 		}
+		start, end = offset, offset+1
 	}
 
-	return defMap
+	blank := []byte(text)
+	for i := start; i < end; i++ {
+		if blank[i] != '\n' {
+			blank[i] = ' '
+		}
+	}
+
+	return string(blank), true
 }
 
-func parse(files []io.Reader, parser *participle.Parser[AST]) (*AST, error) {
-	var ast *AST
-	for _, reader := range files {
-		astSoFar, err := parser.Parse("", reader)
-		if err != nil {
-			return nil, err
+// statementBoundaries returns offsets of ';', '{' and '}' that are not in comments or strings
+func statementBoundaries(text string) []int {
+	boundaries := []int{}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "//"):
+			i += strings.IndexByte(text[i:]+"\n", '\n')
+		case strings.HasPrefix(text[i:], "/*"):
+			if end := strings.Index(text[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(text)
+			}
+		case text[i] == '"':
+			if end := strings.IndexByte(text[i+1:], '"'); end >= 0 {
+				i += end + 1
+			}
+		case text[i] == ';', text[i] == '{', text[i] == '}':
+			boundaries = append(boundaries, i)
 		}
-		ast = mergeAST(ast, astSoFar)
 	}
 
-	return ast, nil
+	return boundaries
 }
 
-func readersFromStrings(s []string) []io.Reader {
-	readers := []io.Reader{}
-	for _, str := range s {
-		readers = append(readers, strings.NewReader(str))
+// sourceName returns the file name of the reader if it is known (e.g. it's an *os.File)
+func sourceName(r io.Reader) string {
+	if named, ok := r.(interface{ Name() string }); ok {
+		return named.Name()
 	}
 
-	return readers
+	return ""
 }
@@ -0,0 +1,32 @@
+package parser
+
+import "reflect"
+
+// Walk calls visit for every node reachable from node, parents before children.
+// Expressions are walked as trees, i.e. after folding. Children of a node are skipped if visit returns false.
+func Walk(node any, visit func(node any) bool) {
+	walkValue(reflect.ValueOf(node), visit)
+}
+
+func walkValue(v reflect.Value, visit func(node any) bool) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if v.Elem().Kind() == reflect.Struct && !visit(v.Interface()) {
+			return
+		}
+		walkValue(v.Elem(), visit)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkValue(v.Index(i), visit)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walkValue(v.Field(i), visit)
+			}
+		}
+	}
+}
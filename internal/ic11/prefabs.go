@@ -0,0 +1,59 @@
+package ic11
+
+// prefabNames are the names of common prefabs, so that their hashes can be shown by name
+var prefabNames = []string{
+	"StructureActiveVent",
+	"StructureAdvancedFurnace",
+	"StructureAirConditioner",
+	"StructureArcFurnace",
+	"StructureAreaPowerControl",
+	"StructureAutolathe",
+	"StructureBackPressureRegulator",
+	"StructureBattery",
+	"StructureBatteryLarge",
+	"StructureCentrifuge",
+	"StructureConsole",
+	"StructureDaylightSensor",
+	"StructureDigitalValve",
+	"StructureElectrolyzer",
+	"StructureFiltration",
+	"StructureFurnace",
+	"StructureGasSensor",
+	"StructureGrowLight",
+	"StructureHydroponicsTray",
+	"StructureLiquidVolumePump",
+	"StructureLogicMemory",
+	"StructureMotionSensor",
+	"StructureOccupancySensor",
+	"StructurePassiveVent",
+	"StructurePipeAnalysizer",
+	"StructurePressureRegulator",
+	"StructureSolarPanel",
+	"StructureSolarPanelDual",
+	"StructureSorter",
+	"StructureStacker",
+	"StructureTankBig",
+	"StructureTankSmall",
+	"StructureTransformer",
+	"StructureVolumePump",
+	"StructureWallCooler",
+	"StructureWallHeater",
+	"StructureWallLight",
+}
+
+var prefabHashes = computePrefabHashes()
+
+func computePrefabHashes() map[int]string {
+	hashes := make(map[int]string)
+	for _, name := range prefabNames {
+		hashes[ComputeHash(name)] = name
+	}
+
+	return hashes
+}
+
+// PrefabName returns the name of a known prefab with the hash
+func PrefabName(hash int) (string, bool) {
+	name, found := prefabHashes[hash]
+	return name, found
+}
@@ -0,0 +1,283 @@
+package preprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// evalCondition evaluates the controlling expression of #if and #elif
+func (pp *Preprocessor) evalCondition(tokens []token) (bool, error) {
+	// defined operators are resolved before macro expansion
+	resolved := []token{}
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].is(tokenIdent, "defined") {
+			resolved = append(resolved, tokens[i])
+			continue
+		}
+
+		j := skipBlank(tokens, i+1)
+		parens := j < len(tokens) && tokens[j].is(tokenPunct, "(")
+		if parens {
+			j = skipBlank(tokens, j+1)
+		}
+		if j >= len(tokens) || tokens[j].kind != tokenIdent {
+			return false, fmt.Errorf("%w: macro name expected after defined", ErrInvalidExpression)
+		}
+		name := tokens[j].text
+		if parens {
+			j = skipBlank(tokens, j+1)
+			if j >= len(tokens) || !tokens[j].is(tokenPunct, ")") {
+				return false, fmt.Errorf("%w: missing ')' after defined", ErrInvalidExpression)
+			}
+		}
+
+		value := "0"
+		if _, found := pp.macros[name]; found {
+			value = "1"
+		}
+		resolved = append(resolved, token{tokenNumber, value, -1, true})
+		i = j
+	}
+
+	expanded, err := pp.expand(resolved, map[string]bool{})
+	if err != nil {
+		return false, err
+	}
+
+	e := &exprParser{}
+	for _, t := range expanded {
+		if !t.isBlank() {
+			e.tokens = append(e.tokens, t)
+		}
+	}
+	if len(e.tokens) == 0 {
+		return false, fmt.Errorf("%w: empty expression", ErrInvalidExpression)
+	}
+
+	v, err := e.parseTernary()
+	if err != nil {
+		return false, err
+	}
+	if e.pos < len(e.tokens) {
+		return false, fmt.Errorf("%w: unexpected %q", ErrInvalidExpression, e.tokens[e.pos].text)
+	}
+
+	return v != 0, nil
+}
+
+// exprParser is a precedence climbing parser for preprocessor integer expressions
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+// binaryPrecedence lists binary operators from the lowest to the highest precedence
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (e *exprParser) peek() (token, bool) {
+	if e.pos >= len(e.tokens) {
+		return token{}, false
+	}
+
+	return e.tokens[e.pos], true
+}
+
+func (e *exprParser) accept(ops ...string) (string, bool) {
+	t, ok := e.peek()
+	if !ok || t.kind != tokenPunct {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			e.pos++
+			return op, true
+		}
+	}
+
+	return "", false
+}
+
+func (e *exprParser) parseTernary() (int64, error) {
+	cond, err := e.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := e.accept("?"); !ok {
+		return cond, nil
+	}
+
+	l, err := e.parseTernary()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := e.accept(":"); !ok {
+		return 0, fmt.Errorf("%w: expected ':'", ErrInvalidExpression)
+	}
+	r, err := e.parseTernary()
+	if err != nil {
+		return 0, err
+	}
+
+	if cond != 0 {
+		return l, nil
+	}
+	return r, nil
+}
+
+func (e *exprParser) parseBinary(level int) (int64, error) {
+	if level == len(binaryPrecedence) {
+		return e.parseUnary()
+	}
+
+	l, err := e.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := e.accept(binaryPrecedence[level]...)
+		if !ok {
+			return l, nil
+		}
+		r, err := e.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		l, err = applyBinary(op, l, r)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (e *exprParser) parseUnary() (int64, error) {
+	if op, ok := e.accept("!", "-", "+", "~"); ok {
+		v, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "!":
+			return boolToInt(v == 0), nil
+		case "-":
+			return -v, nil
+		case "~":
+			return ^v, nil
+		default:
+			return v, nil
+		}
+	}
+
+	return e.parsePrimary()
+}
+
+func (e *exprParser) parsePrimary() (int64, error) {
+	t, ok := e.peek()
+	if !ok {
+		return 0, fmt.Errorf("%w: unexpected end of expression", ErrInvalidExpression)
+	}
+
+	if _, ok := e.accept("("); ok {
+		v, err := e.parseTernary()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := e.accept(")"); !ok {
+			return 0, fmt.Errorf("%w: expected ')'", ErrInvalidExpression)
+		}
+		return v, nil
+	}
+
+	e.pos++
+	switch t.kind {
+	case tokenNumber:
+		return parseNumber(t.text)
+	case tokenIdent:
+		// Identifiers that are not macros evaluate to 0
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%w: unexpected %q", ErrInvalidExpression, t.text)
+	}
+}
+
+// parseNumber parses a number the same way as the compiler does: numbers with leading zeros are decimal, not octal
+func parseNumber(s string) (int64, error) {
+	digits := strings.ReplaceAll(s, "_", "")
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' && strings.ContainsRune("xXbB", rune(digits[1])) {
+		base = 0
+	}
+	if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(digits, 64); err == nil {
+		return int64(f), nil
+	}
+
+	return 0, fmt.Errorf("%w: invalid number %q", ErrInvalidExpression, s)
+}
+
+func applyBinary(op string, l, r int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolToInt(l != 0 || r != 0), nil
+	case "&&":
+		return boolToInt(l != 0 && r != 0), nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "==":
+		return boolToInt(l == r), nil
+	case "!=":
+		return boolToInt(l != r), nil
+	case "<":
+		return boolToInt(l < r), nil
+	case "<=":
+		return boolToInt(l <= r), nil
+	case ">":
+		return boolToInt(l > r), nil
+	case ">=":
+		return boolToInt(l >= r), nil
+	case "<<":
+		return l << uint64(r), nil
+	case ">>":
+		return l >> uint64(r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("%w: division by zero", ErrInvalidExpression)
+		}
+		if op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown operator %q", ErrInvalidExpression, op)
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
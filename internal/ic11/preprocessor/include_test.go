@@ -0,0 +1,168 @@
+package preprocessor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// processFiles writes files to a temporary directory and preprocesses main.uc, with include paths relative to the
+// directory. Units are returned as "name: text", with names relative to the directory.
+func processFiles(t *testing.T, files map[string]string, includePaths []string) ([]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := Config{}
+	for _, path := range includePaths {
+		conf.IncludePaths = append(conf.IncludePaths, filepath.Join(dir, path))
+	}
+	pp, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.uc")
+	units, err := pp.Process(main, strings.NewReader(files["main.uc"]))
+	if err != nil {
+		return nil, err
+	}
+
+	got := []string{}
+	for _, unit := range units {
+		name, err := filepath.Rel(dir, unit.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(name)+": "+unit.Text)
+	}
+
+	return got, nil
+}
+
+func TestInclude(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        map[string]string
+		includePaths []string
+		want         []string
+		err          error
+	}{
+		{
+			name: "relative to the including file",
+			files: map[string]string{
+				"main.uc": "#include \"lib/a.h\"\nmain",
+				"lib/a.h": "#include \"b.h\"\na",
+				"lib/b.h": "b",
+				"b.h":     "wrong",
+			},
+			want: []string{"lib/b.h: b", "lib/a.h: \na", "main.uc: \nmain"},
+		},
+		{
+			name: "quoted searches the including directory first",
+			files: map[string]string{
+				"main.uc":  "#include \"v.h\"",
+				"v.h":      "local",
+				"inc1/v.h": "inc1",
+				"inc2/v.h": "inc2",
+			},
+			includePaths: []string{"inc1", "inc2"},
+			want:         []string{"v.h: local", "main.uc: "},
+		},
+		{
+			name: "quoted falls back to the include paths",
+			files: map[string]string{
+				"main.uc":  "#include \"v.h\"",
+				"inc1/v.h": "inc1",
+			},
+			includePaths: []string{"inc1"},
+			want:         []string{"inc1/v.h: inc1", "main.uc: "},
+		},
+		{
+			name: "include paths in order",
+			files: map[string]string{
+				"main.uc":  "#include <v.h>",
+				"v.h":      "local",
+				"inc1/v.h": "inc1",
+				"inc2/v.h": "inc2",
+			},
+			includePaths: []string{"inc2", "inc1"},
+			want:         []string{"inc2/v.h: inc2", "main.uc: "},
+		},
+		{
+			name: "angle brackets skip the including directory",
+			files: map[string]string{
+				"main.uc": "#include <v.h>",
+				"v.h":     "local",
+			},
+			err: ErrIncludeNotFound,
+		},
+		{
+			name: "file name from a macro",
+			files: map[string]string{
+				"main.uc": "#define LIB \"v.h\"\n#include LIB",
+				"v.h":     "v",
+			},
+			want: []string{"v.h: v", "main.uc: \n"},
+		},
+		{
+			name: "included once",
+			files: map[string]string{
+				"main.uc": "#include \"a.h\"\n#include \"b.h\"\n#include \"a.h\"",
+				"a.h":     "a",
+				"b.h":     "#include \"a.h\"\nb",
+			},
+			want: []string{"a.h: a", "b.h: \nb", "main.uc: \n\n"},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.uc": "#include \"a.h\"",
+				"a.h":     "#include \"b.h\"",
+				"b.h":     "#include \"a.h\"",
+			},
+			err: ErrIncludeCycle,
+		},
+		{
+			name: "self include",
+			files: map[string]string{
+				"main.uc": "#include \"main.uc\"",
+			},
+			err: ErrIncludeCycle,
+		},
+		{
+			name: "invalid argument",
+			files: map[string]string{
+				"main.uc": "#include v.h",
+			},
+			err: ErrInvalidDirective,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processFiles(t, tt.files, tt.includePaths)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n---\n") != strings.Join(tt.want, "\n---\n") {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package preprocessor
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// errUnterminatedArgs is returned when the argument list of a macro invocation doesn't end on the line
+var errUnterminatedArgs = fmt.Errorf("%w: unterminated argument list", ErrMacroArguments)
+
+// macro is a single #define. Object-like macros have params set to nil.
+type macro struct {
+	name   string
+	params []string
+	body   []token
+}
+
+func (m *macro) isFunctionLike() bool {
+	return m.params != nil
+}
+
+func (m *macro) paramIndex(name string) int {
+	for i, param := range m.params {
+		if param == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// parseDefine parses the part of a #define directive that follows the directive name
+func parseDefine(tokens []token) (*macro, error) {
+	i := skipBlank(tokens, 0)
+	if i >= len(tokens) || tokens[i].kind != tokenIdent {
+		return nil, fmt.Errorf("%w: macro name expected", ErrInvalidDirective)
+	}
+	m := &macro{name: tokens[i].text}
+	i++
+
+	// A function-like macro has its parameter list immediately after the name
+	if i < len(tokens) && tokens[i].is(tokenPunct, "(") {
+		m.params = []string{}
+		i = skipBlank(tokens, i+1)
+		if i < len(tokens) && tokens[i].is(tokenPunct, ")") {
+			i++
+		} else {
+			for {
+				if i >= len(tokens) || tokens[i].kind != tokenIdent {
+					return nil, fmt.Errorf("%w: parameter name expected in macro %s", ErrInvalidDirective, m.name)
+				}
+				if m.paramIndex(tokens[i].text) >= 0 {
+					return nil, fmt.Errorf("%w: duplicate parameter %s in macro %s", ErrInvalidDirective, tokens[i].text, m.name)
+				}
+				m.params = append(m.params, tokens[i].text)
+				i = skipBlank(tokens, i+1)
+				if i < len(tokens) && tokens[i].is(tokenPunct, ")") {
+					i++
+					break
+				}
+				if i >= len(tokens) || !tokens[i].is(tokenPunct, ",") {
+					return nil, fmt.Errorf("%w: expected ',' or ')' in macro %s", ErrInvalidDirective, m.name)
+				}
+				i = skipBlank(tokens, i+1)
+			}
+		}
+	}
+
+	m.body = generated(trim(stripComments(tokens[i:])))
+	return m, nil
+}
+
+// stripComments replaces comments with a single space
+func stripComments(tokens []token) []token {
+	stripped := []token{}
+	for _, t := range tokens {
+		if t.kind == tokenComment {
+			t = token{tokenSpace, " ", t.col, t.expanded}
+		}
+		stripped = append(stripped, t)
+	}
+
+	return stripped
+}
+
+// expand performs macro expansion of the tokens.
+// Macros listed in disabled are not expanded to prevent infinite recursion.
+func (pp *Preprocessor) expand(tokens []token, disabled map[string]bool) ([]token, error) {
+	out := []token{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		m, found := pp.macros[t.text]
+		if t.kind != tokenIdent || !found || disabled[t.text] {
+			out = append(out, t)
+			continue
+		}
+
+		var replacement []token
+		if m.isFunctionLike() {
+			// Function-like macro name that is not followed by an argument list is not expanded
+			open := skipBlank(tokens, i+1)
+			if open >= len(tokens) || !tokens[open].is(tokenPunct, "(") {
+				out = append(out, t)
+				continue
+			}
+
+			args, end, err := collectArgs(tokens, open)
+			if err != nil {
+				return nil, fmt.Errorf("%w: macro %s", err, m.name)
+			}
+			if len(args) == 1 && len(m.params) == 0 && len(trim(args[0])) == 0 {
+				args = nil
+			}
+			if len(args) != len(m.params) {
+				return nil, fmt.Errorf("%w: macro %s expects %d arguments, got %d", ErrMacroArguments, m.name, len(m.params), len(args))
+			}
+
+			replacement, err = pp.substitute(m, args, disabled)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		} else {
+			replacement = m.body
+		}
+
+		expanded, err := pp.expand(replacement, withDisabled(disabled, m.name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expanded {
+			if e.col < 0 {
+				e.col = t.col
+			}
+			out = append(out, e)
+		}
+	}
+
+	return out, nil
+}
+
+// collectArgs collects the arguments of a macro invocation.
+// open is the index of the opening parenthesis, the index of the closing one is returned.
+func collectArgs(tokens []token, open int) ([][]token, int, error) {
+	args := [][]token{}
+	cur := []token{}
+	depth := 0
+	for i := open + 1; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is(tokenPunct, "("):
+			depth++
+		case t.is(tokenPunct, ")") && depth > 0:
+			depth--
+		case t.is(tokenPunct, ")"):
+			return append(args, cur), i, nil
+		case t.is(tokenPunct, ",") && depth == 0:
+			args = append(args, cur)
+			cur = []token{}
+			continue
+		}
+		cur = append(cur, t)
+	}
+
+	return nil, 0, errUnterminatedArgs
+}
+
+// substitute replaces parameters in the body of the macro with the arguments,
+// handling the # (stringize) and ## (paste) operators.
+func (pp *Preprocessor) substitute(m *macro, args [][]token, disabled map[string]bool) ([]token, error) {
+	out := []token{}
+	body := m.body
+	for i := 0; i < len(body); i++ {
+		t := body[i]
+
+		// #param turns the argument into a string literal
+		if t.is(tokenPunct, "#") {
+			next := skipBlank(body, i+1)
+			if next < len(body) && body[next].kind == tokenIdent && m.paramIndex(body[next].text) >= 0 {
+				raw := join(trim(args[m.paramIndex(body[next].text)]))
+				out = append(out, token{tokenString, strconv.Quote(raw), -1, true})
+				i = next
+				continue
+			}
+		}
+
+		// a ## b glues the last token on the left to the first token on the right
+		if t.is(tokenPunct, "##") {
+			next := skipBlank(body, i+1)
+			out = trim(out)
+			if len(out) == 0 || next >= len(body) {
+				return nil, fmt.Errorf("%w: '##' cannot appear at either end of macro %s", ErrInvalidDirective, m.name)
+			}
+			right := []token{body[next]}
+			if idx := m.paramIndex(body[next].text); body[next].kind == tokenIdent && idx >= 0 {
+				right = trim(args[idx])
+			}
+			if len(right) > 0 {
+				pasted := generated(tokenize(out[len(out)-1].text + right[0].text))
+				out = append(out[:len(out)-1], pasted...)
+				out = append(out, right[1:]...)
+			}
+			i = next
+			continue
+		}
+
+		if idx := m.paramIndex(t.text); t.kind == tokenIdent && idx >= 0 {
+			// Operand of ## is pasted unexpanded
+			next := skipBlank(body, i+1)
+			if next < len(body) && body[next].is(tokenPunct, "##") {
+				out = append(out, trim(args[idx])...)
+				continue
+			}
+
+			expanded, err := pp.expand(trim(args[idx]), disabled)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+			continue
+		}
+
+		out = append(out, t)
+	}
+
+	return out, nil
+}
+
+func withDisabled(disabled map[string]bool, name string) map[string]bool {
+	newDisabled := make(map[string]bool, len(disabled)+1)
+	for k, v := range disabled {
+		newDisabled[k] = v
+	}
+	newDisabled[name] = true
+
+	return newDisabled
+}
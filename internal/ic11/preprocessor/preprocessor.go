@@ -0,0 +1,488 @@
+package preprocessor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+)
+
+var ErrInvalidDirective = errors.New("invalid preprocessor directive")
+var ErrUnknownDirective = errors.New("unknown preprocessor directive")
+var ErrUnbalancedConditional = errors.New("unbalanced conditional directive")
+var ErrMacroArguments = errors.New("invalid macro arguments")
+var ErrInvalidExpression = errors.New("invalid preprocessor expression")
+var ErrIncludeNotFound = errors.New("included file not found")
+var ErrIncludeCycle = errors.New("include cycle")
+
+// Config configures the preprocessor
+type Config struct {
+	// Defines are the predefined macros, as with -D NAME=value
+	Defines map[string]string
+	// IncludePaths are the directories searched for included files, as with -I
+	IncludePaths []string
+}
+
+// Unit is a single preprocessed source file
+type Unit struct {
+	Name string
+	Text string
+	// Source is the text of the file before preprocessing
+	Source string
+	// origins maps the columns of lines changed by macro expansion, or joined with backslashes, to the source
+	origins map[int][]origin
+	// lineOffsets are the offsets of the lines of Source
+	lineOffsets []int
+}
+
+// origin is the position in the source a column of a preprocessed line comes from
+type origin struct {
+	line, column int
+	// exact is false for text a macro expanded to, which comes from the macro invocation as a whole
+	exact bool
+}
+
+// Original maps a position in Text to the position in Source it comes from.
+// Positions in the text a macro expanded to map to the macro invocation, and exact is false for them.
+func (u Unit) Original(pos lexer.Position) (lexer.Position, bool) {
+	columns, found := u.origins[pos.Line]
+	if !found || pos.Column < 1 {
+		return pos, true
+	}
+
+	// Columns past the end of the line are past the end of the source line as well
+	o := columns[len(columns)-1]
+	if pos.Column <= len(columns) {
+		o = columns[pos.Column-1]
+	} else if o.exact {
+		o.column += pos.Column - len(columns)
+	}
+	pos.Line, pos.Column = o.line, o.column
+	if pos.Line <= len(u.lineOffsets) {
+		pos.Offset = u.lineOffsets[pos.Line-1] + pos.Column - 1
+	}
+
+	return pos, o.exact
+}
+
+// Preprocessor expands macros, evaluates conditional compilation directives and resolves includes ahead of parsing.
+// Macros defined while processing one file remain visible in the files processed after it.
+type Preprocessor struct {
+	macros       map[string]*macro
+	includePaths []string
+	// processed contains canonical paths of the files that were already processed.
+	// Every file is processed at most once, so repeated includes of the same file are no-ops.
+	processed map[string]bool
+	// includeStack contains canonical paths of the files currently being processed, used for cycle detection
+	includeStack []string
+	units        []Unit
+}
+
+// conditional tracks the state of a single #if/#ifdef/#ifndef group
+type conditional struct {
+	// parentActive is true if the code surrounding the group is compiled
+	parentActive bool
+	// active is true if the current branch of the group is compiled
+	active bool
+	// taken is true if one of the branches of the group was already compiled
+	taken    bool
+	seenElse bool
+	line     int
+}
+
+// New creates a new Preprocessor
+func New(conf Config) (*Preprocessor, error) {
+	pp := &Preprocessor{
+		macros:       make(map[string]*macro),
+		includePaths: conf.IncludePaths,
+		processed:    make(map[string]bool),
+	}
+	for name, value := range conf.Defines {
+		m, err := parseDefine(tokenize(name + " " + value))
+		if err != nil {
+			return nil, err
+		}
+		pp.macros[m.name] = m
+	}
+
+	return pp, nil
+}
+
+// Process preprocesses a single source file.
+// Included files are returned as separate units that precede the unit of the including file.
+// Directive lines and lines excluded by conditionals are replaced by empty lines,
+// so that line numbers in the output match the original source.
+func (pp *Preprocessor) Process(name string, r io.Reader) ([]Unit, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pp.units = []Unit{}
+	err = pp.processFile(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	return pp.units, nil
+}
+
+func (pp *Preprocessor) processFile(name string, src string) error {
+	// Sources without a name (e.g. not read from a file) can't be included, so they are not tracked
+	if name != "" {
+		canonical := canonicalPath(name)
+		for i, included := range pp.includeStack {
+			if included == canonical {
+				cycle := append(append([]string{}, pp.includeStack[i:]...), canonical)
+				return fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(cycle, " -> "))
+			}
+		}
+		if pp.processed[canonical] {
+			return nil
+		}
+		pp.processed[canonical] = true
+		pp.includeStack = append(pp.includeStack, canonical)
+		defer func() { pp.includeStack = pp.includeStack[:len(pp.includeStack)-1] }()
+	}
+
+	var b strings.Builder
+	conds := []*conditional{}
+	inAsm := false
+	lines := strings.Split(src, "\n")
+	origins := make(map[int][]origin)
+	for lineNo := 0; lineNo < len(lines); lineNo++ {
+		startLine := lineNo + 1
+		line := lines[lineNo]
+
+		// Backslash-newline joins physical lines into a single logical line, starts are the offsets of the
+		// physical lines in it
+		starts := []int{0}
+		for strings.HasSuffix(line, "\\") && lineNo+1 < len(lines) {
+			lineNo++
+			line = line[:len(line)-1]
+			starts = append(starts, len(line))
+			line += lines[lineNo]
+		}
+
+		var err error
+		active := len(conds) == 0 || conds[len(conds)-1].active
+		tokens := tokenize(line)
+		if !inAsm && isDirective(tokens) {
+			first := skipBlank(tokens, 0)
+			conds, err = pp.directive(name, tokens[first+1:], conds, active, startLine)
+			if err != nil {
+				return wrapLine(name, startLine, err)
+			}
+			line = ""
+		} else if active {
+			expanded, endsInAsm, err := pp.expandLine(tokens, inAsm)
+			// The arguments of a function-like macro may span lines, the following lines are then joined to the
+			// invocation as backslash-newlines join them
+			for errors.Is(err, errUnterminatedArgs) && lineNo+1 < len(lines) && !isDirective(tokenize(lines[lineNo+1])) {
+				lineNo++
+				line += " "
+				starts = append(starts, len(line))
+				line += lines[lineNo]
+				tokens = tokenize(line)
+				expanded, endsInAsm, err = pp.expandLine(tokens, inAsm)
+			}
+			if err != nil {
+				return wrapLine(name, startLine, err)
+			}
+			if text := join(expanded); text != line || len(starts) > 1 {
+				origins[startLine] = lineOrigins(expanded, len(line), startLine, starts)
+				line = text
+			}
+			inAsm = endsInAsm
+		} else {
+			if inAsm {
+				inAsm = !strings.Contains(line, "}")
+			}
+			line = ""
+		}
+		continuations := strings.Repeat("\n", lineNo+1-startLine)
+
+		b.WriteString(line)
+		b.WriteString(continuations)
+		if lineNo < len(lines)-1 {
+			b.WriteString("\n")
+		}
+	}
+
+	if len(conds) > 0 {
+		return wrapLine(name, conds[len(conds)-1].line, fmt.Errorf("%w: unterminated conditional", ErrUnbalancedConditional))
+	}
+
+	lineOffsets := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lineOffsets = append(lineOffsets, i+1)
+		}
+	}
+	pp.units = append(pp.units, Unit{Name: name, Text: b.String(), Source: src, origins: origins, lineOffsets: lineOffsets})
+	return nil
+}
+
+// lineOrigins maps the columns of an expanded logical line, and the column past its end, to the source.
+// The logical line starts at line startLine, with physical lines starting at the offsets starts.
+func lineOrigins(expanded []token, length, startLine int, starts []int) []origin {
+	physical := func(offset int, exact bool) origin {
+		n := len(starts) - 1
+		for n > 0 && starts[n] > offset {
+			n--
+		}
+		return origin{line: startLine + n, column: offset - starts[n] + 1, exact: exact}
+	}
+
+	origins := []origin{}
+	for _, t := range expanded {
+		for i := range t.text {
+			if t.expanded {
+				origins = append(origins, physical(t.col, false))
+			} else {
+				origins = append(origins, physical(t.col+i, true))
+			}
+		}
+	}
+
+	return append(origins, physical(length, true))
+}
+
+// isDirective reports whether the tokens of a line are a preprocessor directive
+func isDirective(tokens []token) bool {
+	first := skipBlank(tokens, 0)
+	return first < len(tokens) && tokens[first].is(tokenPunct, "#")
+}
+
+// expandLine expands the macros of a line. The code of inline assembly blocks is copied verbatim, whether the
+// block spans lines or not: IC10 comments start with # too, and IC10 names are not µC macros. inAsm is set when the
+// line starts in a block, the returned value when it ends in one.
+func (pp *Preprocessor) expandLine(tokens []token, inAsm bool) ([]token, bool, error) {
+	out := []token{}
+	start := 0
+	for i := 0; i < len(tokens); i++ {
+		if inAsm {
+			if tokens[i].is(tokenPunct, "}") {
+				out = append(out, tokens[start:i+1]...)
+				start, inAsm = i+1, false
+			}
+			continue
+		}
+
+		if body := asmBody(tokens, i); body >= 0 {
+			expanded, err := pp.expand(tokens[start:body+1], map[string]bool{})
+			if err != nil {
+				return nil, false, err
+			}
+			out = append(out, expanded...)
+			start, i, inAsm = body+1, body, true
+		}
+	}
+
+	if inAsm {
+		return append(out, tokens[start:]...), true, nil
+	}
+	expanded, err := pp.expand(tokens[start:], map[string]bool{})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return append(out, expanded...), false, nil
+}
+
+// asmBody returns the index of the brace opening the code of an inline assembly block that starts at tokens[i],
+// asm { or asm(a, b) {, or -1 if there is no block there
+func asmBody(tokens []token, i int) int {
+	if !tokens[i].is(tokenIdent, "asm") {
+		return -1
+	}
+
+	j := skipBlank(tokens, i+1)
+	if j < len(tokens) && tokens[j].is(tokenPunct, "(") {
+		for j++; j < len(tokens) && !tokens[j].is(tokenPunct, ")"); j++ {
+			if tokens[j].is(tokenPunct, "(") {
+				return -1
+			}
+		}
+		j = skipBlank(tokens, j+1)
+	}
+	if j < len(tokens) && tokens[j].is(tokenPunct, "{") {
+		return j
+	}
+
+	return -1
+}
+
+// wrapLine ties error to the line of the source file.
+// Errors coming from included files are already positioned and returned as is.
+func wrapLine(name string, line int, err error) error {
+	return diagnostic.Wrap(lexer.Position{Filename: name, Line: line}, err)
+}
+
+// directive executes a single preprocessor directive and returns the updated conditional stack
+func (pp *Preprocessor) directive(file string, tokens []token, conds []*conditional, active bool, line int) ([]*conditional, error) {
+	i := skipBlank(tokens, 0)
+	if i >= len(tokens) {
+		// Null directive
+		return conds, nil
+	}
+	name := tokens[i].text
+	args := tokens[i+1:]
+
+	switch name {
+	case "ifdef", "ifndef":
+		cond := &conditional{parentActive: active, line: line}
+		if active {
+			macroName, err := singleIdent(args)
+			if err != nil {
+				return nil, fmt.Errorf("#%s: %w", name, err)
+			}
+			_, defined := pp.macros[macroName]
+			cond.active = defined == (name == "ifdef")
+			cond.taken = cond.active
+		}
+		return append(conds, cond), nil
+	case "if":
+		cond := &conditional{parentActive: active, line: line}
+		if active {
+			v, err := pp.evalCondition(args)
+			if err != nil {
+				return nil, fmt.Errorf("#if: %w", err)
+			}
+			cond.active = v
+			cond.taken = v
+		}
+		return append(conds, cond), nil
+	case "elif":
+		if len(conds) == 0 {
+			return nil, fmt.Errorf("%w: #elif without #if", ErrUnbalancedConditional)
+		}
+		cond := conds[len(conds)-1]
+		if cond.seenElse {
+			return nil, fmt.Errorf("%w: #elif after #else", ErrUnbalancedConditional)
+		}
+		cond.active = false
+		if cond.parentActive && !cond.taken {
+			v, err := pp.evalCondition(args)
+			if err != nil {
+				return nil, fmt.Errorf("#elif: %w", err)
+			}
+			cond.active = v
+			cond.taken = v
+		}
+		return conds, nil
+	case "else":
+		if len(conds) == 0 {
+			return nil, fmt.Errorf("%w: #else without #if", ErrUnbalancedConditional)
+		}
+		cond := conds[len(conds)-1]
+		if cond.seenElse {
+			return nil, fmt.Errorf("%w: duplicate #else", ErrUnbalancedConditional)
+		}
+		cond.seenElse = true
+		cond.active = cond.parentActive && !cond.taken
+		cond.taken = true
+		return conds, nil
+	case "endif":
+		if len(conds) == 0 {
+			return nil, fmt.Errorf("%w: #endif without #if", ErrUnbalancedConditional)
+		}
+		return conds[:len(conds)-1], nil
+	}
+
+	// The rest of the directives are ignored in the excluded code
+	if !active {
+		return conds, nil
+	}
+
+	switch name {
+	case "include":
+		return conds, pp.include(file, args)
+	case "define":
+		m, err := parseDefine(args)
+		if err != nil {
+			return nil, err
+		}
+		pp.macros[m.name] = m
+	case "undef":
+		macroName, err := singleIdent(args)
+		if err != nil {
+			return nil, fmt.Errorf("#undef: %w", err)
+		}
+		delete(pp.macros, macroName)
+	default:
+		return nil, fmt.Errorf("%w: #%s", ErrUnknownDirective, name)
+	}
+
+	return conds, nil
+}
+
+// include resolves and processes the file referenced by an #include directive.
+// "file" is searched relative to the including file first and then in the include paths, <file> only in the include paths.
+func (pp *Preprocessor) include(from string, tokens []token) error {
+	expanded, err := pp.expand(tokens, map[string]bool{})
+	if err != nil {
+		return err
+	}
+
+	arg := strings.TrimSpace(join(stripComments(expanded)))
+	var path string
+	var searchPaths []string
+	switch {
+	case len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"':
+		path = arg[1 : len(arg)-1]
+		searchPaths = append([]string{filepath.Dir(from)}, pp.includePaths...)
+	case len(arg) >= 2 && arg[0] == '<' && arg[len(arg)-1] == '>':
+		path = arg[1 : len(arg)-1]
+		searchPaths = pp.includePaths
+	default:
+		return fmt.Errorf("%w: #include expects \"file\" or <file>", ErrInvalidDirective)
+	}
+
+	for _, dir := range searchPaths {
+		candidate := path
+		if !filepath.IsAbs(path) {
+			candidate = filepath.Join(dir, path)
+		}
+
+		src, err := os.ReadFile(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return pp.processFile(candidate, string(src))
+	}
+
+	return fmt.Errorf("%w: %s", ErrIncludeNotFound, path)
+}
+
+// canonicalPath returns the path that uniquely identifies a file for the purpose of once-semantics
+func canonicalPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+
+	return abs
+}
+
+// singleIdent expects tokens to contain exactly one identifier
+func singleIdent(tokens []token) (string, error) {
+	trimmed := trim(tokens)
+	if len(trimmed) != 1 || trimmed[0].kind != tokenIdent {
+		return "", fmt.Errorf("%w: macro name expected", ErrInvalidDirective)
+	}
+
+	return trimmed[0].text, nil
+}
@@ -0,0 +1,255 @@
+package preprocessor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// preprocess preprocesses src with the predefined macros and returns its text
+func preprocess(t *testing.T, defines map[string]string, src string) (string, error) {
+	t.Helper()
+	pp, err := New(Config{Defines: defines})
+	if err != nil {
+		return "", err
+	}
+	units, err := pp.Process("main.uc", strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+
+	return units[len(units)-1].Text, nil
+}
+
+func TestMacros(t *testing.T) {
+	tests := []struct {
+		name    string
+		defines map[string]string
+		src     string
+		want    string
+	}{
+		{
+			name: "object-like",
+			src:  "#define N 4\nx = N + N1;",
+			want: "\nx = 4 + N1;",
+		},
+		{
+			name: "nested",
+			src:  "#define A B + 1\n#define B 2\nx = A;",
+			want: "\n\nx = 2 + 1;",
+		},
+		{
+			name: "recursion is not expanded",
+			src:  "#define A A + 1\nx = A;",
+			want: "\nx = A + 1;",
+		},
+		{
+			name: "function-like",
+			src:  "#define SUM(a, b) ((a) + (b))\nx = SUM(f(1, 2), y);",
+			want: "\nx = ((f(1, 2)) + (y));",
+		},
+		{
+			name: "function-like name without arguments",
+			src:  "#define F(a) a\nx = F;",
+			want: "\nx = F;",
+		},
+		{
+			name: "no parameters",
+			src:  "#define F() 1\nx = F();",
+			want: "\nx = 1;",
+		},
+		{
+			name: "stringize",
+			src:  "#define S(a) #a\nx = S( load(d0) );",
+			want: "\nx = \"load(d0)\";",
+		},
+		{
+			name: "paste",
+			src:  "#define CAT(a, b) a ## b\nx = CAT(foo, 1) + CAT(d, 0);",
+			want: "\nx = foo1 + d0;",
+		},
+		{
+			name: "operand of paste is not expanded",
+			src:  "#define N 4\n#define CAT(a, b) a ## b\nx = CAT(N, N);",
+			want: "\n\nx = NN;",
+		},
+		{
+			name: "comments in bodies",
+			src:  "#define N 4 // four\nx = N;",
+			want: "\nx = 4;",
+		},
+		{
+			name: "undef",
+			src:  "#define N 4\n#undef N\nx = N;",
+			want: "\n\nx = N;",
+		},
+		{
+			name: "backslash continuation",
+			src:  "#define SUM(a, b) \\\n\t((a) + (b))\nx = SUM(1, 2);",
+			want: "\n\nx = ((1) + (2));",
+		},
+		{
+			name: "call split across lines",
+			src:  "#define SUM(a, b) ((a) + (b))\nx = SUM(1,\n\t2) + SUM(\n3,\n4);\ny = 1;",
+			want: "\nx = ((1) + (2)) + ((3) + (4));\n\n\n\ny = 1;",
+		},
+		{
+			name:    "predefined",
+			defines: map[string]string{"N": "4", "F(a)": "a * N"},
+			src:     "x = F(2);",
+			want:    "x = 2 * 4;",
+		},
+		{
+			name:    "predefined without value",
+			defines: map[string]string{"DEBUG": ""},
+			src:     "#ifdef DEBUG\nx = 1;\n#endif",
+			want:    "\nx = 1;\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := preprocess(t, tt.defines, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineAsm(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "single line block",
+			src:  "#define db d1\nasm(t) { s db Setting %0 } store(db, \"On\", 1);",
+			want: "\nasm(t) { s db Setting %0 } store(d1, \"On\", 1);",
+		},
+		{
+			name: "block spanning lines",
+			src:  "#define db d1\nasm(t) {\n# comment\ns db Setting %0\n} store(db, \"On\", 1);",
+			want: "\nasm(t) {\n# comment\ns db Setting %0\n} store(d1, \"On\", 1);",
+		},
+		{
+			name: "operands are expanded",
+			src:  "#define T t\nasm(T) { move %0 1 }",
+			want: "\nasm(t) { move %0 1 }",
+		},
+		{
+			name: "string form",
+			src:  "#define db d1\nasm(\"s db Setting %0\", t);",
+			want: "\nasm(\"s db Setting %0\", t);",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := preprocess(t, nil, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"if", "#if 1 + 1 == 2\na\n#endif", "\na\n"},
+		{"if false", "#if 2 < 1\na\n#endif", "\n\n"},
+		{"else", "#if 0\na\n#else\nb\n#endif", "\n\n\nb\n"},
+		{"elif", "#define V 2\n#if V == 1\na\n#elif V == 2\nb\n#elif V == 2\nc\n#else\nd\n#endif", "\n\n\n\nb\n\n\n\n\n"},
+		{"defined", "#define A\n#if defined(A) && !defined B\na\n#endif", "\n\na\n"},
+		{"ifndef", "#ifndef A\na\n#endif", "\na\n"},
+		{"nested in excluded group", "#if 0\n#if 1\na\n#endif\n#else\nb\n#endif", "\n\n\n\n\nb\n"},
+		{"undefined names are 0", "#if UNDEFINED\na\n#endif", "\n\n"},
+		{"ternary and shifts", "#if (1 << 3 >> 1) == 4 ? 1 : 0\na\n#endif", "\na\n"},
+		{"excluded directives", "#if 0\n#define A 1\n#error\n#endif\nA", "\n\n\n\nA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := preprocess(t, nil, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  error
+	}{
+		{"missing argument", "#define F(a, b) a\nF(1);", ErrMacroArguments},
+		{"unterminated call", "#define F(a) a\nF(1,\n2", ErrMacroArguments},
+		{"directive in call", "#define F(a) a\nF(1,\n#define X\n2);", ErrMacroArguments},
+		{"duplicate parameter", "#define F(a, a) a", ErrInvalidDirective},
+		{"paste at the end", "#define F(a) a ##\nF(1);", ErrInvalidDirective},
+		{"unknown directive", "#pragma once", ErrUnknownDirective},
+		{"endif without if", "#endif", ErrUnbalancedConditional},
+		{"else after else", "#if 1\n#else\n#else\n#endif", ErrUnbalancedConditional},
+		{"unterminated if", "#if 1\na", ErrUnbalancedConditional},
+		{"invalid expression", "#if 1 +\n#endif", ErrInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := preprocess(t, nil, tt.src)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOriginal(t *testing.T) {
+	src := "#define SUM(a, b) ((a) + (b))\nx = SUM(1,\n\ty) + z;"
+	pp, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	units, err := pp.Process("main.uc", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := units[0]
+
+	// x = ((1) + (y)) + z;
+	tests := []struct {
+		column       int
+		line, origin int
+		exact        bool
+	}{
+		{1, 2, 1, true},  // x
+		{5, 2, 5, false}, // ( of the expansion
+		{13, 3, 2, true}, // y
+		{19, 3, 7, true}, // z
+	}
+	for _, tt := range tests {
+		pos, exact := unit.Original(lexer.Position{Filename: "main.uc", Line: 2, Column: tt.column})
+		if pos.Line != tt.line || pos.Column != tt.origin || exact != tt.exact {
+			t.Errorf("column %d: got %d:%d exact %t, want %d:%d exact %t", tt.column, pos.Line, pos.Column, exact,
+				tt.line, tt.origin, tt.exact)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package preprocessor
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenPunct
+	tokenSpace
+	tokenComment
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// col is the offset of the token in its logical line. Tokens of macro bodies take the offset of the macro
+	// invocation once expanded, -1 until then, and have expanded set.
+	col      int
+	expanded bool
+}
+
+// multiCharPuncts are the punctuators that are recognized as a single token by the preprocessor
+var multiCharPuncts = []string{"##", "&&", "||", "==", "!=", "<=", ">=", "<<", ">>"}
+
+// tokenize splits a logical source line into preprocessor tokens.
+// Concatenating texts of the returned tokens always yields the original line.
+func tokenize(line string) []token {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			for i < len(line) && strings.IndexByte(" \t\r\f\v", line[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, token{tokenSpace, line[start:i], start, false})
+		case strings.HasPrefix(line[i:], "//"):
+			i = len(line)
+			tokens = append(tokens, token{tokenComment, line[start:i], start, false})
+		case strings.HasPrefix(line[i:], "/*"):
+			end := strings.Index(line[i+2:], "*/")
+			if end < 0 {
+				i = len(line)
+			} else {
+				i = i + 2 + end + 2
+			}
+			tokens = append(tokens, token{tokenComment, line[start:i], start, false})
+		case c == '"':
+			i++
+			for i < len(line) && line[i] != '"' {
+				i++
+			}
+			if i < len(line) {
+				i++
+			}
+			tokens = append(tokens, token{tokenString, line[start:i], start, false})
+		case isIdentStart(c):
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, line[start:i], start, false})
+		case isDigit(c) || (c == '.' && i+1 < len(line) && isDigit(line[i+1])):
+			for i < len(line) && (isIdentChar(line[i]) || line[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, line[start:i], start, false})
+		default:
+			i++
+			for _, p := range multiCharPuncts {
+				if strings.HasPrefix(line[start:], p) {
+					i = start + len(p)
+					break
+				}
+			}
+			tokens = append(tokens, token{tokenPunct, line[start:i], start, false})
+		}
+	}
+
+	return tokens
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// generated marks tokens as coming from a macro, their offset is set when the macro is expanded
+func generated(tokens []token) []token {
+	marked := make([]token, len(tokens))
+	for i, t := range tokens {
+		t.col, t.expanded = -1, true
+		marked[i] = t
+	}
+
+	return marked
+}
+
+// join concatenates token texts
+func join(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
+
+// trim removes leading and trailing whitespace and comment tokens
+func trim(tokens []token) []token {
+	start, end := 0, len(tokens)
+	for start < end && tokens[start].isBlank() {
+		start++
+	}
+	for end > start && tokens[end-1].isBlank() {
+		end--
+	}
+
+	return tokens[start:end]
+}
+
+// skipBlank returns index of the first non-blank token at or after i
+func skipBlank(tokens []token, i int) int {
+	for i < len(tokens) && tokens[i].isBlank() {
+		i++
+	}
+
+	return i
+}
+
+func (t token) isBlank() bool {
+	return t.kind == tokenSpace || t.kind == tokenComment
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
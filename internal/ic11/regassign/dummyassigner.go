@@ -1,26 +1,61 @@
 package regassign
 
-import "github.com/greg2010/ic11c/internal/ic11/ir"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/greg2010/ic11c/internal/ic11/ir"
+)
+
+// MaxRegister is the last general purpose register, r16 and r17 are sp and ra
+const MaxRegister = 15
+
+var ErrOutOfRegisters = errors.New("out of registers")
 
 // DummyAssigner is a type of register assigner.
 // It assigns each variable a unique register, and never releases them.
+// Registers used directly by inline assembly blocks are never assigned.
 type DummyAssigner struct {
 	program       *ir.Program
 	assignedSoFar map[ir.IRVar]int
 	maxAssigned   int
+	clobbered     map[int]bool
 }
 
 func NewDummyAssigner(program *ir.Program) *DummyAssigner {
-	return &DummyAssigner{program: program, assignedSoFar: make(map[ir.IRVar]int), maxAssigned: 0}
+	clobbered := make(map[int]bool)
+	for _, instr := range program.Get() {
+		if asm, ok := instr.(ir.IRInlineAsm); ok {
+			for _, register := range asm.Clobbers() {
+				clobbered[register] = true
+			}
+		}
+	}
+
+	return &DummyAssigner{program: program, assignedSoFar: make(map[ir.IRVar]int), maxAssigned: 0, clobbered: clobbered}
 }
 
-func (da *DummyAssigner) GetRegister(varName ir.IRVar) int {
+func (da *DummyAssigner) GetRegister(varName ir.IRVar) (int, error) {
 	if register, found := da.assignedSoFar[varName]; found {
-		return register
+		return register, nil
+	}
+
+	for da.clobbered[da.maxAssigned] {
+		da.maxAssigned = da.maxAssigned + 1
+	}
+	if da.maxAssigned > MaxRegister {
+		reserved := 0
+		for register := range da.clobbered {
+			if register <= MaxRegister {
+				reserved++
+			}
+		}
+		return 0, fmt.Errorf("%w: no register left for %s, only r0-r%d hold variables and inline assembly uses %d of them",
+			ErrOutOfRegisters, varName, MaxRegister, reserved)
 	}
 
 	register := da.maxAssigned
 	da.assignedSoFar[varName] = register
 	da.maxAssigned = da.maxAssigned + 1
-	return register
+	return register, nil
 }
@@ -0,0 +1,46 @@
+package ic11
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+// Tokenize splits a line of IC10 code into whitespace separated tokens and the comment ending it.
+// Quoted strings, as in HASH("Structure Name"), are kept in a single token.
+func Tokenize(line string) ([]string, string, error) {
+	tokens := []string{}
+	var b strings.Builder
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case quoted:
+			b.WriteRune(r)
+		case r == '#':
+			return appendToken(tokens, &b), strings.TrimSpace(line[i:]), nil
+		case r == ' ' || r == '\t' || r == '\r':
+			tokens = appendToken(tokens, &b)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, "", fmt.Errorf("%w: unterminated string", ErrSyntax)
+	}
+
+	return appendToken(tokens, &b), "", nil
+}
+
+func appendToken(tokens []string, b *strings.Builder) []string {
+	if b.Len() > 0 {
+		tokens = append(tokens, b.String())
+		b.Reset()
+	}
+
+	return tokens
+}
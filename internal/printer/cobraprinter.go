@@ -3,6 +3,7 @@ package printer
 import (
 	"fmt"
 
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
 	"github.com/spf13/cobra"
 )
 
@@ -59,6 +60,13 @@ func (cp *CobraPrinter) PrintErrorln(i ...interface{}) {
 	cp.cmd.PrintErrf("error: %v\n", i...)
 }
 
+// PrintDiagnostics prints errors and warnings, one per line
+func (cp *CobraPrinter) PrintDiagnostics(diags diagnostic.List) {
+	for _, d := range diags {
+		cp.cmd.PrintErrln(d.String())
+	}
+}
+
 func NewCobraPrinter(cmd *cobra.Command, printVerbose bool) *CobraPrinter {
 	return &CobraPrinter{
 		cmd:     cmd,
@@ -1,5 +1,7 @@
 package printer
 
+import "github.com/greg2010/ic11c/internal/ic11/diagnostic"
+
 // Printer is a generic printer interface
 type Printer interface {
 	Print(i ...interface{})
@@ -11,4 +13,5 @@ type Printer interface {
 	PrintError(i ...interface{})
 	PrintErrorf(format string, i ...interface{})
 	PrintErrorln(i ...interface{})
+	PrintDiagnostics(diags diagnostic.List)
 }
@@ -0,0 +1,305 @@
+package printer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/greg2010/ic11c/internal/ic11/diagnostic"
+	"github.com/spf13/cobra"
+)
+
+// Diagnostics output formats
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+var ErrUnknownFormat = errors.New("unknown diagnostics format")
+
+// StructuredPrinter is a Printer that prints diagnostics as JSON or SARIF documents to the standard output,
+// so that they can be consumed by editors and CI tools. Other output is printed as by CobraPrinter, verbose logging
+// to the standard error so that it doesn't mix with the document.
+type StructuredPrinter struct {
+	*CobraPrinter
+	format string
+}
+
+// NewPrinter returns a printer for the diagnostics format
+func NewPrinter(cmd *cobra.Command, printVerbose bool, format string) (Printer, error) {
+	cp := NewCobraPrinter(cmd, printVerbose)
+	switch format {
+	case FormatText:
+		return cp, nil
+	case FormatJSON, FormatSARIF:
+		return &StructuredPrinter{CobraPrinter: cp, format: format}, nil
+	default:
+		return cp, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+func (sp *StructuredPrinter) PrintVerbose(i ...interface{}) {
+	if sp.verbose {
+		fmt.Fprint(sp.cmd.ErrOrStderr(), i...)
+	}
+}
+
+func (sp *StructuredPrinter) PrintVerbosef(format string, i ...interface{}) {
+	if sp.verbose {
+		fmt.Fprintf(sp.cmd.ErrOrStderr(), format, i...)
+	}
+}
+
+func (sp *StructuredPrinter) PrintVerboseln(i ...interface{}) {
+	if sp.verbose {
+		fmt.Fprintln(sp.cmd.ErrOrStderr(), i...)
+	}
+}
+
+// PrintError and friends print errors that are not tied to the source as diagnostics without a position
+func (sp *StructuredPrinter) PrintError(i ...interface{}) {
+	sp.printMessage(fmt.Sprint(i...))
+}
+
+func (sp *StructuredPrinter) PrintErrorf(format string, i ...interface{}) {
+	sp.printMessage(fmt.Sprintf(format, i...))
+}
+
+func (sp *StructuredPrinter) PrintErrorln(i ...interface{}) {
+	sp.printMessage(fmt.Sprint(i...))
+}
+
+func (sp *StructuredPrinter) printMessage(message string) {
+	sp.PrintDiagnostics(diagnostic.List{{Severity: diagnostic.Error, Err: errors.New(message)}})
+}
+
+func (sp *StructuredPrinter) PrintDiagnostics(diags diagnostic.List) {
+	var doc any
+	if sp.format == FormatSARIF {
+		doc = sarifLog(diags)
+	} else {
+		records := []jsonRecord{}
+		for _, d := range diags {
+			records = append(records, newJSONRecord(d))
+		}
+		doc = records
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		sp.CobraPrinter.PrintErrorln(err)
+		return
+	}
+	fmt.Fprintln(sp.cmd.OutOrStdout(), string(out))
+}
+
+// JSON format
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonNote struct {
+	Message string    `json:"message"`
+	File    string    `json:"file,omitempty"`
+	Range   jsonRange `json:"range"`
+}
+
+type jsonFix struct {
+	Description string    `json:"description"`
+	File        string    `json:"file,omitempty"`
+	Range       jsonRange `json:"range"`
+	Text        string    `json:"text"`
+}
+
+type jsonRecord struct {
+	Severity string     `json:"severity"`
+	Code     string     `json:"code,omitempty"`
+	Message  string     `json:"message"`
+	File     string     `json:"file,omitempty"`
+	Range    jsonRange  `json:"range"`
+	Notes    []jsonNote `json:"notes,omitempty"`
+	Fixes    []jsonFix  `json:"fixes,omitempty"`
+}
+
+// newRange returns the range between start and end. The range is empty if the end is not known.
+func newRange(start, end lexer.Position) jsonRange {
+	if end.Line == 0 {
+		end = start
+	}
+
+	return jsonRange{
+		Start: jsonPosition{Line: start.Line, Column: start.Column},
+		End:   jsonPosition{Line: end.Line, Column: end.Column},
+	}
+}
+
+func newJSONRecord(d *diagnostic.Diagnostic) jsonRecord {
+	r := jsonRecord{
+		Severity: d.Severity.String(),
+		Code:     d.Code,
+		Message:  d.Err.Error(),
+		File:     d.Pos.Filename,
+		Range:    newRange(d.Pos, d.End),
+	}
+	for _, note := range d.Notes {
+		r.Notes = append(r.Notes, jsonNote{Message: note.Message, File: note.Pos.Filename, Range: newRange(note.Pos, note.Pos)})
+	}
+	for _, fix := range d.Fixes {
+		r.Fixes = append(r.Fixes, jsonFix{Description: fix.Description, File: fix.Pos.Filename, Range: newRange(fix.Pos, fix.End), Text: fix.Text})
+	}
+
+	return r
+}
+
+// SARIF 2.1.0 format, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifDocument struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+func sarifLog(diags diagnostic.List) sarifDocument {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: "ic11c", Rules: []sarifRule{}}}, Results: []sarifResult{}}
+	rules := map[string]bool{}
+	for _, d := range diags {
+		ruleID := d.Code
+		if ruleID == "" {
+			ruleID = "error"
+		}
+		if !rules[ruleID] {
+			rules[ruleID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: ruleID})
+		}
+
+		result := sarifResult{RuleID: ruleID, Level: d.Severity.String(), Message: sarifMessage{Text: d.Err.Error()}}
+		if loc := newSarifLocation(d.Pos, d.End); loc != nil {
+			result.Locations = append(result.Locations, sarifLocation{PhysicalLocation: loc})
+		}
+		for _, note := range d.Notes {
+			result.RelatedLocations = append(result.RelatedLocations, sarifLocation{
+				PhysicalLocation: newSarifLocation(note.Pos, note.Pos),
+				Message:          &sarifMessage{Text: note.Message},
+			})
+		}
+		for _, fix := range d.Fixes {
+			result.Fixes = append(result.Fixes, sarifFix{
+				Description: sarifMessage{Text: fix.Description},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: sarifArtifactLocation{URI: fix.Pos.Filename},
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   *newSarifRegion(fix.Pos, fix.End),
+						InsertedContent: sarifMessage{Text: fix.Text},
+					}},
+				}},
+			})
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return sarifDocument{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
+
+// newSarifLocation returns the location of a range in a file, nil if the file is not known
+func newSarifLocation(start, end lexer.Position) *sarifPhysicalLocation {
+	if start.Filename == "" {
+		return nil
+	}
+
+	loc := &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: start.Filename}}
+	if start.Line > 0 {
+		loc.Region = newSarifRegion(start, end)
+	}
+
+	return loc
+}
+
+func newSarifRegion(start, end lexer.Position) *sarifRegion {
+	r := newRange(start, end)
+	return &sarifRegion{
+		StartLine:   r.Start.Line,
+		StartColumn: r.Start.Column,
+		EndLine:     r.End.Line,
+		EndColumn:   r.End.Column,
+	}
+}
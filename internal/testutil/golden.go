@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate golden files in testdata")
+
+// CheckGolden compares got with the golden file name, or writes it when tests are run with -update.
+// An empty output has no golden file.
+func CheckGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	if *update {
+		var err error
+		if got == "" {
+			err = os.Remove(name)
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			err = os.WriteFile(name, []byte(got), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, run go test with -update to regenerate it\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
@@ -0,0 +1,28 @@
+// Package testutil holds helpers shared by the tests of the compiler packages
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// AddSeeds seeds the fuzzing corpus with the files matching the patterns
+func AddSeeds(f *testing.F, patterns ...string) {
+	for _, pattern := range patterns {
+		sources, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if len(sources) == 0 {
+			f.Fatalf("no seeds match %s", pattern)
+		}
+		for _, source := range sources {
+			src, err := os.ReadFile(source)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(src))
+		}
+	}
+}